@@ -93,6 +93,56 @@ func (a *Anko) GetInt(symbol string) int {
 	return 0
 }
 
+// GetFloat gets float value from symbol, returns golang default value if not
+// found. Integer values are converted to float.
+func (a *Anko) GetFloat(symbol string) float64 {
+	v, err := a.Execute(symbol)
+	if err != nil {
+		return 0
+	}
+
+	switch val := v.(type) {
+	case float64:
+		return val
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	}
+
+	return 0
+}
+
+// GetFloats gets a list of numbers from symbol, returns nil if not found or
+// if any of the values is not a number.
+func (a *Anko) GetFloats(symbol string) []float64 {
+	v, err := a.Execute(symbol)
+	if err != nil {
+		return nil
+	}
+
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	floats := make([]float64, 0, len(list))
+	for _, item := range list {
+		switch val := item.(type) {
+		case float64:
+			floats = append(floats, val)
+		case int:
+			floats = append(floats, float64(val))
+		case int64:
+			floats = append(floats, float64(val))
+		default:
+			return nil
+		}
+	}
+
+	return floats
+}
+
 // GetString gets string value from symbol, returns golang default value if not
 // found.
 func (a *Anko) GetString(symbol string) string {
@@ -79,6 +79,40 @@ func TestGetInt(t *testing.T) {
 	assert.Equal(t, expect, val)
 }
 
+func TestGetFloat(t *testing.T) {
+	a := NewAnko()
+
+	_, err := a.Execute(`
+module S {
+	x = -2.5
+	y = 3
+}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, -2.5, a.GetFloat("S.x"))
+	assert.Equal(t, 3.0, a.GetFloat("S.y"))
+	assert.Equal(t, 0.0, a.GetFloat("S.z"))
+}
+
+func TestGetFloats(t *testing.T) {
+	a := NewAnko()
+
+	_, err := a.Execute(`
+module S {
+	x = [1, -2.5, 0]
+	y = [1, "a"]
+}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, []float64{1, -2.5, 0}, a.GetFloats("S.x"))
+	assert.Nil(t, a.GetFloats("S.y"))
+	assert.Nil(t, a.GetFloats("S.z"))
+}
+
 func TestGetString(t *testing.T) {
 	expect := "bruhh"
 	a := NewAnko()
@@ -0,0 +1,202 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// attachInterval is how often the attached client asks the daemon for its
+// status
+const attachInterval = time.Second
+
+const attachUsage = `Usage: gomu attach [-socket path]
+
+Shows the status of a running daemon and controls it with the keys
+  space  play or pause
+  n      skip to the next song in the queue
+  p      restart the song or go back to the previous one
+  a      add songs, directories or stream urls to the queue
+  q      quit, the daemon keeps playing
+`
+
+// attachClient is a tui showing the status of a daemon, the keys are sent as
+// commands over the control socket
+type attachClient struct {
+	app        *tview.Application
+	socketPath string
+	status     *tview.TextView
+	input      *tview.InputField
+	message    *tview.TextView
+	// state of the daemon when it was last asked, only read and written on
+	// the ui goroutine
+	state string
+}
+
+// Gets the command line sent to the daemon for the key, it is empty if the
+// key is not a command
+func attachCommand(key rune, state string) string {
+	switch key {
+	case ' ':
+		if state == "playing" {
+			return "pause"
+		}
+		return "play"
+	case 'n':
+		return "next"
+	case 'p':
+		return "previous"
+	}
+	return ""
+}
+
+// runAttach executes `gomu attach` and returns the exit code
+func runAttach(args []string) int {
+
+	flags := flag.NewFlagSet("attach", flag.ExitOnError)
+	socket := flags.String("socket", defaultSocketPath(), "Specify control socket")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), attachUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	c := &attachClient{
+		app:        tview.NewApplication(),
+		socketPath: expandFilePath(*socket),
+	}
+
+	// fails right away when there is no daemon to attach to
+	res, err := sendCtl(c.socketPath, "status")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to gomu daemon:", err)
+		return 1
+	}
+
+	c.status = tview.NewTextView()
+	c.status.SetBorder(true).SetTitle(" gomu ")
+	c.status.SetInputCapture(c.handleKey)
+
+	c.input = tview.NewInputField().SetLabel("enqueue: ")
+	c.input.SetDoneFunc(c.enqueue)
+
+	c.message = tview.NewTextView()
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(c.status, 0, 1, true).
+		AddItem(c.input, 1, 0, false).
+		AddItem(c.message, 1, 0, false)
+
+	c.render(res, nil)
+
+	stop := make(chan struct{})
+	go c.poll(stop)
+	defer close(stop)
+
+	if err := c.app.SetRoot(layout, true).Run(); err != nil {
+		logError(err)
+		return 1
+	}
+
+	return 0
+}
+
+// Asks the daemon for its status until stop is closed
+func (c *attachClient) poll(stop chan struct{}) {
+
+	ticker := time.NewTicker(attachInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		res, err := sendCtl(c.socketPath, "status")
+		c.app.QueueUpdateDraw(func() {
+			c.render(res, err)
+		})
+	}
+}
+
+// Sends the command without blocking the ui, errors are shown below the
+// status
+func (c *attachClient) send(line string) {
+	go func() {
+		res, err := sendCtl(c.socketPath, line)
+		c.app.QueueUpdateDraw(func() {
+			c.message.SetText(res.Error)
+			c.render(res, err)
+		})
+	}()
+}
+
+// Shows the status of the daemon, it must be called on the ui goroutine
+func (c *attachClient) render(res ctlResponse, err error) {
+
+	if err != nil {
+		c.state = ""
+		c.status.SetText(fmt.Sprintf("unable to connect to gomu daemon: %v", err))
+		return
+	}
+
+	if res.Status == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	printStatus(&buf, *res.Status)
+
+	c.state = res.Status.State
+	c.status.SetText(buf.String())
+}
+
+func (c *attachClient) handleKey(e *tcell.EventKey) *tcell.EventKey {
+
+	switch e.Rune() {
+	case 'q':
+		c.app.Stop()
+		return nil
+	case 'a':
+		c.app.SetFocus(c.input)
+		return nil
+	}
+
+	if line := attachCommand(e.Rune(), c.state); line != "" {
+		c.send(line)
+		return nil
+	}
+
+	return e
+}
+
+// Enqueues the path typed in the input field, escape goes back to the status
+func (c *attachClient) enqueue(key tcell.Key) {
+
+	songPath := c.input.GetText()
+
+	c.input.SetText("")
+	c.app.SetFocus(c.status)
+
+	if key != tcell.KeyEnter || songPath == "" {
+		return
+	}
+
+	// the daemon may run in another directory
+	if !player.IsStream(songPath) {
+		songPath = expandFilePath(songPath)
+	}
+
+	c.send("enqueue " + songPath)
+}
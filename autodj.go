@@ -0,0 +1,302 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// strategies of the auto DJ
+const (
+	// any song of the library
+	autoDJRandom = "random"
+	// songs of the same artist, album or genre as the last song
+	autoDJArtist = "artist"
+	autoDJAlbum  = "album"
+	autoDJGenre  = "genre"
+	// songs which have not been played for the longest time
+	autoDJLeastRecent = "least_recent"
+	// songs played often are more likely to be picked
+	autoDJPlayCount = "play_count"
+)
+
+// autoDJ picks songs from the library to append when the queue runs low
+type autoDJ struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	// songs picked so far, oldest first, they are not picked again within the
+	// repeat window even if they have not been played yet
+	picked []string
+}
+
+// autoDJCandidate is a song of the library which the auto DJ may pick
+type autoDJCandidate struct {
+	path  string
+	entry *libraryEntry
+	stats songStats
+}
+
+func newAutoDJ() *autoDJ {
+	return &autoDJ{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Picks the next song. played are the paths of the played songs, the last
+// played first, and excluded are the songs which are queued or playing. Songs
+// played or picked within the last window songs are avoided unless there is
+// nothing else to pick.
+func (dj *autoDJ) pick(
+	strategy string,
+	candidates []autoDJCandidate,
+	last *libraryEntry,
+	played []string,
+	excluded map[string]bool,
+	window int,
+) (autoDJCandidate, bool) {
+
+	dj.mu.Lock()
+	defer dj.mu.Unlock()
+
+	recent := make(map[string]bool)
+	for i := 0; i < window && i < len(played); i++ {
+		recent[played[i]] = true
+	}
+	for i := len(dj.picked) - 1; i >= 0 && i >= len(dj.picked)-window; i-- {
+		recent[dj.picked[i]] = true
+	}
+
+	pool := filterCandidates(candidates, func(c autoDJCandidate) bool {
+		return !excluded[c.path] && !recent[c.path]
+	})
+
+	// repeats are better than silence
+	if len(pool) == 0 {
+		pool = filterCandidates(candidates, func(c autoDJCandidate) bool {
+			return !excluded[c.path]
+		})
+	}
+	if len(pool) == 0 {
+		pool = candidates
+	}
+	if len(pool) == 0 {
+		return autoDJCandidate{}, false
+	}
+
+	var picked autoDJCandidate
+
+	switch strategy {
+	case autoDJArtist, autoDJAlbum, autoDJGenre:
+		// falls back to any song when the last song has no such tag or when
+		// there is no other song sharing it
+		if last != nil {
+			value := strings.TrimSpace(autoDJField(strategy, last))
+			similar := filterCandidates(pool, func(c autoDJCandidate) bool {
+				return value != "" && strings.EqualFold(
+					strings.TrimSpace(autoDJField(strategy, c.entry)), value,
+				)
+			})
+			if len(similar) > 0 {
+				pool = similar
+			}
+		}
+		picked = pool[dj.rand.Intn(len(pool))]
+
+	case autoDJLeastRecent:
+		picked = dj.pickLeastRecent(pool)
+
+	case autoDJPlayCount:
+		picked = dj.pickWeighted(pool)
+
+	default:
+		picked = pool[dj.rand.Intn(len(pool))]
+	}
+
+	dj.picked = append(dj.picked, picked.path)
+	if len(dj.picked) > window {
+		dj.picked = dj.picked[len(dj.picked)-window:]
+	}
+
+	return picked, true
+}
+
+// Picks one of the songs played the longest time ago, songs which have never
+// been played come first
+func (dj *autoDJ) pickLeastRecent(pool []autoDJCandidate) autoDJCandidate {
+
+	var oldest []autoDJCandidate
+
+	for _, c := range pool {
+		switch {
+		case len(oldest) == 0 || c.stats.LastPlayed < oldest[0].stats.LastPlayed:
+			oldest = []autoDJCandidate{c}
+		case c.stats.LastPlayed == oldest[0].stats.LastPlayed:
+			oldest = append(oldest, c)
+		}
+	}
+
+	return oldest[dj.rand.Intn(len(oldest))]
+}
+
+// Picks a song with a chance growing with the number of times it has been
+// played
+func (dj *autoDJ) pickWeighted(pool []autoDJCandidate) autoDJCandidate {
+
+	total := 0
+	for _, c := range pool {
+		total += 1 + c.stats.Plays
+	}
+
+	n := dj.rand.Intn(total)
+	for _, c := range pool {
+		n -= 1 + c.stats.Plays
+		if n < 0 {
+			return c
+		}
+	}
+
+	return pool[len(pool)-1]
+}
+
+func autoDJField(strategy string, entry *libraryEntry) string {
+	switch strategy {
+	case autoDJArtist:
+		return entry.Artist
+	case autoDJAlbum:
+		return entry.Album
+	case autoDJGenre:
+		return entry.Genre
+	}
+	return ""
+}
+
+func filterCandidates(
+	candidates []autoDJCandidate, keep func(c autoDJCandidate) bool,
+) []autoDJCandidate {
+
+	var kept []autoDJCandidate
+	for _, c := range candidates {
+		if keep(c) {
+			kept = append(kept, c)
+		}
+	}
+
+	return kept
+}
+
+// Gets the playable songs of the library sorted by path
+func autoDJCandidates() []autoDJCandidate {
+
+	songs := gomu.library.songs()
+
+	candidates := make([]autoDJCandidate, 0, len(songs))
+	for path, entry := range songs {
+		candidates = append(candidates, autoDJCandidate{path, entry, gomu.stats.get(path)})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].path < candidates[j].path
+	})
+
+	return candidates
+}
+
+// Gets the number of songs the auto DJ has to append for the queue to hold
+// General.auto_dj_min_queue songs
+func (s *songQueue) autoDJNeeded() int {
+
+	if !s.isAutoDJ {
+		return 0
+	}
+
+	minQueue := gomu.anko.GetInt("General.auto_dj_min_queue")
+	if minQueue < 1 {
+		minQueue = 1
+	}
+
+	if n := minQueue - s.length(); n > 0 {
+		return n
+	}
+
+	return 0
+}
+
+// Appends the songs picked by the auto DJ when the queue runs low. current is
+// the song playing or which has just finished, the picks follow the last
+// queued song or else the current song.
+func (s *songQueue) fillAutoDJ(
+	current *player.AudioFile,
+	findAudioFile func(songPath string) (*player.AudioFile, error),
+	add func(audioFile *player.AudioFile),
+) {
+
+	n := s.autoDJNeeded()
+	if n == 0 {
+		return
+	}
+
+	if s.dj == nil {
+		s.dj = newAutoDJ()
+	}
+
+	candidates := autoDJCandidates()
+	strategy := gomu.anko.GetString("General.auto_dj_strategy")
+	window := gomu.anko.GetInt("General.auto_dj_repeat_window")
+	if window < 0 {
+		window = 0
+	}
+
+	excluded := make(map[string]bool)
+	lastPath := ""
+
+	if current != nil {
+		excluded[current.Path()] = true
+		lastPath = current.Path()
+	}
+
+	for _, audioFile := range s.songs() {
+		excluded[audioFile.Path()] = true
+		lastPath = audioFile.Path()
+	}
+
+	var played []string
+	for _, audioFile := range s.history.songs() {
+		played = append(played, audioFile.Path())
+	}
+
+	if lastPath == "" && len(played) > 0 {
+		lastPath = played[0]
+	}
+
+	last, _ := gomu.library.indexed(lastPath)
+
+	for added := 0; added < n; {
+
+		picked, ok := s.dj.pick(strategy, candidates, last, played, excluded, window)
+		if !ok {
+			return
+		}
+
+		excluded[picked.path] = true
+		candidates = filterCandidates(candidates, func(c autoDJCandidate) bool {
+			return c.path != picked.path
+		})
+
+		// the song may have been removed since it was indexed
+		audioFile, err := findAudioFile(picked.path)
+		if err != nil {
+			logError(err)
+			continue
+		}
+
+		add(audioFile)
+		last = picked.entry
+		added++
+	}
+}
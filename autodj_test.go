@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func newTestAutoDJ() *autoDJ {
+	return &autoDJ{rand: rand.New(rand.NewSource(1))}
+}
+
+func TestAutoDJPick(t *testing.T) {
+
+	candidates := []autoDJCandidate{
+		{path: "/music/a.mp3", entry: &libraryEntry{Artist: "Radiohead", Album: "OK Computer", Genre: "Rock"}},
+		{path: "/music/b.mp3", entry: &libraryEntry{Artist: "Radiohead", Album: "Kid A", Genre: "Electronic"}},
+		{path: "/music/c.mp3", entry: &libraryEntry{Artist: "Portishead", Album: "Dummy", Genre: "Trip Hop"}},
+		{path: "/music/d.mp3", entry: &libraryEntry{Artist: "Massive Attack", Album: "Mezzanine", Genre: "Trip Hop"}},
+	}
+
+	last := candidates[0].entry
+	excluded := map[string]bool{"/music/a.mp3": true}
+
+	for i := 0; i < 10; i++ {
+		got, ok := newTestAutoDJ().pick(autoDJArtist, candidates, last, nil, excluded, 0)
+		if !ok || got.path != "/music/b.mp3" {
+			t.Fatalf("Expected the other song of the artist; got %v", got.path)
+		}
+	}
+
+	// falls back to any song when nothing is similar
+	got, ok := newTestAutoDJ().pick(autoDJAlbum, candidates, last, nil, excluded, 0)
+	if !ok || got.path == "/music/a.mp3" {
+		t.Errorf("Expected a song other than the excluded one; got %v", got.path)
+	}
+
+	got, _ = newTestAutoDJ().pick(autoDJGenre, candidates, candidates[2].entry, nil, nil, 0)
+	if got.entry.Genre != "Trip Hop" {
+		t.Errorf("Expected a trip hop song; got %v", got.path)
+	}
+
+	// never played songs come first, then the songs played the longest ago
+	listened := []autoDJCandidate{
+		{"/music/a.mp3", &libraryEntry{}, songStats{Plays: 1, LastPlayed: 100}},
+		{"/music/b.mp3", &libraryEntry{}, songStats{Plays: 1, LastPlayed: 400}},
+		{"/music/c.mp3", &libraryEntry{}, songStats{Plays: 9, LastPlayed: 300}},
+		{"/music/d.mp3", &libraryEntry{}, songStats{Plays: 1, LastPlayed: 200}},
+	}
+
+	got, _ = newTestAutoDJ().pick(autoDJLeastRecent, listened, nil, nil, nil, 0)
+	if got.path != "/music/a.mp3" {
+		t.Errorf("Expected the least recently played song; got %v", got.path)
+	}
+
+	got, _ = newTestAutoDJ().pick(autoDJLeastRecent, append(listened, autoDJCandidate{path: "/music/e.mp3", entry: &libraryEntry{}}), nil, nil, nil, 0)
+	if got.stats.LastPlayed != 0 {
+		t.Errorf("Expected a song never played; got %v", got.path)
+	}
+
+	// songs played more often are picked more often
+	counts := make(map[string]int)
+	dj := newTestAutoDJ()
+	for i := 0; i < 1000; i++ {
+		got, _ := dj.pick(autoDJPlayCount, listened, nil, nil, nil, 0)
+		counts[got.path]++
+	}
+	if counts["/music/c.mp3"] < 2*counts["/music/a.mp3"] {
+		t.Errorf("Expected the most played song to be picked most; got %v", counts)
+	}
+}
+
+func TestAutoDJRepeatWindow(t *testing.T) {
+
+	candidates := []autoDJCandidate{
+		{path: "/music/a.mp3", entry: &libraryEntry{}},
+		{path: "/music/b.mp3", entry: &libraryEntry{}},
+		{path: "/music/c.mp3", entry: &libraryEntry{}},
+		{path: "/music/d.mp3", entry: &libraryEntry{}},
+	}
+
+	dj := newTestAutoDJ()
+	played := []string{"/music/a.mp3"}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		got, ok := dj.pick(autoDJRandom, candidates, nil, played, nil, 4)
+		if !ok {
+			t.Fatal("Expected a song to be picked")
+		}
+		if got.path == "/music/a.mp3" || seen[got.path] {
+			t.Fatalf("Expected no repeat within the window; got %v after %v", got.path, seen)
+		}
+		seen[got.path] = true
+	}
+
+	// every song is within the window, a repeat is better than nothing
+	if _, ok := dj.pick(autoDJRandom, candidates, nil, played, nil, 4); !ok {
+		t.Error("Expected a song to be picked when every song is recent")
+	}
+
+	if _, ok := dj.pick(autoDJRandom, nil, nil, played, nil, 4); ok {
+		t.Error("Expected nothing to be picked from an empty library")
+	}
+}
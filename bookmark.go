@@ -0,0 +1,403 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// abRepeatInterval is how often the position is checked against B
+const abRepeatInterval = 50 * time.Millisecond
+
+// bookmark is a named position in a song
+type bookmark struct {
+	Name string `json:"name"`
+	// seconds
+	Position float64 `json:"position"`
+}
+
+func (b bookmark) position() time.Duration {
+	return time.Duration(b.Position * float64(time.Second))
+}
+
+// bookmarks are the bookmarks of the songs by path, saved next to the
+// statistics
+type bookmarks struct {
+	mu        sync.Mutex
+	savedPath string
+	songs     map[string][]bookmark
+}
+
+func newBookmarks() *bookmarks {
+	return &bookmarks{songs: make(map[string][]bookmark)}
+}
+
+func getBookmarksPath() string {
+	return expandTilde(gomu.anko.GetString("General.bookmarks_path"))
+}
+
+// Loads the bookmarks saved at the path, which is where they are saved
+// afterwards
+func (b *bookmarks) load(savedPath string) error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.savedPath = savedPath
+
+	content, err := ioutil.ReadFile(savedPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	songs := make(map[string][]bookmark)
+	if err := json.Unmarshal(content, &songs); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	b.songs = songs
+
+	return nil
+}
+
+// Saves the bookmarks, they are saved as soon as they change
+func (b *bookmarks) save() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.savedPath == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(b.songs)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return tracerr.Wrap(ioutil.WriteFile(b.savedPath, content, 0644))
+}
+
+// Gets a copy of the bookmarks of the song ordered by position
+func (b *bookmarks) get(songPath string) []bookmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]bookmark(nil), b.songs[songPath]...)
+}
+
+func (b *bookmarks) add(songPath, name string, position time.Duration) error {
+
+	b.mu.Lock()
+	marks := append(b.songs[songPath], bookmark{name, position.Seconds()})
+	sort.SliceStable(marks, func(i, j int) bool {
+		return marks[i].Position < marks[j].Position
+	})
+	b.songs[songPath] = marks
+	b.mu.Unlock()
+
+	return b.save()
+}
+
+// Removes the bookmark at the index of the bookmarks of the song
+func (b *bookmarks) remove(songPath string, index int) error {
+
+	b.mu.Lock()
+	marks := b.songs[songPath]
+	if index < 0 || index >= len(marks) {
+		b.mu.Unlock()
+		return nil
+	}
+	marks = append(marks[:index:index], marks[index+1:]...)
+	if len(marks) == 0 {
+		delete(b.songs, songPath)
+	} else {
+		b.songs[songPath] = marks
+	}
+	b.mu.Unlock()
+
+	return b.save()
+}
+
+// Moves the bookmarks of the renamed file or of the files under the renamed
+// directory
+func (b *bookmarks) rename(oldPath, newPath string) error {
+
+	b.mu.Lock()
+
+	moved := make(map[string][]bookmark)
+	for songPath, marks := range b.songs {
+		if isUnder(oldPath, songPath) {
+			delete(b.songs, songPath)
+			moved[newPath+songPath[len(oldPath):]] = marks
+		}
+	}
+
+	for songPath, marks := range moved {
+		b.songs[songPath] = marks
+	}
+
+	b.mu.Unlock()
+
+	if len(moved) == 0 {
+		return nil
+	}
+
+	return b.save()
+}
+
+// abState is the state of the A-B repeat
+type abState int
+
+const (
+	abOff abState = iota
+	// A is marked, waiting for B
+	abMarkedA
+	// looping from A to B
+	abLooping
+)
+
+// abRepeat loops the current song between the points A and B
+type abRepeat struct {
+	mu       sync.Mutex
+	state    abState
+	songPath string
+	a, b     time.Duration
+	stop     chan struct{}
+}
+
+// Marks the position of the song as the next point, once A and B are marked
+// the next mark turns the repeat off. A mark in another song starts over.
+func (r *abRepeat) mark(songPath string, position time.Duration) abState {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.songPath != songPath && r.state != abOff {
+		r.clearLocked()
+	}
+
+	switch r.state {
+	case abOff:
+		r.state = abMarkedA
+		r.songPath = songPath
+		r.a = position
+	case abMarkedA:
+		r.state = abLooping
+		r.b = position
+		// marked the other way around
+		if r.b < r.a {
+			r.a, r.b = r.b, r.a
+		}
+		r.stop = make(chan struct{})
+	case abLooping:
+		r.clearLocked()
+	}
+
+	return r.state
+}
+
+// Gets the points of the song, they are only set while its A-B repeat is on
+func (r *abRepeat) points(songPath string) (state abState, a, b time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.songPath != songPath {
+		return abOff, 0, 0
+	}
+	return r.state, r.a, r.b
+}
+
+func (r *abRepeat) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clearLocked()
+}
+
+// must be called with the lock held
+func (r *abRepeat) clearLocked() {
+	if r.state == abLooping {
+		close(r.stop)
+	}
+	r.state = abOff
+	r.songPath = ""
+}
+
+// Seeks back to A whenever the position reaches B, until the repeat is turned
+// off or another song plays
+func (r *abRepeat) loop() {
+
+	r.mu.Lock()
+	stop := r.stop
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(abRepeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := gomu.player.GetCurrentSong()
+		if current == nil {
+			r.clear()
+			return
+		}
+
+		state, a, b := r.points(current.Path())
+		if state != abLooping {
+			r.clear()
+			return
+		}
+
+		if gomu.player.GetPosition() >= b {
+			if err := gomu.player.Seek(a); err != nil {
+				logError(err)
+			}
+		}
+	}
+}
+
+// Gets the song which is playing or paused, nil when stopped
+func playingSong() player.Audio {
+	if gomu.player.IsRunning() || gomu.player.IsPaused() {
+		return gomu.player.GetCurrentSong()
+	}
+	return nil
+}
+
+// progressMark is drawn on the progress bar at the position
+type progressMark struct {
+	position time.Duration
+	symbol   rune
+}
+
+// Gets the bookmarks and the A-B points of the song to be drawn on the
+// progress bar
+func songMarks(songPath string) []progressMark {
+
+	var marks []progressMark
+
+	for _, b := range gomu.bookmarks.get(songPath) {
+		marks = append(marks, progressMark{b.position(), '▼'})
+	}
+
+	state, a, b := gomu.abRepeat.points(songPath)
+	if state != abOff {
+		marks = append(marks, progressMark{a, 'A'})
+	}
+	if state == abLooping {
+		marks = append(marks, progressMark{b, 'B'})
+	}
+
+	return marks
+}
+
+// Replaces the cells of the progress bar at the marks, full is the length of
+// the song in seconds. Later marks are drawn over earlier ones.
+func markProgress(bar string, full int, marks []progressMark) string {
+
+	cells := []rune(bar)
+	if full <= 0 || len(cells) == 0 {
+		return bar
+	}
+
+	for _, m := range marks {
+		i := int(m.position.Seconds()) * len(cells) / full
+		if i < 0 {
+			continue
+		}
+		if i >= len(cells) {
+			i = len(cells) - 1
+		}
+		cells[i] = m.symbol
+	}
+
+	return string(cells)
+}
+
+// Lists the bookmarks of the current song, the song jumps to the selected one
+func bookmarksPopup(songPath string) {
+
+	popupID := "bookmarks-popup"
+	marks := gomu.bookmarks.get(songPath)
+
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBackgroundColor(gomu.colors.popup).SetTitle(" Bookmarks ").
+		SetBorder(true)
+	list.SetSelectedBackgroundColor(gomu.colors.accent).
+		SetSelectedTextColor(gomu.colors.foreground)
+
+	redraw := func() {
+		list.Clear()
+		for _, b := range marks {
+			list.AddItem(fmt.Sprintf("[ %s ] %s", fmtDuration(b.position()), b.Name), "", 0, nil)
+		}
+		if len(marks) == 0 {
+			list.AddItem("  no bookmark yet", "", 0, nil)
+		}
+	}
+
+	redraw()
+
+	list.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			list.SetCurrentItem(list.GetCurrentItem() + 1)
+		case 'k':
+			list.SetCurrentItem(list.GetCurrentItem() - 1)
+		case 'd':
+			if len(marks) == 0 {
+				break
+			}
+			if err := gomu.bookmarks.remove(songPath, list.GetCurrentItem()); err != nil {
+				errorPopup(err)
+			}
+			marks = gomu.bookmarks.get(songPath)
+			redraw()
+		}
+
+		switch e.Key() {
+		case tcell.KeyEsc:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+		case tcell.KeyEnter:
+			if len(marks) == 0 {
+				break
+			}
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+			b := marks[list.GetCurrentItem()]
+			if err := gomu.player.Seek(b.position()); err != nil {
+				errorPopup(err)
+			}
+		}
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(list, 60, 20), true, true)
+	gomu.popups.push(list)
+}
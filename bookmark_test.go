@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBookmarks(t *testing.T) {
+
+	b := newBookmarks()
+	b.savedPath = filepath.Join(t.TempDir(), "gomu", "bookmarks")
+
+	for _, mark := range []bookmark{{"solo", 90}, {"intro", 5}, {"bridge", 60}} {
+		if err := b.add("/music/a.mp3", mark.Name, mark.position()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := b.get("/music/a.mp3")
+	if len(got) != 3 || got[0].Name != "intro" || got[1].Name != "bridge" || got[2].Name != "solo" {
+		t.Errorf("Expected bookmarks ordered by position; got %v", got)
+	}
+
+	if err := b.remove("/music/a.mp3", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.rename("/music", "/songs"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newBookmarks()
+	if err := loaded.load(b.savedPath); err != nil {
+		t.Fatal(err)
+	}
+
+	got = loaded.get("/songs/a.mp3")
+	if len(got) != 2 || got[0].Name != "intro" || got[1].position() != 90*time.Second {
+		t.Errorf("Expected saved bookmarks at the new path; got %v", got)
+	}
+
+	if got := loaded.get("/music/a.mp3"); len(got) != 0 {
+		t.Errorf("Expected no bookmark left at the old path; got %v", got)
+	}
+}
+
+func TestABRepeat(t *testing.T) {
+
+	var r abRepeat
+
+	if state := r.mark("/music/a.mp3", 20*time.Second); state != abMarkedA {
+		t.Fatalf("Expected A to be marked; got %v", state)
+	}
+
+	// B before A swaps them
+	if state := r.mark("/music/a.mp3", 10*time.Second); state != abLooping {
+		t.Fatalf("Expected to be looping; got %v", state)
+	}
+
+	if state, a, b := r.points("/music/a.mp3"); state != abLooping || a != 10*time.Second || b != 20*time.Second {
+		t.Errorf("Expected loop from 10s to 20s; got %v %v %v", state, a, b)
+	}
+
+	if state, _, _ := r.points("/music/b.mp3"); state != abOff {
+		t.Errorf("Expected no loop in another song; got %v", state)
+	}
+
+	stop := r.stop
+
+	// marking another song starts over
+	if state := r.mark("/music/b.mp3", 5*time.Second); state != abMarkedA {
+		t.Fatalf("Expected A to be marked in the other song; got %v", state)
+	}
+
+	select {
+	case <-stop:
+	default:
+		t.Error("Expected the previous loop to be stopped")
+	}
+
+	r.mark("/music/b.mp3", 8*time.Second)
+	if state := r.mark("/music/b.mp3", 9*time.Second); state != abOff {
+		t.Errorf("Expected the third mark to turn it off; got %v", state)
+	}
+}
+
+func TestMarkProgress(t *testing.T) {
+
+	bar := progresStr(0, 100, 10, "█", "━")
+
+	got := markProgress(bar, 100, []progressMark{
+		{0, '▼'},
+		{55 * time.Second, 'A'},
+		{100 * time.Second, 'B'},
+	})
+
+	if expected := "▼━━━━A━━━B"; got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+
+	if got := markProgress(bar, 0, []progressMark{{0, 'A'}}); got != bar {
+		t.Errorf("Expected no mark without a length; got %s", got)
+	}
+}
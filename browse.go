@@ -0,0 +1,419 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// browseView is how the songs are grouped in the Playlist panel
+type browseView int
+
+const (
+	browseDirectory browseView = iota
+	browseArtist
+	browseGenre
+	browseYear
+	browseRecent
+	browseSmart
+)
+
+// number of songs shown in the recently added view
+const recentlyAddedLimit = 100
+
+// errBrowseGroup is returned by the commands which need a file or a directory
+// when a group of a browse view is highlighted
+var errBrowseGroup = errors.New("not available for groups, switch to the directory view")
+
+func (v browseView) String() string {
+	switch v {
+	case browseArtist:
+		return "Artists"
+	case browseGenre:
+		return "Genres"
+	case browseYear:
+		return "Years"
+	case browseRecent:
+		return "Recently Added"
+	case browseSmart:
+		return "Smart Playlists"
+	}
+	return "Directory"
+}
+
+// browseTrack is a song of the music directory along with its indexed tags
+type browseTrack struct {
+	audioFile *player.AudioFile
+	entry     *libraryEntry
+}
+
+// browseLevel gets the group of a song at one level of a browse view
+type browseLevel func(t browseTrack) string
+
+func artistLevel(t browseTrack) string {
+	return groupName(t.entry.Artist, "Unknown Artist")
+}
+
+func albumLevel(t browseTrack) string {
+	return groupName(t.entry.Album, "Unknown Album")
+}
+
+func genreLevel(t browseTrack) string {
+	return groupName(t.entry.Genre, "Unknown Genre")
+}
+
+func yearLevel(t browseTrack) string {
+	if t.entry.Year <= 0 {
+		return "Unknown Year"
+	}
+	return strconv.Itoa(t.entry.Year)
+}
+
+func groupName(name, unknown string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return unknown
+	}
+	return name
+}
+
+// Gets the grouping of the view from the outermost group
+func (v browseView) levels() []browseLevel {
+	switch v {
+	case browseArtist:
+		return []browseLevel{artistLevel, albumLevel}
+	case browseGenre:
+		return []browseLevel{genreLevel, artistLevel}
+	case browseYear:
+		return []browseLevel{yearLevel, albumLevel}
+	}
+	return nil
+}
+
+// Checks whether the AudioFile is a group of a browse view rather than a
+// file or a directory
+func isBrowseGroup(audioFile *player.AudioFile) bool {
+	return audioFile != nil && !audioFile.IsAudioFile() && audioFile.Path() == ""
+}
+
+// Gets the songs of the directory tree with their library entries. Songs of
+// playlist files are left out as they are in the music directory already,
+// and streams have no tags to be browsed by.
+func collectBrowseTracks(root *tview.TreeNode) []browseTrack {
+
+	var tracks []browseTrack
+
+	root.Walk(func(node, _ *tview.TreeNode) bool {
+
+		audioFile := node.GetReference().(*player.AudioFile)
+
+		if !audioFile.IsAudioFile() {
+			return node == root || !isPlaylistFile(audioFile.Path())
+		}
+
+		if player.IsStream(audioFile.Path()) {
+			return true
+		}
+
+		entry, ok := gomu.library.indexed(audioFile.Path())
+		if !ok {
+			entry = &libraryEntry{}
+		}
+
+		tracks = append(tracks, browseTrack{audioFile, entry})
+
+		return true
+	})
+
+	return tracks
+}
+
+// Orders songs as they appear on their album
+func sortAlbumOrder(tracks []browseTrack) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.entry.Album != b.entry.Album {
+			return strings.ToLower(a.entry.Album) < strings.ToLower(b.entry.Album)
+		}
+		if a.entry.TrackNumber != b.entry.TrackNumber {
+			return a.entry.TrackNumber < b.entry.TrackNumber
+		}
+		return a.audioFile.Name() < b.audioFile.Name()
+	})
+}
+
+// Builds the tree of the view, the song nodes refer to the same AudioFiles as
+// the directory tree. Returns the root and the keys identifying every node
+// across rebuilds.
+func buildBrowseTree(
+	view browseView, tracks []browseTrack,
+) (*tview.TreeNode, map[*tview.TreeNode]string) {
+
+	root := newBrowseGroup(view.String(), nil)
+	keys := map[*tview.TreeNode]string{root: ""}
+
+	tracks = append([]browseTrack(nil), tracks...)
+
+	if view == browseRecent {
+		sort.SliceStable(tracks, func(i, j int) bool {
+			return tracks[i].entry.Added > tracks[j].entry.Added
+		})
+		if len(tracks) > recentlyAddedLimit {
+			tracks = tracks[:recentlyAddedLimit]
+		}
+	} else {
+		sortAlbumOrder(tracks)
+	}
+
+	levels := view.levels()
+
+	// groups differing only by case are merged, the first name seen is shown
+	groups := make(map[string]*tview.TreeNode)
+
+	for _, track := range tracks {
+
+		parent := root
+
+		for _, level := range levels {
+
+			name := level(track)
+			key := keys[parent] + "/" + strings.ToLower(name)
+
+			group, ok := groups[key]
+			if !ok {
+				group = newBrowseGroup(name, parent)
+				parent.AddChild(group)
+				groups[key] = group
+				keys[group] = key
+			}
+
+			parent = group
+		}
+
+		node := tview.NewTreeNode(setDisplayText(track.audioFile)).
+			SetReference(track.audioFile)
+		parent.AddChild(node)
+		keys[node] = keys[parent] + "/" + track.audioFile.Path()
+	}
+
+	sortBrowseGroups(root)
+
+	return root, keys
+}
+
+func newBrowseGroup(name string, parent *tview.TreeNode) *tview.TreeNode {
+
+	node := tview.NewTreeNode(name).
+		SetColor(gomu.colors.playlistDir).
+		SetExpanded(false)
+
+	audioFile := new(player.AudioFile)
+	audioFile.SetName(name)
+	audioFile.SetIsAudioFile(false)
+	audioFile.SetNode(node)
+	audioFile.SetParentNode(parent)
+
+	node.SetReference(audioFile)
+	node.SetText(setDisplayText(audioFile))
+
+	return node
+}
+
+// Sorts the groups by name, unknown groups are last. Songs keep their order.
+func sortBrowseGroups(root *tview.TreeNode) {
+
+	root.Walk(func(node, _ *tview.TreeNode) bool {
+
+		children := node.GetChildren()
+
+		sort.SliceStable(children, func(i, j int) bool {
+			a := children[i].GetReference().(*player.AudioFile)
+			b := children[j].GetReference().(*player.AudioFile)
+			if a.IsAudioFile() || b.IsAudioFile() {
+				return false
+			}
+			aUnknown := strings.HasPrefix(a.Name(), "Unknown ")
+			bUnknown := strings.HasPrefix(b.Name(), "Unknown ")
+			if aUnknown != bUnknown {
+				return bUnknown
+			}
+			return strings.ToLower(a.Name()) < strings.ToLower(b.Name())
+		})
+
+		return true
+	})
+}
+
+// Gets the root of the directory tree, which is not shown in browse views
+func (p *Playlist) directoryRoot() *tview.TreeNode {
+	if p.view == browseDirectory {
+		return p.GetRoot()
+	}
+	return p.dirRoot
+}
+
+// Shows the songs grouped by the view. The highlighted song stays highlighted.
+func (p *Playlist) setView(view browseView) {
+
+	if view == p.view {
+		return
+	}
+
+	if p.view == browseDirectory {
+		p.dirRoot = p.GetRoot()
+	}
+
+	current := p.getCurrentFile()
+	p.view = view
+
+	// groups are collapsed when switching views
+	p.browseKeys = nil
+
+	if view != browseDirectory {
+		p.defaultTitle = fmt.Sprintf("─ Playlist: %s ──┤ 0 downloads ├", view)
+		songPath := ""
+		if current != nil {
+			songPath = current.Path()
+		}
+		p.rebuildBrowse(songPath)
+	} else {
+		p.defaultTitle = "─ Playlist ──┤ 0 downloads ├"
+		p.SetRoot(p.dirRoot)
+
+		highlight := p.dirRoot
+		if children := p.dirRoot.GetChildren(); len(children) > 0 {
+			highlight = children[0]
+		}
+
+		if current != nil && current.IsAudioFile() && current.Node() != nil &&
+			containsNode(p.dirRoot, current.Node()) {
+			highlight = current.Node()
+			for parent := current.ParentNode(); parent != nil; {
+				parent.SetExpanded(true)
+				parent = parent.GetReference().(*player.AudioFile).ParentNode()
+			}
+		}
+
+		p.setHighlight(highlight)
+	}
+
+	if p.download == 0 {
+		p.SetTitle(p.defaultTitle)
+	}
+}
+
+// Switches to the next view
+func (p *Playlist) cycleView() {
+	p.setView((p.view + 1) % (browseSmart + 1))
+}
+
+// Rebuilds the tree of the browse view from the directory tree, the expanded
+// groups and the highlighted node are kept. The song at songPath is
+// highlighted if the highlighted node is no longer there.
+func (p *Playlist) rebuildBrowse(songPath string) {
+
+	expanded := make(map[string]bool)
+	for node, key := range p.browseKeys {
+		if node.IsExpanded() {
+			expanded[key] = true
+		}
+	}
+
+	currentKey, hasCurrent := p.browseKeys[p.GetCurrentNode()]
+
+	tracks := collectBrowseTracks(p.dirRoot)
+
+	var root *tview.TreeNode
+	var keys map[*tview.TreeNode]string
+
+	// smart playlists are evaluated again as the library may have changed
+	if p.view == browseSmart {
+		playlists, err := loadSmartPlaylists(getSmartPlaylistsPath())
+		if err != nil {
+			logError(err)
+		}
+		root, keys = buildSmartTree(playlists, tracks)
+	} else {
+		root, keys = buildBrowseTree(p.view, tracks)
+	}
+
+	sortTreeSongs(root, p.sortOrder)
+
+	p.browseKeys = keys
+
+	var highlight, songNode *tview.TreeNode
+	parents := make(map[*tview.TreeNode]*tview.TreeNode)
+
+	root.Walk(func(node, parent *tview.TreeNode) bool {
+
+		parents[node] = parent
+
+		if expanded[keys[node]] {
+			node.SetExpanded(true)
+		}
+
+		if hasCurrent && keys[node] == currentKey {
+			highlight = node
+		}
+
+		audioFile := node.GetReference().(*player.AudioFile)
+		if songNode == nil && audioFile.IsAudioFile() && audioFile.Path() == songPath {
+			songNode = node
+		}
+
+		return true
+	})
+
+	root.SetExpanded(true)
+	p.SetRoot(root)
+
+	if highlight == nil && songNode != nil {
+		highlight = songNode
+		for parent := parents[songNode]; parent != nil; parent = parents[parent] {
+			parent.SetExpanded(true)
+		}
+	}
+
+	if highlight == nil {
+		highlight = root
+		if children := root.GetChildren(); len(children) > 0 {
+			highlight = children[0]
+		}
+	}
+
+	p.setHighlight(highlight)
+}
+
+// Gets the node of the AudioFile in the tree shown, the groups containing it
+// are expanded
+func (p *Playlist) nodeOf(audioFile *player.AudioFile) *tview.TreeNode {
+
+	if p.view == browseDirectory {
+		return audioFile.Node()
+	}
+
+	var found *tview.TreeNode
+	parents := make(map[*tview.TreeNode]*tview.TreeNode)
+
+	p.GetRoot().Walk(func(node, parent *tview.TreeNode) bool {
+		parents[node] = parent
+		if node.GetReference() == audioFile {
+			found = node
+		}
+		return found == nil
+	})
+
+	for parent := parents[found]; parent != nil; parent = parents[parent] {
+		parent.SetExpanded(true)
+	}
+
+	return found
+}
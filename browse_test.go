@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func TestBuildBrowseTree(t *testing.T) {
+
+	gomu = newGomu()
+	err := execConfig(expandFilePath(testConfigPath))
+	if err != nil {
+		t.Error(err)
+	}
+	gomu.colors = newColor()
+
+	newTrack := func(name string, entry libraryEntry) browseTrack {
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(name)
+		audioFile.SetPath("/music/" + name + ".mp3")
+		audioFile.SetIsAudioFile(true)
+		return browseTrack{audioFile, &entry}
+	}
+
+	tracks := []browseTrack{
+		newTrack("airbag", libraryEntry{Artist: "Radiohead", Album: "OK Computer", TrackNumber: 1, Added: 1}),
+		newTrack("creep", libraryEntry{Artist: "Radiohead", Album: "Pablo Honey", TrackNumber: 2, Added: 3}),
+		newTrack("paranoid android", libraryEntry{Artist: "radiohead", Album: "OK Computer", TrackNumber: 2, Added: 2}),
+		newTrack("untagged", libraryEntry{Added: 4}),
+		newTrack("one more time", libraryEntry{Artist: "Daft Punk", Album: "Discovery", TrackNumber: 1}),
+	}
+
+	root, keys := buildBrowseTree(browseArtist, tracks)
+
+	var artists []string
+	for _, artist := range root.GetChildren() {
+		artists = append(artists, artist.GetReference().(*player.AudioFile).Name())
+	}
+
+	// artists differing by case are merged and unknown artists are last
+	expected := []string{"Daft Punk", "Radiohead", "Unknown Artist"}
+	if len(artists) != len(expected) {
+		t.Fatalf("Expected artists %v; got %v", expected, artists)
+	}
+	for i := range expected {
+		if artists[i] != expected[i] {
+			t.Errorf("Expected artists %v; got %v", expected, artists)
+		}
+	}
+
+	albums := root.GetChildren()[1].GetChildren()
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums of Radiohead; got %d", len(albums))
+	}
+
+	okComputer := albums[0].GetChildren()
+	if len(okComputer) != 2 {
+		t.Fatalf("Expected 2 songs in OK Computer; got %d", len(okComputer))
+	}
+
+	// the songs refer to the AudioFiles of the directory tree
+	if okComputer[0].GetReference() != tracks[0].audioFile || okComputer[1].GetReference() != tracks[2].audioFile {
+		t.Error("Expected songs ordered by track number")
+	}
+
+	if !isBrowseGroup(albums[0].GetReference().(*player.AudioFile)) {
+		t.Error("Expected album to be a browse group")
+	}
+
+	if keys[albums[0]] != "/radiohead/ok computer" {
+		t.Errorf("Expected key /radiohead/ok computer; got %q", keys[albums[0]])
+	}
+
+	root, _ = buildBrowseTree(browseRecent, tracks)
+
+	recent := root.GetChildren()
+	if len(recent) != len(tracks) {
+		t.Fatalf("Expected %d recently added songs; got %d", len(tracks), len(recent))
+	}
+
+	for i, name := range []string{"untagged", "creep", "paranoid android"} {
+		if got := recent[i].GetReference().(*player.AudioFile).Name(); got != name {
+			t.Errorf("Expected %s to be added #%d; got %s", name, i+1, got)
+		}
+	}
+}
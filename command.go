@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/issadarkthing/gomu/player"
 	"github.com/rivo/tview"
@@ -38,6 +41,14 @@ func (c Command) defineCommands() {
 	/* Playlist */
 
 	c.define("create_playlist", func() {
+		if isRadio(gomu.playlist.getCurrentFile()) {
+			addStationPopup()
+			return
+		}
+		if isBrowseGroup(gomu.playlist.getCurrentFile()) {
+			errorPopup(errBrowseGroup)
+			return
+		}
 		name, _ := gomu.pages.GetFrontPage()
 		if name != "mkdir-popup" {
 			createPlaylistPopup()
@@ -49,6 +60,18 @@ func (c Command) defineCommands() {
 		if audioFile.IsAudioFile() {
 			return
 		}
+		if gomu.playlist.isSmartPlaylist(audioFile.Node()) {
+			deleteSmartPlaylistPopup(audioFile.Name())
+			return
+		}
+		if isBrowseGroup(audioFile) {
+			errorPopup(errBrowseGroup)
+			return
+		}
+		if isRadio(audioFile) {
+			errorPopup(errRadio)
+			return
+		}
 		err := confirmDeleteAllPopup(audioFile.Node())
 		if err != nil {
 			errorPopup(err)
@@ -62,11 +85,30 @@ func (c Command) defineCommands() {
 			return
 		}
 
+		// only the saved stations can be deleted
+		if isRadio(audioFile) {
+			if isRadio(audioFile.Parent()) {
+				deleteStationPopup(audioFile.Name())
+			} else {
+				errorPopup(errRadio)
+			}
+			return
+		}
+
+		if isPlaylistEntry(audioFile) {
+			errorPopup(errPlaylistEntry)
+			return
+		}
+
 		gomu.playlist.deleteSong(audioFile)
 
 	})
 
 	c.define("youtube_search", func() {
+		if isBrowseGroup(gomu.playlist.getCurrentFile()) {
+			errorPopup(errBrowseGroup)
+			return
+		}
 		ytSearchPopup()
 	})
 
@@ -79,6 +121,20 @@ func (c Command) defineCommands() {
 			gomu.popups.pop()
 			return
 		}
+		if isBrowseGroup(audioFile) {
+			errorPopup(errBrowseGroup)
+			return
+		}
+		if isRadio(audioFile) {
+			errorPopup(errRadio)
+			return
+		}
+		// a playlist file is not a directory to download to
+		if isPlaylistEntry(audioFile) ||
+			(!audioFile.IsAudioFile() && isPlaylistFile(audioFile.Path())) {
+			errorPopup(errPlaylistEntry)
+			return
+		}
 		// this ensures it downloads to
 		// the correct dir
 		if audioFile.IsAudioFile() {
@@ -112,7 +168,7 @@ func (c Command) defineCommands() {
 		// remove the color of the node
 
 		if audioFile.IsAudioFile() {
-			parent := audioFile.ParentNode()
+			parent := gomu.playlist.parentNode(currNode)
 			gomu.playlist.setHighlight(parent)
 			parent.SetExpanded(false)
 		}
@@ -157,6 +213,18 @@ func (c Command) defineCommands() {
 
 	c.define("rename", func() {
 		audioFile := gomu.playlist.getCurrentFile()
+		if isBrowseGroup(audioFile) {
+			errorPopup(errBrowseGroup)
+			return
+		}
+		if isRadio(audioFile) {
+			errorPopup(errRadio)
+			return
+		}
+		if isPlaylistEntry(audioFile) {
+			errorPopup(errPlaylistEntry)
+			return
+		}
 		renamePopup(audioFile)
 	})
 
@@ -175,11 +243,49 @@ func (c Command) defineCommands() {
 				logError(err)
 			}
 
-			gomu.playlist.setHighlight(audio.Node())
+			gomu.playlist.setHighlight(gomu.playlist.nodeOf(audio))
 			gomu.playlist.refresh()
 		})
 	})
 
+	c.define("replaygain_scan", func() {
+		audioFile := gomu.playlist.getCurrentFile()
+		infoPopup("Scanning loudness of " + audioFile.Name())
+		go func() {
+			count, err := scanReplayGain(audioFile)
+			if err != nil {
+				errorPopup(err)
+				gomu.app.Draw()
+				return
+			}
+			defaultTimedPopup(" ReplayGain ", fmt.Sprintf("%d songs scanned", count))
+			gomu.app.Draw()
+		}()
+	})
+
+	c.define("query_search", func() {
+		queryPopup()
+	})
+
+	c.define("switch_browse_view", func() {
+		gomu.playlist.cycleView()
+	})
+
+	c.define("rate_song", func() {
+		audioFile := gomu.playlist.getCurrentFile()
+		if audioFile == nil || !audioFile.IsAudioFile() {
+			return
+		}
+		ratingPopup(audioFile)
+	})
+
+	c.define("sort_playlist", func() {
+		orders := []statsOrder{
+			orderDefault, orderPlays, orderSkips, orderRating, orderLastPlayed,
+		}
+		sortPopup(orders, gomu.playlist.setSortOrder)
+	})
+
 	c.define("reload_config", func() {
 		cfg := expandFilePath(*gomu.args.config)
 		err := execConfig(cfg)
@@ -187,6 +293,8 @@ func (c Command) defineCommands() {
 			errorPopup(err)
 		}
 
+		gomu.configPlayer()
+
 		infoPopup("successfully reload config file")
 	})
 
@@ -239,6 +347,72 @@ func (c Command) defineCommands() {
 		gomu.queue.shuffle()
 	})
 
+	c.define("save_queue_as", func() {
+		placeholder := filepath.Join(getMusicDir(gomu.args), "queue.m3u8")
+		inputPopup("Save queue as", placeholder, func(playlistPath string) {
+			playlistPath = expandFilePath(playlistPath)
+			if filepath.Ext(playlistPath) == "" {
+				playlistPath += ".m3u8"
+			}
+			err := writePlaylistFile(playlistPath, gomu.queue.songs())
+			if err != nil {
+				errorPopup(err)
+				return
+			}
+			gomu.playlist.refresh()
+			defaultTimedPopup(" Success ", "Queue saved to\n"+playlistPath)
+		})
+	})
+
+	c.define("load_playlist_file", func() {
+
+		load := func(playlistPath string) {
+			audioFiles, err := loadPlaylistFile(expandFilePath(playlistPath), gomu.queue.findAudioFile())
+			if err != nil {
+				errorPopup(err)
+				return
+			}
+			gomu.queue.enqueueAll(audioFiles)
+		}
+
+		// the highlighted playlist file is loaded without asking
+		audioFile := gomu.playlist.getCurrentFile()
+		if audioFile != nil && !audioFile.IsAudioFile() && isPlaylistFile(audioFile.Path()) {
+			load(audioFile.Path())
+			return
+		}
+
+		inputPopup("Load playlist file", "", load)
+	})
+
+	c.define("toggle_shuffle", func() {
+		gomu.queue.isShuffle = !gomu.queue.isShuffle
+		if gomu.queue.isShuffle {
+			gomu.queue.shuffle()
+		}
+		gomu.queue.updateTitle()
+	})
+
+	c.define("toggle_auto_dj", func() {
+		gomu.queue.isAutoDJ = !gomu.queue.isAutoDJ
+		current, _ := gomu.player.GetCurrentSong().(*player.AudioFile)
+		gomu.queue.runAutoDJ(current)
+		gomu.queue.updateTitle()
+	})
+
+	c.define("rate_queued_song", func() {
+		index := gomu.queue.GetCurrentItem()
+		if index < 0 || index >= len(gomu.queue.items) {
+			return
+		}
+		ratingPopup(gomu.queue.items[index])
+	})
+
+	c.define("sort_queue", func() {
+		orders := []statsOrder{orderPlays, orderSkips, orderRating, orderLastPlayed}
+		sortPopup(orders, gomu.queue.sortSongs)
+	})
+
 	c.define("queue_search", func() {
 
 		queue := gomu.queue
@@ -276,7 +450,7 @@ func (c Command) defineCommands() {
 	})
 
 	c.define("toggle_pause", func() {
-		gomu.player.TogglePause()
+		gomu.togglePause()
 	})
 
 	c.define("volume_up", func() {
@@ -295,10 +469,74 @@ func (c Command) defineCommands() {
 		}
 	})
 
+	c.define("speed_up", func() {
+		speedPopup(gomu.player.SetSpeed(gomu.player.Speed() + 0.1))
+	})
+
+	c.define("speed_down", func() {
+		speedPopup(gomu.player.SetSpeed(gomu.player.Speed() - 0.1))
+	})
+
+	c.define("ab_repeat", func() {
+		current := playingSong()
+		if current == nil {
+			return
+		}
+
+		position := gomu.player.GetPosition()
+
+		switch gomu.abRepeat.mark(current.Path(), position) {
+		case abMarkedA:
+			defaultTimedPopup(" A-B Repeat ", "A marked at "+fmtDuration(position))
+		case abLooping:
+			_, a, b := gomu.abRepeat.points(current.Path())
+			go gomu.abRepeat.loop()
+			defaultTimedPopup(" A-B Repeat ",
+				fmt.Sprintf("repeating %s to %s", fmtDuration(a), fmtDuration(b)))
+		case abOff:
+			defaultTimedPopup(" A-B Repeat ", "A-B repeat off")
+		}
+	})
+
+	c.define("add_bookmark", func() {
+		current := playingSong()
+		if current == nil {
+			return
+		}
+
+		position := gomu.player.GetPosition()
+
+		inputPopup("Bookmark", fmtDuration(position), func(name string) {
+			if err := gomu.bookmarks.add(current.Path(), name, position); err != nil {
+				errorPopup(err)
+			}
+		})
+	})
+
+	c.define("show_bookmarks", func() {
+		if current := playingSong(); current != nil {
+			bookmarksPopup(current.Path())
+		}
+	})
+
 	c.define("skip", func() {
 		gomu.player.Skip()
 	})
 
+	c.define("previous", func() {
+		err := playPrevious(gomu.queue.songQueue, gomu.queue.pushFront, gomu.queue.playQueue)
+		if err != nil {
+			errorPopup(err)
+		}
+	})
+
+	c.define("show_history", func() {
+		name, _ := gomu.pages.GetFrontPage()
+		if name != "history-popup" {
+			historyPopup()
+		}
+	})
+
 	c.define("toggle_help", func() {
 		name, _ := gomu.pages.GetFrontPage()
 
@@ -327,67 +565,23 @@ func (c Command) defineCommands() {
 	})
 
 	c.define("forward", func() {
-		if gomu.player.IsRunning() && !gomu.player.IsPaused() {
-			position := gomu.playingBar.getProgress() + 10
-			if position < gomu.playingBar.getFull() {
-				err := gomu.player.Seek(position)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(position)
-			}
-		}
+		seekBy(10 * time.Second)
 	})
 
 	c.define("rewind", func() {
-		if gomu.player.IsRunning() && !gomu.player.IsPaused() {
-			position := gomu.playingBar.getProgress() - 10
-			if position-1 > 0 {
-				err := gomu.player.Seek(position)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(position)
-			} else {
-				err := gomu.player.Seek(0)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(0)
-			}
-		}
+		seekBy(-10 * time.Second)
 	})
 
 	c.define("forward_fast", func() {
-		if gomu.player.IsRunning() && !gomu.player.IsPaused() {
-			position := gomu.playingBar.getProgress() + 60
-			if position < gomu.playingBar.getFull() {
-				err := gomu.player.Seek(position)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(position)
-			}
-		}
+		seekBy(time.Minute)
 	})
 
 	c.define("rewind_fast", func() {
-		if gomu.player.IsRunning() && !gomu.player.IsPaused() {
-			position := gomu.playingBar.getProgress() - 60
-			if position-1 > 0 {
-				err := gomu.player.Seek(position)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(position)
-			} else {
-				err := gomu.player.Seek(0)
-				if err != nil {
-					errorPopup(err)
-				}
-				gomu.playingBar.setProgress(0)
-			}
-		}
+		seekBy(-time.Minute)
+	})
+
+	c.define("seek_to", func() {
+		seekToPopup()
 	})
 
 	c.define("yank", func() {
@@ -472,6 +666,10 @@ func (c Command) defineCommands() {
 		gomu.popups.push(cp)
 	})
 
+	c.define("equalizer", func() {
+		equalizerPopup()
+	})
+
 	for name, cmd := range c.commands {
 		err := gomu.anko.DefineGlobal(name, cmd)
 		if err != nil {
@@ -0,0 +1,151 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+const ctlUsage = `Usage: gomu ctl [-socket path] <command>
+
+Commands:
+  play              resume or start playing the queue
+  pause             pause the current song
+  next              skip to the next song in the queue
+  previous          restart the song or go back to the previous one
+  enqueue <path>... add songs, directories or stream urls to the queue
+  status            show the current song and the queue
+`
+
+// Sends a command to the daemon and returns its response
+func sendCtl(socketPath, line string) (ctlResponse, error) {
+
+	var res ctlResponse
+
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return res, tracerr.Wrap(err)
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return res, tracerr.Wrap(err)
+	}
+
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return res, tracerr.Wrap(err)
+	}
+
+	return res, nil
+}
+
+// runCtl executes `gomu ctl` and returns the exit code
+func runCtl(args []string) int {
+
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := flags.String("socket", defaultSocketPath(), "Specify control socket")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var lines []string
+
+	switch command := flags.Arg(0); command {
+	case "play", "pause", "next", "previous", "status":
+		lines = append(lines, command)
+	case "enqueue":
+		if flags.NArg() < 2 {
+			flags.Usage()
+			return 2
+		}
+		// the daemon may run in another directory
+		for _, songPath := range flags.Args()[1:] {
+			if !player.IsStream(songPath) {
+				songPath = expandFilePath(songPath)
+			}
+			lines = append(lines, "enqueue "+songPath)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+
+	var res ctlResponse
+
+	for _, line := range lines {
+
+		var err error
+		res, err = sendCtl(expandFilePath(*socket), line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to connect to gomu daemon:", err)
+			return 1
+		}
+
+		if res.Error != "" {
+			fmt.Fprintln(os.Stderr, res.Error)
+			return 1
+		}
+	}
+
+	if res.Status != nil {
+		printStatus(os.Stdout, *res.Status)
+	}
+
+	return 0
+}
+
+// Prints the status in human readable form
+func printStatus(w io.Writer, status ctlStatus) {
+
+	if status.Song != "" {
+		position := time.Duration(status.Position * float64(time.Second))
+		length := fmtDuration(time.Duration(status.Length * float64(time.Second)))
+		song := status.Song
+		// a stream has no end
+		if player.IsStream(status.Path) {
+			length = "live"
+		}
+		if status.Title != "" {
+			song += " - " + status.Title
+		}
+		fmt.Fprintf(w, "%s: %s [%s/%s]\n", status.State, song,
+			fmtDuration(position), length)
+	} else {
+		fmt.Fprintln(w, status.State)
+	}
+
+	loop := "off"
+	if status.Loop {
+		loop = "on"
+	}
+
+	speed := ""
+	if status.Speed != 0 && status.Speed != 1 {
+		speed = fmt.Sprintf(" | speed: %gx", status.Speed)
+	}
+
+	fmt.Fprintf(w, "volume: %d%% | loop: %s%s | queue: %d\n",
+		status.Volume, loop, speed, len(status.Queue))
+
+	for i, songPath := range status.Queue {
+		fmt.Fprintf(w, "%3d. %s\n", i+1, getName(songPath))
+	}
+}
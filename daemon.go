@@ -0,0 +1,548 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// ctlStatus is the player state reported to the control clients
+type ctlStatus struct {
+	// "playing", "paused" or "stopped"
+	State string `json:"state"`
+	Song  string `json:"song,omitempty"`
+	Path  string `json:"path,omitempty"`
+	// title sent by the station of a stream
+	Title string `json:"title,omitempty"`
+	// position and length in seconds
+	Position float64  `json:"position"`
+	Length   float64  `json:"length"`
+	Volume   int      `json:"volume"`
+	Speed    float64  `json:"speed"`
+	Loop     bool     `json:"loop"`
+	Queue    []string `json:"queue"`
+}
+
+// ctlResponse is sent as a json line for every command received
+type ctlResponse struct {
+	Error  string     `json:"error,omitempty"`
+	Status *ctlStatus `json:"status,omitempty"`
+}
+
+// controller is driven by the commands received from the control socket
+type controller interface {
+	play() error
+	pause()
+	next() error
+	previous() error
+	enqueue(path string) error
+	status() ctlStatus
+}
+
+// Gets the default path of the control socket
+func defaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gomu.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gomu-%d.sock", os.Getuid()))
+}
+
+// Runs a single command line of the control protocol, commands are separated
+// from their argument by a space e.g. "enqueue /path/to/song.mp3"
+func handleCtl(c controller, line string) ctlResponse {
+
+	name, arg := strings.TrimSpace(line), ""
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name, arg = name[:i], strings.TrimSpace(name[i+1:])
+	}
+
+	var err error
+
+	switch name {
+	case "play":
+		err = c.play()
+	case "pause":
+		c.pause()
+	case "next":
+		err = c.next()
+	case "previous":
+		err = c.previous()
+	case "enqueue":
+		if arg == "" {
+			err = tracerr.New("enqueue requires a path")
+		} else {
+			err = c.enqueue(arg)
+		}
+	case "status":
+	default:
+		err = tracerr.Errorf("unknown command %q", name)
+	}
+
+	if err != nil {
+		return ctlResponse{Error: err.Error()}
+	}
+
+	status := c.status()
+	return ctlResponse{Status: &status}
+}
+
+// Listens on the unix socket, a stale socket left by a crashed daemon is
+// removed
+func listenCtl(socketPath string) (net.Listener, error) {
+
+	if _, err := os.Stat(socketPath); err == nil {
+
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil, tracerr.Errorf("gomu is already listening on %s", socketPath)
+		}
+
+		if err := os.Remove(socketPath); err != nil {
+			return nil, tracerr.Wrap(err)
+		}
+	}
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		l.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	return l, nil
+}
+
+// Accepts clients until the listener is closed
+func serveCtl(l net.Listener, c controller) {
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logError(err)
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+
+			scanner := bufio.NewScanner(conn)
+			encoder := json.NewEncoder(conn)
+
+			for scanner.Scan() {
+				if err := encoder.Encode(handleCtl(c, scanner.Text())); err != nil {
+					logError(err)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// daemon plays the queue without a screen
+type daemon struct {
+	player *player.Player
+	queue  *songQueue
+}
+
+func newDaemon(p *player.Player, q *songQueue) *daemon {
+
+	d := &daemon{
+		player: p,
+		queue:  q,
+	}
+
+	p.SetSongStart(func(audio player.Audio) {
+		// the next song is preloaded from the head of the queue
+		d.fillAutoDJ(audio.(*player.AudioFile))
+		gomu.hook.RunHooks("new_song")
+	})
+
+	// a station playing another song is a new song as well
+	p.OnEvent(func(e player.Event) {
+		if _, ok := e.(player.StreamTitleChanged); ok {
+			gomu.hook.RunHooks("new_song")
+		}
+	})
+
+	p.SetSongSkip(func(_ player.Audio, position time.Duration) {
+		gomu.stats.skip(position)
+		gomu.hook.RunHooks("skip")
+	})
+
+	p.SetSongFinish(func(currAudio player.Audio) {
+
+		recorded := recordFinished(d.queue, currAudio.(*player.AudioFile))
+
+		if d.queue.isLoop && recorded {
+			d.queue.add(currAudio.(*player.AudioFile))
+		}
+
+		d.fillAutoDJ(currAudio.(*player.AudioFile))
+
+		if d.queue.length() > 0 {
+			if err := d.playQueue(); err != nil {
+				logError(err)
+			}
+		}
+	})
+
+	// the head of the queue is preloaded for gapless playback
+	p.SetNextSong(func() player.Audio {
+		if head := d.queue.head(); head != nil {
+			return head
+		}
+		return nil
+	})
+
+	return d
+}
+
+// Plays the first item in the queue
+func (d *daemon) playQueue() error {
+
+	audioFile, err := d.queue.pop()
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return tracerr.Wrap(d.player.Run(audioFile))
+}
+
+// Appends the songs picked by the auto DJ when the queue runs low
+func (d *daemon) fillAutoDJ(current *player.AudioFile) {
+	d.queue.fillAutoDJ(current, newAudioFile, func(audioFile *player.AudioFile) {
+		d.queue.add(audioFile)
+	})
+}
+
+// Checks whether a song is loaded even if it is paused
+func (d *daemon) isActive() bool {
+	return d.player.IsRunning() || d.player.IsPaused()
+}
+
+func (d *daemon) play() error {
+
+	if d.player.IsPaused() {
+		gomu.resume()
+		return nil
+	}
+
+	if !d.isActive() && d.queue.length() > 0 {
+		return d.playQueue()
+	}
+
+	return nil
+}
+
+func (d *daemon) pause() {
+	gomu.pause()
+}
+
+func (d *daemon) next() error {
+
+	if d.isActive() {
+		d.player.Skip()
+		return nil
+	}
+
+	if d.queue.length() > 0 {
+		return d.playQueue()
+	}
+
+	return nil
+}
+
+func (d *daemon) previous() error {
+	return playPrevious(d.queue, d.queue.addFront, d.playQueue)
+}
+
+// Adds the song or every song in the directory to the queue and starts
+// playing if nothing is playing
+func (d *daemon) enqueue(songPath string) error {
+
+	audioFiles, err := findAudioFiles(songPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	for _, audioFile := range audioFiles {
+		d.queue.add(audioFile)
+	}
+
+	if !d.isActive() && d.queue.length() > 0 {
+		return d.playQueue()
+	}
+
+	return nil
+}
+
+func (d *daemon) status() ctlStatus {
+	return newCtlStatus(d.player, d.queue)
+}
+
+// Gets the state of the player and the queue
+func newCtlStatus(p *player.Player, queue *songQueue) ctlStatus {
+
+	status := ctlStatus{
+		State:  "stopped",
+		Volume: player.VolToHuman(p.GetVolume()),
+		Speed:  p.Speed(),
+		Loop:   queue.isLoop,
+		Queue:  []string{},
+	}
+
+	if p.IsPaused() {
+		status.State = "paused"
+	} else if p.IsRunning() {
+		status.State = "playing"
+	}
+
+	if current := p.GetCurrentSong(); current != nil && status.State != "stopped" {
+		status.Song = current.Name()
+		status.Path = current.Path()
+		status.Title = p.StreamTitle()
+		status.Position = p.GetPosition().Seconds()
+		if audioFile, ok := current.(*player.AudioFile); ok {
+			status.Length = audioFile.Len().Seconds()
+		}
+	}
+
+	for _, audioFile := range queue.songs() {
+		status.Queue = append(status.Queue, audioFile.Path())
+	}
+
+	return status
+}
+
+// Creates an AudioFile for a song outside of the playlist tree
+func newAudioFile(songPath string) (*player.AudioFile, error) {
+
+	if player.IsStream(songPath) {
+		return newStreamAudioFile(songPath), nil
+	}
+
+	entry, err := gomu.library.lookupPath(songPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	if !entry.Format.Playable() {
+		return nil, tracerr.Errorf("%s is not a supported audio file", songPath)
+	}
+
+	audioFile := new(player.AudioFile)
+	audioFile.SetName(getName(songPath))
+	audioFile.SetPath(songPath)
+	audioFile.SetIsAudioFile(true)
+	audioFile.SetLen(entry.Length)
+
+	return audioFile, nil
+}
+
+// Creates AudioFiles of the song, of the songs in the playlist file or of
+// every song under the directory sorted by path. A url is played as a stream
+func findAudioFiles(songPath string) ([]*player.AudioFile, error) {
+
+	if player.IsStream(songPath) {
+		streamURL, err := resolveStream(songPath)
+		if err != nil {
+			return nil, tracerr.Wrap(err)
+		}
+		return []*player.AudioFile{newStreamAudioFile(streamURL)}, nil
+	}
+
+	songPath, err := filepath.Abs(expandTilde(songPath))
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	info, err := os.Stat(songPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	if !info.IsDir() && isPlaylistFile(songPath) {
+		return loadPlaylistFile(songPath, newAudioFile)
+	}
+
+	if !info.IsDir() {
+		audioFile, err := newAudioFile(songPath)
+		if err != nil {
+			return nil, tracerr.Wrap(err)
+		}
+		return []*player.AudioFile{audioFile}, nil
+	}
+
+	var paths []string
+
+	err = filepath.Walk(songPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && player.HasSupportedExt(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	sort.Strings(paths)
+
+	var audioFiles []*player.AudioFile
+
+	for _, path := range paths {
+		audioFile, err := newAudioFile(path)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		audioFiles = append(audioFiles, audioFile)
+	}
+
+	return audioFiles, nil
+}
+
+// Runs gomu without the tui, the player is controlled through the unix
+// socket by `gomu ctl`
+func runDaemon(args Args) {
+
+	gomu = newGomu()
+	gomu.args = args
+	gomu.command.defineCommands()
+	gomu.anko.DefineGlobal("shell", shell)
+
+	err := loadModules(gomu.anko)
+	if err != nil {
+		die(err)
+	}
+
+	err = execConfig(expandFilePath(*args.config))
+	if err != nil {
+		die(err)
+	}
+
+	setupHooks(gomu.hook, gomu.anko)
+	gomu.hook.RunHooks("enter")
+
+	if err := gomu.library.load(); err != nil {
+		logError(err)
+	}
+	if err := gomu.stats.load(getStatsPath()); err != nil {
+		logError(err)
+	}
+
+	gomu.player = newPlayer()
+	gomu.configPlayer()
+	setupScrobbler(gomu.hook, gomu.player)
+
+	playerModule, _ := gomu.anko.NewModule("Player")
+	playerModule.Define("current_audio", gomu.player.GetCurrentSong)
+	playerModule.Define("state", func() string {
+		return gomu.player.State().String()
+	})
+	playerModule.Define("stream_title", gomu.player.StreamTitle)
+	defineStats(gomu.anko)
+
+	queue := newSongQueue()
+	queue.isLoop = gomu.anko.GetBool("General.queue_loop")
+	queue.isAutoDJ = gomu.anko.GetBool("General.auto_dj")
+
+	// without the playlist the library is only indexed for the auto DJ
+	if queue.isAutoDJ {
+		go func() {
+			if err := gomu.library.scan(getMusicDir(args)); err != nil {
+				logError(err)
+			}
+		}()
+	}
+
+	d := newDaemon(gomu.player, queue)
+	setupMpris(d)
+
+	state := playbackState{volume: -1}
+
+	if !*args.empty && gomu.anko.GetBool("General.load_prev_queue") {
+		// load saved queue from previous session
+		add := func(audioFile *player.AudioFile) { queue.add(audioFile) }
+		state, err = queue.restoreQueue(getMusicDir(args), newAudioFile, add)
+		if err != nil {
+			logError(err)
+		}
+		if err := queue.history.load(newAudioFile); err != nil {
+			logError(err)
+		}
+	}
+
+	socketPath := expandFilePath(*args.socket)
+
+	l, err := listenCtl(socketPath)
+	if err != nil {
+		die(err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logError(fmt.Errorf("Received %s. Exiting program", sig.String()))
+		l.Close()
+	}()
+
+	resumePlayback(state)
+
+	if queue.length() > 0 {
+		if err := d.playQueue(); err != nil {
+			logError(err)
+		}
+	}
+
+	serveCtl(l, d)
+
+	if !*args.empty {
+		if err := queue.saveQueue(getPlaybackState(gomu.player)); err != nil {
+			logError(err)
+		}
+		if err := queue.history.save(); err != nil {
+			logError(err)
+		}
+	}
+
+	if err := gomu.library.save(); err != nil {
+		logError(err)
+	}
+	if err := gomu.stats.save(); err != nil {
+		logError(err)
+	}
+
+	os.Remove(socketPath)
+	gomu.hook.RunHooks("exit")
+
+	// completes the file of the wav output
+	if err := gomu.player.Close(); err != nil {
+		logError(err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeController records the commands it receives
+type fakeController struct {
+	commands []string
+	queue    []string
+}
+
+func (f *fakeController) play() error { f.commands = append(f.commands, "play"); return nil }
+func (f *fakeController) pause()      { f.commands = append(f.commands, "pause") }
+func (f *fakeController) next() error { f.commands = append(f.commands, "next"); return nil }
+
+func (f *fakeController) previous() error {
+	f.commands = append(f.commands, "previous")
+	return nil
+}
+
+func (f *fakeController) enqueue(path string) error {
+	f.queue = append(f.queue, path)
+	return nil
+}
+
+func (f *fakeController) status() ctlStatus {
+	return ctlStatus{State: "playing", Queue: f.queue}
+}
+
+func TestHandleCtl(t *testing.T) {
+
+	c := &fakeController{}
+
+	for _, line := range []string{"play", "pause", " next ", "previous"} {
+		res := handleCtl(c, line)
+		if res.Error != "" || res.Status == nil {
+			t.Errorf("%q; unexpected response %+v", line, res)
+		}
+	}
+
+	if !Equal(c.commands, []string{"play", "pause", "next", "previous"}) {
+		t.Errorf("Expected play pause next previous; got %v", c.commands)
+	}
+
+	res := handleCtl(c, "enqueue /music/with space.mp3")
+	if res.Error != "" || !Equal(res.Status.Queue, []string{"/music/with space.mp3"}) {
+		t.Errorf("Unexpected enqueue response %+v", res)
+	}
+
+	for _, line := range []string{"enqueue", "rewind"} {
+		if res := handleCtl(c, line); res.Error == "" || res.Status != nil {
+			t.Errorf("%q; expected error got %+v", line, res)
+		}
+	}
+}
+
+func TestServeCtl(t *testing.T) {
+
+	socketPath := filepath.Join(t.TempDir(), "gomu.sock")
+
+	l, err := listenCtl(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		serveCtl(l, &fakeController{})
+		close(done)
+	}()
+
+	if _, err := listenCtl(socketPath); err == nil {
+		t.Error("Expected error when the socket is in use")
+	}
+
+	res, err := sendCtl(socketPath, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status == nil || res.Status.State != "playing" {
+		t.Errorf("Unexpected status response %+v", res)
+	}
+
+	l.Close()
+	<-done
+
+	if _, err := net.Dial("unix", socketPath); err == nil {
+		t.Error("Socket still accepts connections after closing")
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	printStatus(&buf, ctlStatus{
+		State:    "paused",
+		Song:     "song",
+		Position: 83,
+		Length:   296,
+		Volume:   80,
+		Speed:    1.5,
+		Queue:    []string{"/music/next.mp3"},
+	})
+
+	got := buf.String()
+
+	for _, expected := range []string{"paused: song [01:23/04:56]", "volume: 80%", "speed: 1.5x", "1. next"} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("Expected %q in %q", expected, got)
+		}
+	}
+}
+
+func TestAttachCommand(t *testing.T) {
+
+	samples := []struct {
+		key      rune
+		state    string
+		expected string
+	}{
+		{' ', "playing", "pause"},
+		{' ', "paused", "play"},
+		{' ', "stopped", "play"},
+		{'n', "playing", "next"},
+		{'p', "paused", "previous"},
+		{'x', "playing", ""},
+	}
+
+	for _, s := range samples {
+		if got := attachCommand(s.key, s.state); got != s.expected {
+			t.Errorf("%q while %s; expected %q got %q", s.key, s.state, s.expected, got)
+		}
+	}
+}
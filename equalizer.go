@@ -0,0 +1,260 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/anko"
+	"github.com/issadarkthing/gomu/player"
+)
+
+// customPreset is the name of the preset once a band has been changed
+const customPreset = "custom"
+
+// equalizer holds the settings of the effects applied by the player, they are
+// read from the Equalizer module and changed in the equalizer popup
+type equalizer struct {
+	mu      sync.Mutex
+	enabled bool
+	preset  string
+	eq      *player.Equalizer
+	// from -1 for the left channel only to 1 for the right channel only
+	balance float64
+	mono    bool
+}
+
+func newEqualizer() *equalizer {
+	return &equalizer{
+		preset: "flat",
+		eq:     player.NewEqualizer(nil),
+	}
+}
+
+// Reads the settings from the Equalizer module, the gains override the
+// preset when they are given
+func (e *equalizer) load(env *anko.Anko) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.enabled = env.GetBool("Equalizer.enabled")
+	e.mono = env.GetBool("Equalizer.mono")
+	e.balance = math.Max(-1, math.Min(1, env.GetFloat("Equalizer.balance")))
+
+	e.preset = env.GetString("Equalizer.preset")
+	gains, ok := player.EqualizerPresets[e.preset]
+	if !ok {
+		e.preset = "flat"
+		gains = player.EqualizerPresets["flat"]
+	}
+
+	if custom := env.GetFloats("Equalizer.gains"); len(custom) > 0 {
+		e.preset = customPreset
+		gains = custom
+	}
+
+	e.eq.SetGains(gains)
+}
+
+// Gets the effects to be applied in order
+func (e *equalizer) effects() []player.Effect {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var effects []player.Effect
+
+	if e.enabled {
+		effects = append(effects, e.eq)
+	}
+
+	if e.mono {
+		effects = append(effects, player.Mono{})
+	}
+
+	if e.balance != 0 {
+		effects = append(effects, player.Balance(e.balance))
+	}
+
+	return effects
+}
+
+// Applies the effects to the player
+func (e *equalizer) apply(p *player.Player) {
+	p.SetEffects(e.effects()...)
+}
+
+func (e *equalizer) toggle() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enabled = !e.enabled
+}
+
+func (e *equalizer) toggleMono() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mono = !e.mono
+}
+
+// Moves the balance towards the right channel by delta
+func (e *equalizer) addBalance(delta float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// rounded so that the center is reached again
+	balance := math.Round((e.balance+delta)*10) / 10
+	e.balance = math.Max(-1, math.Min(1, balance))
+}
+
+// Adds delta dB to the gain of the band
+func (e *equalizer) addGain(band int, delta float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.preset = customPreset
+	e.eq.SetGain(band, e.eq.Gains()[band]+delta)
+}
+
+// Switches to the preset after the current one
+func (e *equalizer) nextPreset() {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	names := player.EqualizerPresetNames()
+
+	next := names[0]
+	for i, name := range names {
+		if name == e.preset && i+1 < len(names) {
+			next = names[i+1]
+		}
+	}
+
+	e.preset = next
+	e.eq.SetGains(player.EqualizerPresets[next])
+}
+
+// Draws the gains of the bands, the selected band is highlighted
+func (e *equalizer) draw(selected int) string {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var text strings.Builder
+
+	status := "off"
+	if e.enabled {
+		status = "on"
+	}
+
+	fmt.Fprintf(&text, "equalizer %s, preset %s\n\n", status, e.preset)
+
+	// every dB is a cell on each side of the center
+	width := int(player.MaxEqualizerGain)
+
+	for band, gain := range e.eq.Gains() {
+
+		cells := int(math.Round(math.Abs(gain)))
+		left := strings.Repeat(" ", width)
+		right := strings.Repeat(" ", width)
+
+		if gain < 0 {
+			left = strings.Repeat(" ", width-cells) + strings.Repeat("█", cells)
+		} else {
+			right = strings.Repeat("█", cells) + strings.Repeat(" ", width-cells)
+		}
+
+		line := fmt.Sprintf("%6s %s|%s %+5.1f dB",
+			bandName(player.EqualizerBands[band]), left, right, gain)
+
+		if band == selected {
+			line = fmt.Sprintf("[%s]%s[-]", gomu.colors.accent.String(), line)
+		}
+
+		fmt.Fprintln(&text, line)
+	}
+
+	mono := "stereo"
+	if e.mono {
+		mono = "mono"
+	}
+
+	fmt.Fprintf(&text, "\nbalance %+.1f, %s\n\n", e.balance, mono)
+	fmt.Fprint(&text, "j/k band  h/l gain  e on/off  p preset\n</> balance  M mono")
+
+	return text.String()
+}
+
+// Formats the frequency in Hz of the band, e.g. 125 or 2k
+func bandName(freq float64) string {
+	if freq >= 1000 {
+		return fmt.Sprintf("%gk", freq/1000)
+	}
+	return fmt.Sprintf("%g", freq)
+}
+
+// Shows the gains of the bands, the changes are applied while the song plays
+func equalizerPopup() {
+
+	popupID := "equalizer-popup"
+	selected := 0
+
+	textView := tview.NewTextView().SetDynamicColors(true)
+	textView.SetBackgroundColor(gomu.colors.popup).
+		SetBorder(true).
+		SetTitle(" Equalizer ").
+		SetBorderPadding(1, 1, 2, 2)
+
+	redraw := func() {
+		textView.SetText(gomu.equalizer.draw(selected))
+	}
+
+	redraw()
+
+	textView.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			if selected < len(player.EqualizerBands)-1 {
+				selected++
+			}
+		case 'k':
+			if selected > 0 {
+				selected--
+			}
+		case 'h':
+			gomu.equalizer.addGain(selected, -1)
+		case 'l':
+			gomu.equalizer.addGain(selected, 1)
+		case '<':
+			gomu.equalizer.addBalance(-0.1)
+		case '>':
+			gomu.equalizer.addBalance(0.1)
+		case 'e':
+			gomu.equalizer.toggle()
+		case 'p':
+			gomu.equalizer.nextPreset()
+		case 'M':
+			gomu.equalizer.toggleMono()
+		}
+
+		if e.Key() == tcell.KeyEsc {
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+			return nil
+		}
+
+		gomu.equalizer.apply(gomu.player)
+		redraw()
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(textView, 50, 22), true, true)
+	gomu.popups.push(textView)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func TestEqualizerEffects(t *testing.T) {
+
+	e := newEqualizer()
+
+	if effects := e.effects(); len(effects) != 0 {
+		t.Errorf("Expected no effects by default; got %v", effects)
+	}
+
+	e.toggle()
+	e.toggleMono()
+	e.addBalance(-0.3)
+
+	effects := e.effects()
+	if len(effects) != 3 {
+		t.Fatalf("Expected 3 effects; got %v", effects)
+	}
+
+	if effects[0] != e.eq {
+		t.Errorf("Expected equalizer first; got %v", effects[0])
+	}
+
+	if _, ok := effects[1].(player.Mono); !ok {
+		t.Errorf("Expected mono downmix second; got %v", effects[1])
+	}
+
+	if balance, ok := effects[2].(player.Balance); !ok || balance != -0.3 {
+		t.Errorf("Expected balance -0.3 last; got %v", effects[2])
+	}
+
+	// back to the center
+	e.addBalance(0.1)
+	e.addBalance(0.1)
+	e.addBalance(0.1)
+
+	if e.balance != 0 {
+		t.Errorf("Expected balance to be centered; got %v", e.balance)
+	}
+
+	e.addBalance(-5)
+	if e.balance != -1 {
+		t.Errorf("Expected balance to be limited to -1; got %v", e.balance)
+	}
+}
+
+func TestEqualizerPresets(t *testing.T) {
+
+	e := newEqualizer()
+	names := player.EqualizerPresetNames()
+
+	e.addGain(0, 3)
+	if e.preset != customPreset || e.eq.Gains()[0] != 3 {
+		t.Errorf("Expected custom preset with 3 dB; got %s %v", e.preset, e.eq.Gains())
+	}
+
+	// a custom preset switches to the first preset
+	for _, name := range append(names, names[0]) {
+		e.nextPreset()
+		if e.preset != name {
+			t.Fatalf("Expected preset %s; got %s", name, e.preset)
+		}
+		if !floatsEqual(e.eq.Gains(), player.EqualizerPresets[name]) {
+			t.Errorf("Expected gains of %s; got %v", name, e.eq.Gains())
+		}
+	}
+}
+
+func floatsEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
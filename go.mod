@@ -1,6 +1,6 @@
 module github.com/issadarkthing/gomu
 
-go 1.14
+go 1.16
 
 require (
 	github.com/BurntSushi/xgb v0.0.0-20210121224620-deaf085860bc // indirect
@@ -16,6 +16,7 @@ require (
 	github.com/gdamore/tcell/v2 v2.5.4
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/gocolly/colly v1.2.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
 	github.com/hajimehoshi/oto v1.0.1 // indirect
@@ -31,15 +31,25 @@ type Gomu struct {
 	args      Args
 	anko      *anko.Anko
 	hook      *hook.EventHook
+	library   *library
+	stats     *playStats
+	equalizer *equalizer
+	bookmarks *bookmarks
+	abRepeat  *abRepeat
 }
 
 // Creates new instance of gomu with default values
 func newGomu() *Gomu {
 
 	gomu := &Gomu{
-		command: newCommand(),
-		anko:    anko.NewAnko(),
-		hook:    hook.NewEventHook(),
+		command:   newCommand(),
+		anko:      anko.NewAnko(),
+		hook:      hook.NewEventHook(),
+		library:   newLibrary(),
+		stats:     newPlayStats(),
+		equalizer: newEqualizer(),
+		bookmarks: newBookmarks(),
+		abRepeat:  &abRepeat{},
 	}
 
 	return gomu
@@ -52,12 +62,78 @@ func (g *Gomu) initPanels(app *tview.Application, args Args) {
 	g.app = app
 	g.playingBar = newPlayingBar()
 	g.queue = newQueue()
+	if err := g.library.load(); err != nil {
+		logError(err)
+	}
+	if err := g.stats.load(getStatsPath()); err != nil {
+		logError(err)
+	}
+	if err := g.bookmarks.load(getBookmarksPath()); err != nil {
+		logError(err)
+	}
 	g.playlist = newPlaylist(args)
-	g.player = player.New(g.anko.GetInt("General.volume"))
+	g.player = newPlayer()
+	g.configPlayer()
 	g.pages = tview.NewPages()
 	g.panels = []Panel{g.playlist, g.queue, g.playingBar}
 }
 
+// Creates the player playing to the output from the config, the speaker
+// is used when the output cannot be created
+func newPlayer() *player.Player {
+
+	p := player.New(gomu.anko.GetInt("General.volume"))
+
+	output, err := getOutput(p)
+	if err != nil {
+		logError(err)
+		output = player.NewSpeaker()
+	}
+
+	p.SetOutput(output, getSampleRate(), getBufferSize())
+
+	return p
+}
+
+// Applies playback settings from the config
+func (g *Gomu) configPlayer() {
+	g.player.SetCrossfade(getCrossfade())
+	g.player.SetReplayGain(
+		getReplayGainMode(),
+		g.anko.GetFloat("General.replaygain_preamp"),
+		g.anko.GetBool("General.replaygain_prevent_clip"),
+	)
+	g.player.SetPreservePitch(g.anko.GetBool("General.preserve_pitch"))
+	g.player.SetSpeed(getSpeed())
+	g.equalizer.load(g.anko)
+	g.equalizer.apply(g.player)
+}
+
+// Pauses the player and runs the pause hook
+func (g *Gomu) pause() {
+	if g.player.IsRunning() {
+		g.player.Pause()
+		g.hook.RunHooks("pause")
+	}
+}
+
+// Resumes the player and runs the play hook
+func (g *Gomu) resume() {
+	if g.player.IsPaused() {
+		g.player.Play()
+		g.hook.RunHooks("play")
+	}
+}
+
+// Toggles between pause and play
+func (g *Gomu) togglePause() {
+	if g.player.IsPaused() {
+		g.resume()
+	} else {
+		g.pause()
+	}
+}
+
 // Cycle between panels
 func (g *Gomu) cyclePanels() Panel {
 
@@ -144,6 +220,22 @@ func (g *Gomu) quit(args Args) error {
 		if err != nil {
 			return tracerr.Wrap(err)
 		}
+
+		err = gomu.queue.history.save()
+		if err != nil {
+			return tracerr.Wrap(err)
+		}
+	}
+
+	// songs outside of the music directory may have been indexed
+	err := gomu.library.save()
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = gomu.stats.save()
+	if err != nil {
+		return tracerr.Wrap(err)
 	}
 
 	gomu.app.Stop()
@@ -0,0 +1,204 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// historyLimit is the number of played songs remembered
+const historyLimit = 100
+
+// defaultPreviousRestart is used when previous_restart is not set
+const defaultPreviousRestart = 3 * time.Second
+
+// playHistory is a bounded stack of the songs played before the current one
+type playHistory struct {
+	mu        sync.Mutex
+	savedPath string
+	// oldest first
+	items []*player.AudioFile
+	// the song left by going back is not recorded
+	rewinding bool
+}
+
+// Records the song which has finished playing, returns false if the song was
+// left by going back
+func (h *playHistory) push(audioFile *player.AudioFile) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.rewinding {
+		h.rewinding = false
+		return false
+	}
+
+	h.items = append(h.items, audioFile)
+	if len(h.items) > historyLimit {
+		h.items = h.items[len(h.items)-historyLimit:]
+	}
+
+	return true
+}
+
+// Removes the last played song and returns it
+func (h *playHistory) pop() *player.AudioFile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.items) == 0 {
+		return nil
+	}
+
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+
+	return last
+}
+
+// Returns the played songs, the last played first
+func (h *playHistory) songs() []*player.AudioFile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	songs := make([]*player.AudioFile, 0, len(h.items))
+	for i := len(h.items) - 1; i >= 0; i-- {
+		songs = append(songs, h.items[i])
+	}
+
+	return songs
+}
+
+// Saves the paths of the played songs, one per line
+func (h *playHistory) save() error {
+
+	h.mu.Lock()
+	var content strings.Builder
+	for _, audioFile := range h.items {
+		content.WriteString(audioFile.Path() + "\n")
+	}
+	h.mu.Unlock()
+
+	savedPath := expandTilde(h.savedPath)
+
+	if err := os.MkdirAll(filepath.Dir(savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err := ioutil.WriteFile(savedPath, []byte(content.String()), 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
+
+// Loads the history saved by the previous session, songs which cannot be
+// found anymore are dropped
+func (h *playHistory) load(find func(songPath string) (*player.AudioFile, error)) error {
+
+	f, err := os.Open(expandTilde(h.savedPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	var items []*player.AudioFile
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		audioFile, err := find(scanner.Text())
+		if err != nil {
+			logError(err)
+			continue
+		}
+		items = append(items, audioFile)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	h.mu.Lock()
+	h.items = append(items, h.items...)
+	if len(h.items) > historyLimit {
+		h.items = h.items[len(h.items)-historyLimit:]
+	}
+	h.mu.Unlock()
+
+	return nil
+}
+
+// Gets the duration after which previous restarts the current song, configs
+// written before previous_restart existed get the default
+func getPreviousRestart() time.Duration {
+
+	dur := gomu.anko.GetString("General.previous_restart")
+	if dur == "" {
+		return defaultPreviousRestart
+	}
+
+	m, err := time.ParseDuration(dur)
+	if err != nil {
+		logError(err)
+		return defaultPreviousRestart
+	}
+
+	return m
+}
+
+// Goes back to the previous song. The current song is restarted instead if
+// it has played longer than previous_restart or if there is no history.
+// Otherwise the current and the previous song are put back to the front of
+// the queue, pushFront and playQueue are those of the queue being played.
+func playPrevious(queue *songQueue, pushFront func(*player.AudioFile), playQueue func() error) error {
+
+	p := gomu.player
+	active := p.IsRunning() || p.IsPaused()
+
+	if active && p.GetPosition() > getPreviousRestart() {
+		return tracerr.Wrap(p.Seek(0))
+	}
+
+	prev := queue.history.pop()
+
+	if prev == nil {
+		if active {
+			return tracerr.Wrap(p.Seek(0))
+		}
+		return nil
+	}
+
+	if !active {
+		pushFront(prev)
+		return tracerr.Wrap(playQueue())
+	}
+
+	if current, ok := p.GetCurrentSong().(*player.AudioFile); ok {
+		pushFront(current)
+	}
+	pushFront(prev)
+
+	// the current song is neither recorded nor looped, the finish callback
+	// runs after Skip has returned and clears the flag
+	queue.history.mu.Lock()
+	queue.history.rewinding = true
+	queue.history.mu.Unlock()
+
+	p.Skip()
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func TestPlayHistory(t *testing.T) {
+
+	h := &playHistory{}
+	songs := newTestSongs("a", "b", "c")
+
+	if h.pop() != nil {
+		t.Error("Expected nil from empty history")
+	}
+
+	for _, song := range songs {
+		h.push(song)
+	}
+
+	if got := songNames(h.songs()); !Equal(got, []string{"c", "b", "a"}) {
+		t.Errorf("Expected c b a; got %v", got)
+	}
+
+	// the song left by going back is not recorded
+	h.rewinding = true
+	if h.push(songs[0]) {
+		t.Error("Expected the song left by going back not to be recorded")
+	}
+
+	if popped := h.pop(); popped != songs[2] {
+		t.Errorf("Expected c to be popped; got %v", popped)
+	}
+
+	if got := songNames(h.songs()); !Equal(got, []string{"b", "a"}) {
+		t.Errorf("Expected b a; got %v", got)
+	}
+
+	for i := 0; i < historyLimit; i++ {
+		h.push(songs[2])
+	}
+
+	if got := h.songs(); len(got) != historyLimit || got[len(got)-1] != songs[2] {
+		t.Errorf("Expected history to be trimmed to %d songs", historyLimit)
+	}
+}
+
+func TestPlayHistorySave(t *testing.T) {
+
+	savedPath := filepath.Join(t.TempDir(), "gomu", "history.cache")
+	songs := newTestSongs("a", "b", "c")
+
+	h := &playHistory{savedPath: savedPath}
+	for _, song := range songs {
+		h.push(song)
+	}
+
+	if err := h.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(map[string]*player.AudioFile)
+	for _, song := range songs[1:] {
+		paths[song.Path()] = song
+	}
+
+	loaded := &playHistory{savedPath: savedPath}
+	err := loaded.load(func(songPath string) (*player.AudioFile, error) {
+		if audioFile, ok := paths[songPath]; ok {
+			return audioFile, nil
+		}
+		return nil, os.ErrNotExist
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// songs which cannot be found are dropped
+	if got := songNames(loaded.songs()); !Equal(got, []string{"c", "b"}) {
+		t.Errorf("Expected c b; got %v", got)
+	}
+}
+
+func TestPreviousRestart(t *testing.T) {
+
+	gomu = newGomu()
+
+	// configs written before the key existed
+	if _, err := gomu.anko.Execute("module General {\n volume = 80\n}"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getPreviousRestart(); got != defaultPreviousRestart {
+		t.Errorf("Expected %v when unset; got %v", defaultPreviousRestart, got)
+	}
+}
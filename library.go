@@ -0,0 +1,375 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// libraryVersion is increased whenever libraryEntry changes, older indexes
+// are rebuilt
+const libraryVersion = 3
+
+// libraryEntry is the indexed information of a file in the music directory
+type libraryEntry struct {
+	ModTime int64
+	Size    int64
+	// files which are not playable are indexed as well so that they are not
+	// sniffed again
+	Format      player.AudioFormat
+	Title       string
+	Artist      string
+	Album       string
+	TrackNumber int
+	Genre       string
+	Year        int
+	Rating      int
+	Length      time.Duration
+	// when the file was first indexed, kept when the file is modified or
+	// moved
+	Added int64
+}
+
+// savedLibrary is the content of the library cache
+type savedLibrary struct {
+	Version int
+	Entries map[string]*libraryEntry
+}
+
+// library indexes the audio files by path so that they are only opened when
+// they have changed
+type library struct {
+	mu        sync.Mutex
+	savedPath string
+	entries   map[string]*libraryEntry
+	// paths seen during the current scan, nil when not scanning
+	seen  map[string]bool
+	dirty bool
+	// the index is being built from scratch, the files are considered added
+	// when they were last modified rather than now
+	rebuilding bool
+}
+
+// Initiliaze new library index saved in the cache directory
+func newLibrary() *library {
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logError(err)
+	}
+
+	return &library{
+		savedPath:  filepath.Join(cacheDir, "gomu", "library.cache"),
+		entries:    make(map[string]*libraryEntry),
+		rebuilding: true,
+	}
+}
+
+// Loads the index saved by the previous session
+func (l *library) load() error {
+
+	f, err := os.Open(expandTilde(l.savedPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	var saved savedLibrary
+	if err := gob.NewDecoder(f).Decode(&saved); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	// rebuilt from scratch
+	if saved.Version != libraryVersion || saved.Entries == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	l.entries = saved.Entries
+	l.rebuilding = false
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Saves the index if it has changed
+func (l *library) save() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.dirty {
+		return nil
+	}
+
+	savedPath := expandTilde(l.savedPath)
+
+	if err := os.MkdirAll(filepath.Dir(savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	// written to a temporary file so that a crash does not corrupt the index
+	tmpPath := savedPath + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	saved := savedLibrary{Version: libraryVersion, Entries: l.entries}
+
+	if err := gob.NewEncoder(f).Encode(saved); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return tracerr.Wrap(err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.Rename(tmpPath, savedPath); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	l.dirty = false
+
+	return nil
+}
+
+// Gets the entry of the file, the file is only scanned if it is not indexed
+// or if it has changed since
+func (l *library) lookup(songPath string, info os.FileInfo) *libraryEntry {
+
+	l.mu.Lock()
+	entry, ok := l.entries[songPath]
+	if ok && entry.ModTime == info.ModTime().UnixNano() && entry.Size == info.Size() {
+		if l.seen != nil {
+			l.seen[songPath] = true
+		}
+		l.mu.Unlock()
+		return entry
+	}
+	rebuilding := l.rebuilding
+	l.mu.Unlock()
+
+	added := time.Now().UnixNano()
+	if ok {
+		added = entry.Added
+	} else if rebuilding {
+		added = info.ModTime().UnixNano()
+	}
+
+	entry = scanLibraryEntry(songPath, info)
+	entry.Added = added
+
+	l.mu.Lock()
+	l.entries[songPath] = entry
+	if l.seen != nil {
+		l.seen[songPath] = true
+	}
+	l.dirty = true
+	l.mu.Unlock()
+
+	return entry
+}
+
+// Gets the entry of the file at the path
+func (l *library) lookupPath(songPath string) (*libraryEntry, error) {
+
+	info, err := os.Stat(songPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return l.lookup(songPath, info), nil
+}
+
+// Gets the indexed entry of the file without checking whether it has
+// changed
+func (l *library) indexed(songPath string) (*libraryEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[songPath]
+	return entry, ok
+}
+
+// Gets the entries of the playable files by path
+func (l *library) songs() map[string]*libraryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	songs := make(map[string]*libraryEntry)
+	for songPath, entry := range l.entries {
+		if entry.Format.Playable() {
+			songs[songPath] = entry
+		}
+	}
+
+	return songs
+}
+
+// Removes the entries of the file or of the files under the directory
+func (l *library) remove(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for songPath := range l.entries {
+		if isUnder(path, songPath) {
+			delete(l.entries, songPath)
+			l.dirty = true
+		}
+	}
+}
+
+// Moves the entries of the renamed file or directory so that the files are
+// not scanned again
+func (l *library) rename(oldPath, newPath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	moved := make(map[string]*libraryEntry)
+
+	for songPath, entry := range l.entries {
+		if isUnder(oldPath, songPath) {
+			delete(l.entries, songPath)
+			moved[newPath+strings.TrimPrefix(songPath, oldPath)] = entry
+		}
+	}
+
+	for songPath, entry := range moved {
+		l.entries[songPath] = entry
+		l.dirty = true
+	}
+}
+
+// Opens the file to read its format, tags and length
+func scanLibraryEntry(songPath string, info os.FileInfo) *libraryEntry {
+
+	entry := &libraryEntry{
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+	}
+
+	format, err := player.DetectFileFormat(songPath)
+	if err != nil || !format.Playable() {
+		return entry
+	}
+
+	entry.Format = format
+
+	if tag, err := player.OpenTag(songPath); err == nil {
+		entry.Title = tag.Title()
+		entry.Artist = tag.Artist()
+		entry.Album = tag.Album()
+		entry.TrackNumber = tag.TrackNumber()
+		entry.Genre = tag.Genre()
+		entry.Year = tag.Year()
+		entry.Rating = tag.Rating()
+		entry.Length = tag.Length()
+		tag.Close()
+	}
+
+	// a scan never writes to the files, a length which is not in the tag is
+	// only kept in the index
+	if entry.Length == 0 {
+		entry.Length, err = player.GetLength(songPath)
+		if err != nil {
+			logError(err)
+		}
+	}
+
+	return entry
+}
+
+// Starts a scan of the whole music directory
+func (l *library) beginScan() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen = make(map[string]bool)
+}
+
+// Ends the scan of the directory, the entries of the files under it which
+// were not seen are removed. Songs outside of it stay indexed.
+func (l *library) endScan(rootPath string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rootPath = filepath.Clean(rootPath)
+
+	for songPath := range l.entries {
+		if !l.seen[songPath] && isUnder(rootPath, songPath) {
+			delete(l.entries, songPath)
+			l.dirty = true
+		}
+	}
+
+	l.seen = nil
+	l.rebuilding = false
+}
+
+// Ends the scan without removing any entry
+func (l *library) abortScan() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seen = nil
+}
+
+// Populates the tree from the music directory using the library index which
+// is updated with the changes since the last scan
+func populateLibrary(root *tview.TreeNode, rootPath string, sortMtime bool) error {
+
+	gomu.library.beginScan()
+
+	err := populate(root, rootPath, sortMtime)
+
+	// nothing was seen if the music directory could not be read
+	if err != nil {
+		gomu.library.abortScan()
+		return tracerr.Wrap(err)
+	}
+
+	gomu.library.endScan(rootPath)
+
+	return tracerr.Wrap(gomu.library.save())
+}
+
+// Updates the index with the files under the music directory without
+// populating a tree, the daemon has no playlist
+func (l *library) scan(rootPath string) error {
+
+	l.beginScan()
+
+	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && player.HasSupportedExt(path) {
+			l.lookup(path, info)
+		}
+		return nil
+	})
+
+	if err != nil {
+		l.abortScan()
+		return tracerr.Wrap(err)
+	}
+
+	l.endScan(rootPath)
+
+	return tracerr.Wrap(l.save())
+}
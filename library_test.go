@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLibrary(t *testing.T) {
+
+	dir := t.TempDir()
+	l := &library{
+		savedPath:  filepath.Join(dir, "gomu", "library.cache"),
+		entries:    make(map[string]*libraryEntry),
+		rebuilding: true,
+	}
+
+	songPath := filepath.Join(dir, "song.mp3")
+	otherPath := filepath.Join(dir, "other.mp3")
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, p := range []string{songPath, otherPath} {
+		if err := ioutil.WriteFile(p, []byte("not audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l.beginScan()
+
+	entry, err := l.lookupPath(songPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Format.Playable() {
+		t.Error("Expected text file to be unplayable")
+	}
+
+	if _, err := l.lookupPath(otherPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// files found while building the index are added when last modified
+	if entry.Added != mtime.UnixNano() {
+		t.Errorf("Expected %s to be added at %v; got %v", songPath, mtime, time.Unix(0, entry.Added))
+	}
+
+	l.endScan(dir)
+
+	if err := l.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &library{savedPath: l.savedPath, entries: make(map[string]*libraryEntry)}
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.entries) != 2 {
+		t.Fatalf("Expected 2 entries; got %d", len(loaded.entries))
+	}
+
+	cached := loaded.entries[songPath]
+
+	// unchanged files are not scanned again
+	if got, _ := loaded.lookupPath(songPath); got != cached {
+		t.Error("Expected unchanged file to be taken from the index")
+	}
+
+	if err := os.Chtimes(songPath, mtime, mtime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := loaded.lookupPath(songPath)
+	if got == cached {
+		t.Error("Expected modified file to be scanned again")
+	}
+
+	if got.Added != cached.Added {
+		t.Error("Expected modified file to keep the time it was added")
+	}
+
+	// songs outside of the music directory, e.g. of the queue, are kept
+	outsidePath := filepath.Join(t.TempDir(), "outside.mp3")
+	if err := ioutil.WriteFile(outsidePath, []byte("not audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.lookupPath(outsidePath); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries of removed files are dropped by a scan
+	loaded.beginScan()
+	loaded.lookupPath(songPath)
+	loaded.endScan(dir)
+
+	if _, ok := loaded.entries[otherPath]; ok || len(loaded.entries) != 2 {
+		t.Errorf("Expected only %s and %s to be left; got %v", songPath, outsidePath, loaded.entries)
+	}
+}
+
+func TestLibraryUnplayable(t *testing.T) {
+
+	dir := t.TempDir()
+	l := &library{
+		savedPath: filepath.Join(dir, "gomu", "library.cache"),
+		entries:   make(map[string]*libraryEntry),
+	}
+
+	// mp4 files are only detected for the tag editor, nothing plays them
+	songPath := filepath.Join(dir, "song.m4a")
+	if err := ioutil.WriteFile(songPath, []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := l.lookupPath(songPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Format.Playable() || entry.Title != "" {
+		t.Errorf("Expected mp4 to be indexed as unplayable without tags; got %+v", entry)
+	}
+
+	if songs := l.songs(); len(songs) != 0 {
+		t.Errorf("Expected no playable song; got %v", songs)
+	}
+}
+
+func TestScanLibraryEntryReadOnly(t *testing.T) {
+
+	content, err := ioutil.ReadFile("./test/rap/audio_test.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songPath := filepath.Join(t.TempDir(), "song.mp3")
+	if err := ioutil.WriteFile(songPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(songPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanLibraryEntry(songPath, info)
+
+	// the length is not embedded into the tag
+	scanned, err := ioutil.ReadFile(songPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(scanned, content) {
+		t.Error("Expected the scan to leave the file untouched")
+	}
+}
@@ -13,6 +13,17 @@ import (
 func main() {
 	setupLog()
 	os.Setenv("TEST", "false")
+
+	// control a running daemon
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+
+	// show a running daemon in the terminal
+	if len(os.Args) > 1 && os.Args[1] == "attach" {
+		os.Exit(runAttach(os.Args[2:]))
+	}
+
 	args := getArgs()
 
 	app := tview.NewApplication()
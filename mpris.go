@@ -0,0 +1,252 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/mpris"
+	"github.com/issadarkthing/gomu/player"
+)
+
+// tuiController drives the queue and the player of the tui from external
+// controls such as mpris. The commands are run on the goroutine of the tui
+// as they change its widgets
+type tuiController struct{}
+
+// Runs f on the goroutine of the tui and waits for it to return, it must not
+// be called from that goroutine
+func onUI(f func() error) error {
+	done := make(chan error, 1)
+	gomu.app.QueueUpdateDraw(func() {
+		done <- f()
+	})
+	return <-done
+}
+
+func (tuiController) play() error {
+	return onUI(func() error {
+
+		if gomu.player.IsPaused() {
+			gomu.resume()
+			return nil
+		}
+
+		if !gomu.player.IsRunning() && len(gomu.queue.items) > 0 {
+			return tracerr.Wrap(gomu.queue.playQueue())
+		}
+
+		return nil
+	})
+}
+
+func (tuiController) pause() {
+	onUI(func() error {
+		gomu.pause()
+		return nil
+	})
+}
+
+func (tuiController) next() error {
+	return onUI(func() error {
+
+		if gomu.player.IsRunning() || gomu.player.IsPaused() {
+			gomu.player.Skip()
+			return nil
+		}
+
+		if len(gomu.queue.items) > 0 {
+			return tracerr.Wrap(gomu.queue.playQueue())
+		}
+
+		return nil
+	})
+}
+
+func (tuiController) previous() error {
+	return onUI(func() error {
+		return playPrevious(gomu.queue.songQueue, gomu.queue.pushFront, gomu.queue.playQueue)
+	})
+}
+
+func (tuiController) enqueue(songPath string) error {
+
+	// resolving a stream may take a while, the tui is not blocked meanwhile
+	audioFiles, err := findAudioFiles(songPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return onUI(func() error {
+
+		for _, audioFile := range audioFiles {
+			if _, err := gomu.queue.enqueue(audioFile); err != nil {
+				logError(err)
+			}
+		}
+
+		if !gomu.player.IsRunning() && !gomu.player.IsPaused() && len(gomu.queue.items) > 0 {
+			return tracerr.Wrap(gomu.queue.playQueue())
+		}
+
+		return nil
+	})
+}
+
+func (tuiController) status() ctlStatus {
+	return newCtlStatus(gomu.player, gomu.queue.songQueue)
+}
+
+// mprisPlayer adapts the controller to the mpris interface
+type mprisPlayer struct {
+	ctl controller
+
+	// tags of the current song are only read once
+	mu       sync.Mutex
+	metadata mpris.Metadata
+	// title of the stream the metadata was made with
+	streamTitle string
+}
+
+func (m *mprisPlayer) Play() error {
+	return m.ctl.play()
+}
+
+func (m *mprisPlayer) Pause() error {
+	m.ctl.pause()
+	return nil
+}
+
+func (m *mprisPlayer) Next() error {
+	return m.ctl.next()
+}
+
+func (m *mprisPlayer) Previous() error {
+	return m.ctl.previous()
+}
+
+// Stop pauses and rewinds as songs cannot be unloaded
+func (m *mprisPlayer) Stop() error {
+
+	if m.ctl.status().State == "stopped" {
+		return nil
+	}
+
+	m.ctl.pause()
+
+	return tracerr.Wrap(gomu.player.Seek(0))
+}
+
+func (m *mprisPlayer) SetPosition(position time.Duration) error {
+
+	if m.ctl.status().State == "stopped" {
+		return nil
+	}
+
+	return tracerr.Wrap(gomu.player.Seek(position))
+}
+
+func (m *mprisPlayer) SetVolume(volume float64) error {
+	target := player.AbsVolume(int(volume*100 + 0.5))
+	gomu.player.SetVolume(target - gomu.player.GetVolume())
+	return nil
+}
+
+func (m *mprisPlayer) OpenURI(songPath string) error {
+	return m.ctl.enqueue(songPath)
+}
+
+func (m *mprisPlayer) Status() mpris.Status {
+
+	status := m.ctl.status()
+
+	playback := mpris.Stopped
+	switch status.State {
+	case "playing":
+		playback = mpris.Playing
+	case "paused":
+		playback = mpris.Paused
+	}
+
+	return mpris.Status{
+		Playback: playback,
+		Position: time.Duration(status.Position * float64(time.Second)),
+		Volume:   float64(status.Volume) / 100,
+		Rate:     status.Speed,
+		Loop:     status.Loop,
+		Metadata: m.songMetadata(status),
+	}
+}
+
+// Reads the tags of the current song
+func (m *mprisPlayer) songMetadata(status ctlStatus) mpris.Metadata {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.metadata.Path == status.Path && m.streamTitle == status.Title {
+		return m.metadata
+	}
+
+	metadata := mpris.Metadata{
+		Path:   status.Path,
+		Title:  status.Song,
+		Length: time.Duration(status.Length * float64(time.Second)),
+	}
+
+	if status.Path != "" {
+		tag, err := player.OpenTag(status.Path)
+		if err == nil {
+			if tag.Title() != "" {
+				metadata.Title = tag.Title()
+			}
+			metadata.Artist = tag.Artist()
+			metadata.Album = tag.Album()
+			metadata.TrackNumber = tag.TrackNumber()
+			tag.Close()
+		}
+	}
+
+	// the station of a stream sends the title of the song it plays
+	if status.Title != "" {
+		metadata.Title = status.Title
+		metadata.Album = status.Song
+	}
+
+	m.metadata = metadata
+	m.streamTitle = status.Title
+
+	return metadata
+}
+
+// Registers gomu on the session bus, the hooks keep the mpris clients up to
+// date
+func setupMpris(ctl controller) {
+
+	if !gomu.anko.GetBool("General.mpris") {
+		return
+	}
+
+	server, err := mpris.New(&mprisPlayer{ctl: ctl})
+	if err != nil {
+		logError(err)
+		return
+	}
+
+	for _, event := range []string{"new_song", "play", "pause", "skip"} {
+		gomu.hook.AddHook(event, server.Changed)
+	}
+
+	// jumps and volume changes come from the keys as well as from mpris
+	gomu.player.OnEvent(func(e player.Event) {
+		switch e := e.(type) {
+		case player.Seeked:
+			server.Seeked(e.Position)
+		case player.VolumeChanged:
+			server.Changed()
+		}
+	})
+}
@@ -0,0 +1,544 @@
+// Copyright (C) 2020  Raziman
+
+// Package mpris exposes the player on the session bus with the MPRIS2
+// interface so that media keys, status bars and playerctl can control it.
+package mpris
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/ztrue/tracerr"
+)
+
+const (
+	busName         = "org.mpris.MediaPlayer2.gomu"
+	objectPath      = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	ifaceRoot       = "org.mpris.MediaPlayer2"
+	ifacePlayer     = "org.mpris.MediaPlayer2.Player"
+	ifaceProperties = "org.freedesktop.DBus.Properties"
+	ifaceIntrospect = "org.freedesktop.DBus.Introspectable"
+)
+
+// playback status
+const (
+	Playing = "Playing"
+	Paused  = "Paused"
+	Stopped = "Stopped"
+)
+
+// the range of the playback speed
+const (
+	MinimumRate = 0.5
+	MaximumRate = 2.0
+)
+
+// noTrack is the track id when nothing is playing.
+const noTrack = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+
+// Metadata describes the current song.
+type Metadata struct {
+	Path        string
+	Title       string
+	Artist      string
+	Album       string
+	TrackNumber int
+	Length      time.Duration
+}
+
+// Status is the state of the player.
+type Status struct {
+	// Playing, Paused or Stopped
+	Playback string
+	Position time.Duration
+	// volume between 0 and 1
+	Volume float64
+	// playback speed, 1 is the normal speed
+	Rate     float64
+	Loop     bool
+	Metadata Metadata
+}
+
+// Player is controlled by the MPRIS clients.
+type Player interface {
+	Play() error
+	Pause() error
+	Next() error
+	Previous() error
+	// Stop stops playing, play starts from the beginning afterwards.
+	Stop() error
+	SetPosition(position time.Duration) error
+	SetVolume(volume float64) error
+	// OpenURI plays the file of a file uri, which is passed as a path, or
+	// the stream of a http uri.
+	OpenURI(uri string) error
+	Status() Status
+}
+
+// Server serves the MPRIS interfaces on the session bus.
+type Server struct {
+	bus    *dbus.Conn
+	player Player
+
+	mu   sync.Mutex
+	last map[string]dbus.Variant
+}
+
+// New connects to the session bus and registers the player.
+func New(player Player) (*Server, error) {
+
+	bus, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return newServer(bus, player)
+}
+
+// NewWithAddress registers the player on the bus at the address.
+func NewWithAddress(address string, player Player) (*Server, error) {
+
+	bus, err := dbus.Connect(address)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return newServer(bus, player)
+}
+
+func newServer(bus *dbus.Conn, player Player) (*Server, error) {
+
+	s := &Server{bus: bus, player: player}
+	s.last = s.playerProperties()
+
+	tables := map[string]map[string]interface{}{
+		ifaceRoot:       s.rootMethods(),
+		ifacePlayer:     s.playerMethods(),
+		ifaceProperties: s.propertiesMethods(),
+	}
+
+	for iface, methods := range tables {
+		if err := bus.ExportMethodTable(methods, objectPath, iface); err != nil {
+			bus.Close()
+			return nil, tracerr.Wrap(err)
+		}
+	}
+
+	err := bus.Export(introspect.Introspectable(introspection), objectPath, ifaceIntrospect)
+	if err != nil {
+		bus.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	// replace any existing owner such as a previous instance of gomu
+	reply, err := bus.RequestName(busName,
+		dbus.NameFlagAllowReplacement|dbus.NameFlagReplaceExisting)
+	if err != nil {
+		bus.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		bus.Close()
+		return nil, tracerr.Errorf("unable to own %s", busName)
+	}
+
+	return s, nil
+}
+
+// Close releases the bus name.
+func (s *Server) Close() error {
+	return s.bus.Close()
+}
+
+// Changed emits PropertiesChanged for the player properties which have
+// changed since the last call.
+func (s *Server) Changed() {
+
+	props := s.playerProperties()
+
+	s.mu.Lock()
+	changed := make(map[string]dbus.Variant)
+	for name, v := range props {
+		// position is not tracked by PropertiesChanged
+		if name == "Position" {
+			continue
+		}
+		if !reflect.DeepEqual(v, s.last[name]) {
+			changed[name] = v
+		}
+	}
+	s.last = props
+	s.mu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+
+	s.bus.Emit(objectPath, ifaceProperties+".PropertiesChanged",
+		ifacePlayer, changed, []string{})
+}
+
+// Seeked emits the Seeked signal after the position has jumped. It is not
+// emitted by SetPosition and Seek, the caller emits it for every jump
+// whatever has caused it.
+func (s *Server) Seeked(position time.Duration) {
+	s.bus.Emit(objectPath, ifacePlayer+".Seeked", position.Microseconds())
+}
+
+// trackID derives a stable object path from the song path.
+func trackID(path string) dbus.ObjectPath {
+	if path == "" {
+		return noTrack
+	}
+	sum := sha1.Sum([]byte(path))
+	return dbus.ObjectPath("/org/gomu/track/" + hex.EncodeToString(sum[:]))
+}
+
+// songURL is the uri of the song, the path of a stream is its url already
+func songURL(path string) string {
+	if u, err := url.Parse(path); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func metadata(m Metadata) map[string]dbus.Variant {
+
+	md := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(trackID(m.Path)),
+	}
+
+	if m.Path == "" {
+		return md
+	}
+
+	title := m.Title
+	if title == "" {
+		title = m.Path
+	}
+
+	md["mpris:length"] = dbus.MakeVariant(m.Length.Microseconds())
+	md["xesam:url"] = dbus.MakeVariant(songURL(m.Path))
+	md["xesam:title"] = dbus.MakeVariant(title)
+
+	if m.Artist != "" {
+		md["xesam:artist"] = dbus.MakeVariant([]string{m.Artist})
+	}
+	if m.Album != "" {
+		md["xesam:album"] = dbus.MakeVariant(m.Album)
+	}
+	if m.TrackNumber > 0 {
+		md["xesam:trackNumber"] = dbus.MakeVariant(int32(m.TrackNumber))
+	}
+
+	return md
+}
+
+func rootProperties() map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"CanQuit":             dbus.MakeVariant(false),
+		"CanRaise":            dbus.MakeVariant(false),
+		"HasTrackList":        dbus.MakeVariant(false),
+		"Identity":            dbus.MakeVariant("gomu"),
+		"SupportedUriSchemes": dbus.MakeVariant([]string{"file", "http", "https"}),
+		"SupportedMimeTypes": dbus.MakeVariant([]string{
+			"audio/mpeg", "audio/flac", "audio/ogg", "audio/wav",
+		}),
+	}
+}
+
+func (s *Server) playerProperties() map[string]dbus.Variant {
+
+	status := s.player.Status()
+
+	loop := "None"
+	if status.Loop {
+		loop = "Playlist"
+	}
+
+	rate := status.Rate
+	if rate == 0 {
+		rate = 1
+	}
+
+	return map[string]dbus.Variant{
+		"PlaybackStatus": dbus.MakeVariant(status.Playback),
+		"LoopStatus":     dbus.MakeVariant(loop),
+		"Rate":           dbus.MakeVariant(rate),
+		"Shuffle":        dbus.MakeVariant(false),
+		"Metadata":       dbus.MakeVariant(metadata(status.Metadata)),
+		"Volume":         dbus.MakeVariant(status.Volume),
+		"Position":       dbus.MakeVariant(status.Position.Microseconds()),
+		"MinimumRate":    dbus.MakeVariant(MinimumRate),
+		"MaximumRate":    dbus.MakeVariant(MaximumRate),
+		"CanGoNext":      dbus.MakeVariant(true),
+		"CanGoPrevious":  dbus.MakeVariant(true),
+		"CanPlay":        dbus.MakeVariant(true),
+		"CanPause":       dbus.MakeVariant(true),
+		"CanSeek":        dbus.MakeVariant(canSeek(status.Metadata)),
+		"CanControl":     dbus.MakeVariant(true),
+	}
+}
+
+func (s *Server) properties(iface string) (map[string]dbus.Variant, bool) {
+	switch iface {
+	case ifaceRoot:
+		return rootProperties(), true
+	case ifacePlayer:
+		return s.playerProperties(), true
+	}
+	return nil, false
+}
+
+// failed turns the error of the player into the error replied.
+func failed(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*dbus.Error); ok {
+		return e
+	}
+	return dbus.MakeFailedError(err)
+}
+
+func invalidArgs(text string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []interface{}{text})
+}
+
+func (s *Server) rootMethods() map[string]interface{} {
+	noop := func() *dbus.Error { return nil }
+	return map[string]interface{}{
+		"Raise": noop,
+		"Quit":  noop,
+	}
+}
+
+func (s *Server) playerMethods() map[string]interface{} {
+	return map[string]interface{}{
+		"Next":        func() *dbus.Error { return failed(s.player.Next()) },
+		"Previous":    func() *dbus.Error { return failed(s.player.Previous()) },
+		"Play":        func() *dbus.Error { return failed(s.player.Play()) },
+		"Pause":       func() *dbus.Error { return failed(s.player.Pause()) },
+		"PlayPause":   s.playPause,
+		"Stop":        func() *dbus.Error { return failed(s.player.Stop()) },
+		"Seek":        s.seek,
+		"SetPosition": s.setPosition,
+		"OpenUri":     s.openURI,
+	}
+}
+
+func (s *Server) propertiesMethods() map[string]interface{} {
+	return map[string]interface{}{
+		"Get":    s.get,
+		"GetAll": s.getAll,
+		"Set":    s.set,
+	}
+}
+
+func (s *Server) playPause() *dbus.Error {
+	if s.player.Status().Playback == Playing {
+		return failed(s.player.Pause())
+	}
+	return failed(s.player.Play())
+}
+
+func (s *Server) get(iface, name string) (dbus.Variant, *dbus.Error) {
+
+	props, ok := s.properties(iface)
+	if !ok {
+		return dbus.Variant{}, dbus.NewError(
+			"org.freedesktop.DBus.Error.UnknownInterface", []interface{}{iface})
+	}
+
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError(
+			"org.freedesktop.DBus.Error.UnknownProperty", []interface{}{name})
+	}
+
+	return v, nil
+}
+
+func (s *Server) getAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+
+	props, ok := s.properties(iface)
+	if !ok {
+		props = map[string]dbus.Variant{}
+	}
+
+	return props, nil
+}
+
+// set only supports the volume, other properties are read only.
+func (s *Server) set(iface, name string, value dbus.Variant) *dbus.Error {
+
+	if iface != ifacePlayer || name != "Volume" {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly",
+			[]interface{}{iface + "." + name + " is read only"})
+	}
+
+	volume, ok := value.Value().(float64)
+	if !ok {
+		return invalidArgs("volume must be a double")
+	}
+
+	if volume < 0 {
+		volume = 0
+	}
+	if volume > 1 {
+		volume = 1
+	}
+
+	if err := s.player.SetVolume(volume); err != nil {
+		return failed(err)
+	}
+
+	s.Changed()
+
+	return nil
+}
+
+// canSeek is false for streams, they have no length.
+func canSeek(m Metadata) bool {
+	return m.Path != "" && m.Length > 0
+}
+
+// seek moves the position by the offset in microseconds, seeking past the
+// end skips to the next song.
+func (s *Server) seek(offset int64) *dbus.Error {
+
+	status := s.player.Status()
+	if !canSeek(status.Metadata) {
+		return nil
+	}
+
+	position := status.Position + time.Duration(offset)*time.Microsecond
+
+	if position > status.Metadata.Length {
+		return failed(s.player.Next())
+	}
+
+	if position < 0 {
+		position = 0
+	}
+
+	return failed(s.player.SetPosition(position))
+}
+
+// setPosition is ignored if the track is no longer the current one.
+func (s *Server) setPosition(track dbus.ObjectPath, microseconds int64) *dbus.Error {
+
+	status := s.player.Status()
+	position := time.Duration(microseconds) * time.Microsecond
+
+	if !canSeek(status.Metadata) || track != trackID(status.Metadata.Path) || position < 0 ||
+		position > status.Metadata.Length {
+		return nil
+	}
+
+	return failed(s.player.SetPosition(position))
+}
+
+func (s *Server) openURI(uri string) *dbus.Error {
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return invalidArgs("only file and http uris are supported")
+	}
+
+	switch u.Scheme {
+	case "file":
+		return failed(s.player.OpenURI(u.Path))
+	case "http", "https":
+		return failed(s.player.OpenURI(u.String()))
+	}
+
+	return invalidArgs("only file and http uris are supported")
+}
+
+const introspection = `<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="data" type="s" direction="out"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Peer">
+    <method name="Ping"/>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <method name="Set">
+      <arg name="interface" type="s" direction="in"/>
+      <arg name="property" type="s" direction="in"/>
+      <arg name="value" type="v" direction="in"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface" type="s"/>
+      <arg name="changed" type="a{sv}"/>
+      <arg name="invalidated" type="as"/>
+    </signal>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2">
+    <method name="Raise"/>
+    <method name="Quit"/>
+    <property name="CanQuit" type="b" access="read"/>
+    <property name="CanRaise" type="b" access="read"/>
+    <property name="HasTrackList" type="b" access="read"/>
+    <property name="Identity" type="s" access="read"/>
+    <property name="SupportedUriSchemes" type="as" access="read"/>
+    <property name="SupportedMimeTypes" type="as" access="read"/>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2.Player">
+    <method name="Next"/>
+    <method name="Previous"/>
+    <method name="Pause"/>
+    <method name="PlayPause"/>
+    <method name="Stop"/>
+    <method name="Play"/>
+    <method name="Seek">
+      <arg name="Offset" type="x" direction="in"/>
+    </method>
+    <method name="SetPosition">
+      <arg name="TrackId" type="o" direction="in"/>
+      <arg name="Position" type="x" direction="in"/>
+    </method>
+    <method name="OpenUri">
+      <arg name="Uri" type="s" direction="in"/>
+    </method>
+    <signal name="Seeked">
+      <arg name="Position" type="x"/>
+    </signal>
+    <property name="PlaybackStatus" type="s" access="read"/>
+    <property name="LoopStatus" type="s" access="read"/>
+    <property name="Rate" type="d" access="read"/>
+    <property name="Shuffle" type="b" access="read"/>
+    <property name="Metadata" type="a{sv}" access="read"/>
+    <property name="Volume" type="d" access="readwrite"/>
+    <property name="Position" type="x" access="read"/>
+    <property name="MinimumRate" type="d" access="read"/>
+    <property name="MaximumRate" type="d" access="read"/>
+    <property name="CanGoNext" type="b" access="read"/>
+    <property name="CanGoPrevious" type="b" access="read"/>
+    <property name="CanPlay" type="b" access="read"/>
+    <property name="CanPause" type="b" access="read"/>
+    <property name="CanSeek" type="b" access="read"/>
+    <property name="CanControl" type="b" access="read"/>
+  </interface>
+</node>`
@@ -0,0 +1,291 @@
+package mpris
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`
+
+// startBus runs a private dbus-daemon and returns its address.
+func startBus(t *testing.T) string {
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	socket := filepath.Join(dir, "bus")
+
+	err = os.WriteFile(config, []byte(strings.Replace(busConfig, "%s", socket, 1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(address)
+}
+
+type fakePlayer struct {
+	mu     sync.Mutex
+	status Status
+	calls  []string
+}
+
+func (f *fakePlayer) record(call string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakePlayer) Play() error {
+	f.mu.Lock()
+	f.status.Playback = Playing
+	f.mu.Unlock()
+	return f.record("play")
+}
+
+func (f *fakePlayer) Pause() error {
+	f.mu.Lock()
+	f.status.Playback = Paused
+	f.mu.Unlock()
+	return f.record("pause")
+}
+
+func (f *fakePlayer) Next() error              { return f.record("next") }
+func (f *fakePlayer) Previous() error          { return f.record("previous") }
+func (f *fakePlayer) Stop() error              { return f.record("stop") }
+func (f *fakePlayer) OpenURI(uri string) error { return f.record("open " + uri) }
+
+func (f *fakePlayer) SetPosition(position time.Duration) error {
+	return f.record("position " + position.String())
+}
+
+func (f *fakePlayer) SetVolume(volume float64) error {
+	f.mu.Lock()
+	f.status.Volume = volume
+	f.mu.Unlock()
+	return f.record("volume")
+}
+
+func (f *fakePlayer) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *fakePlayer) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func TestServer(t *testing.T) {
+
+	address := startBus(t)
+
+	player := &fakePlayer{status: Status{
+		Playback: Paused,
+		Volume:   0.8,
+		Metadata: Metadata{
+			Path:   "/music/song.mp3",
+			Title:  "song",
+			Artist: "artist",
+			Length: time.Minute,
+		},
+	}}
+
+	server, err := NewWithAddress(address, player)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, iface := range []string{ifaceProperties, ifacePlayer} {
+		if err := client.AddMatchSignal(dbus.WithMatchInterface(iface)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	client.Signal(signals)
+
+	signal := func(name string) *dbus.Signal {
+		t.Helper()
+		for {
+			select {
+			case s := <-signals:
+				if s.Name == name {
+					return s
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s is not emitted", name)
+			}
+		}
+	}
+
+	obj := client.Object(busName, objectPath)
+
+	call := func(method string, args ...interface{}) *dbus.Call {
+		t.Helper()
+		c := obj.Call(method, 0, args...)
+		if c.Err != nil {
+			t.Fatalf("%s: %v", method, c.Err)
+		}
+		return c
+	}
+
+	var v dbus.Variant
+	if err := call(ifaceProperties+".Get", ifacePlayer, "Metadata").Store(&v); err != nil {
+		t.Fatal(err)
+	}
+	md := v.Value().(map[string]dbus.Variant)
+
+	if title := md["xesam:title"].Value(); title != "song" {
+		t.Errorf("expected title song got %v", title)
+	}
+
+	if length := md["mpris:length"].Value(); length != time.Minute.Microseconds() {
+		t.Errorf("expected length of a minute got %v", length)
+	}
+
+	if u := md["xesam:url"].Value(); u != "file:///music/song.mp3" {
+		t.Errorf("expected url file:///music/song.mp3 got %v", u)
+	}
+
+	call(ifacePlayer + ".PlayPause")
+	call(ifacePlayer + ".Previous")
+	call(ifacePlayer+".Seek", (10 * time.Second).Microseconds())
+	call(ifacePlayer+".SetPosition", trackID("/music/song.mp3"), (5 * time.Second).Microseconds())
+	// stale track ids are ignored
+	call(ifacePlayer+".SetPosition", trackID("/music/other.mp3"), int64(0))
+	call(ifaceProperties+".Set", ifacePlayer, "Volume", dbus.MakeVariant(0.5))
+	call(ifacePlayer+".OpenUri", "file:///music/new%20song.mp3")
+	call(ifacePlayer+".OpenUri", "http://radio.example/stream")
+
+	expected := []string{
+		"play", "previous", "position 10s", "position 5s", "volume",
+		"open /music/new song.mp3", "open http://radio.example/stream",
+	}
+	if got := player.Calls(); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected calls %v got %v", expected, got)
+	}
+
+	var props map[string]dbus.Variant
+	if err := call(ifaceProperties+".GetAll", ifacePlayer).Store(&props); err != nil {
+		t.Fatal(err)
+	}
+	if volume := props["Volume"].Value(); volume != 0.5 {
+		t.Errorf("expected volume 0.5 got %v", volume)
+	}
+
+	// the volume change has already been announced
+	changed := signal(ifaceProperties + ".PropertiesChanged").Body[1].(map[string]dbus.Variant)
+	if _, ok := changed["Volume"]; !ok {
+		t.Errorf("expected volume change got %v", changed)
+	}
+
+	player.mu.Lock()
+	player.status.Playback = Stopped
+	player.mu.Unlock()
+
+	server.Changed()
+
+	changed = signal(ifaceProperties + ".PropertiesChanged").Body[1].(map[string]dbus.Variant)
+	if len(changed) != 1 || changed["PlaybackStatus"].Value() != Stopped {
+		t.Errorf("expected only PlaybackStatus to change got %v", changed)
+	}
+
+	server.Seeked(5 * time.Second)
+
+	if position := signal(ifacePlayer + ".Seeked").Body[0]; position != (5 * time.Second).Microseconds() {
+		t.Errorf("expected Seeked at 5s got %v", position)
+	}
+
+	// streams have no length, seeking would skip the station
+	player.mu.Lock()
+	player.status.Playback = Playing
+	player.status.Metadata = Metadata{Path: "http://radio.example/stream"}
+	player.mu.Unlock()
+
+	calls := len(player.Calls())
+	call(ifacePlayer+".Seek", (10 * time.Second).Microseconds())
+	call(ifacePlayer+".SetPosition", trackID("http://radio.example/stream"), int64(0))
+
+	if got := player.Calls(); len(got) != calls {
+		t.Errorf("expected a stream not to be seeked got %v", got[calls:])
+	}
+
+	var canSeek dbus.Variant
+	if err := call(ifaceProperties+".Get", ifacePlayer, "CanSeek").Store(&canSeek); err != nil {
+		t.Fatal(err)
+	}
+	if canSeek.Value() != false {
+		t.Error("expected a stream not to be seekable")
+	}
+
+	if err := obj.Call(ifacePlayer+".Rewind", 0).Err; err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func TestSongURL(t *testing.T) {
+
+	samples := map[string]string{
+		"/music/a b.mp3":               "file:///music/a%20b.mp3",
+		"http://radio.example/128.mp3": "http://radio.example/128.mp3",
+		"https://radio.example/live":   "https://radio.example/live",
+	}
+
+	for path, expected := range samples {
+		if got := songURL(path); got != expected {
+			t.Errorf("expected %s got %s", expected, got)
+		}
+	}
+}
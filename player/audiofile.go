@@ -3,13 +3,11 @@
 package player
 
 import (
-	"errors"
 	"fmt"
 	"sort"
 	"time"
 
 	"github.com/rivo/tview"
-	"github.com/tramhao/id3v2"
 	"github.com/ztrue/tracerr"
 )
 
@@ -113,29 +111,20 @@ func (a *AudioFile) String() string {
 }
 
 // LoadTagMap will load from tag and return a map of langExt to lyrics
-func (a *AudioFile) LoadTagMap() (tag *id3v2.Tag, popupLyricMap map[string]string, options []string, err error) {
+func (a *AudioFile) LoadTagMap() (tag Tag, popupLyricMap map[string]string, options []string, err error) {
 
-	popupLyricMap = make(map[string]string)
-
-	if a.isAudioFile {
-		tag, err = id3v2.Open(a.path, id3v2.Options{Parse: true})
-		if err != nil {
-			return nil, nil, nil, tracerr.Wrap(err)
-		}
-		defer tag.Close()
-	} else {
+	if !a.isAudioFile {
 		return nil, nil, nil, fmt.Errorf("not an audio file")
 	}
-	usltFrames := tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
-
-	for _, f := range usltFrames {
-		uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame)
-		if !ok {
-			return nil, nil, nil, errors.New("USLT error")
-		}
-		res := uslf.Lyrics
-		popupLyricMap[uslf.ContentDescriptor] = res
+
+	tag, err = OpenTag(a.path)
+	if err != nil {
+		return nil, nil, nil, tracerr.Wrap(err)
 	}
+	defer tag.Close()
+
+	popupLyricMap = tag.Lyrics()
+
 	for option := range popupLyricMap {
 		options = append(options, option)
 	}
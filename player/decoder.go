@@ -0,0 +1,165 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/flac"
+	"github.com/faiface/beep/mp3"
+	"github.com/faiface/beep/vorbis"
+	"github.com/faiface/beep/wav"
+	"github.com/ztrue/tracerr"
+)
+
+// AudioFormat is the container format of an audio file.
+type AudioFormat int
+
+const (
+	// FormatUnknown is returned for files gomu is unable to decode.
+	FormatUnknown AudioFormat = iota
+	FormatMP3
+	FormatFLAC
+	FormatOgg
+	FormatWAV
+	// FormatMP4 is only recognized for tagging, there is no decoder for it.
+	FormatMP4
+)
+
+// ErrUnsupportedFormat is returned when decoding a file which format is not
+// supported.
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// SupportedExtensions lists file extensions of the formats gomu can play.
+var SupportedExtensions = []string{".mp3", ".flac", ".ogg", ".oga", ".wav"}
+
+// String returns the name of the format.
+func (f AudioFormat) String() string {
+	switch f {
+	case FormatMP3:
+		return "mp3"
+	case FormatFLAC:
+		return "flac"
+	case FormatOgg:
+		return "ogg"
+	case FormatWAV:
+		return "wav"
+	case FormatMP4:
+		return "mp4"
+	}
+	return "unknown"
+}
+
+// Playable returns true if there is a decoder for the format. Files which
+// are not playable are left out of the library and the playlist, MP4 files
+// can only be tagged by opening them with OpenTag.
+func (f AudioFormat) Playable() bool {
+	switch f {
+	case FormatMP3, FormatFLAC, FormatOgg, FormatWAV:
+		return true
+	}
+	return false
+}
+
+// DetectFormat sniffs the header of r to find out the audio format. The file
+// extension is not trusted as downloaded files are often misnamed.
+func DetectFormat(r io.Reader) (AudioFormat, error) {
+
+	header := make([]byte, 12)
+
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return FormatUnknown, tracerr.Wrap(err)
+	}
+
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC, nil
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return FormatOgg, nil
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) &&
+		bytes.Equal(header[8:12], []byte("WAVE")):
+		return FormatWAV, nil
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return FormatMP4, nil
+	case bytes.HasPrefix(header, []byte("ID3")):
+		// flac files may also be prefixed with id3 tag, but that is rare
+		// enough to be treated as mp3
+		return FormatMP3, nil
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		// mpeg frame sync
+		return FormatMP3, nil
+	}
+
+	return FormatUnknown, nil
+}
+
+// DetectFileFormat opens the file and detects its audio format.
+func DetectFileFormat(audioPath string) (AudioFormat, error) {
+
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return FormatUnknown, tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	return DetectFormat(f)
+}
+
+// HasSupportedExt returns true if the file name ends with one of the
+// supported audio extensions.
+func HasSupportedExt(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, v := range SupportedExtensions {
+		if ext == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode picks a decoder based on the content of f. The returned
+// StreamSeekCloser owns f, closing it will also close the file.
+func Decode(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+
+	format, err := DetectFormat(f)
+	if err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	var (
+		stream     beep.StreamSeekCloser
+		fmtDecoded beep.Format
+	)
+
+	switch format {
+	case FormatMP3:
+		stream, fmtDecoded, err = mp3.Decode(f)
+	case FormatFLAC:
+		stream, fmtDecoded, err = flac.Decode(f)
+	case FormatOgg:
+		stream, fmtDecoded, err = vorbis.Decode(f)
+	case FormatWAV:
+		stream, fmtDecoded, err = wav.Decode(f)
+	default:
+		return nil, beep.Format{}, tracerr.Wrap(ErrUnsupportedFormat)
+	}
+
+	if err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	return stream, fmtDecoded, nil
+}
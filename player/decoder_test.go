@@ -0,0 +1,52 @@
+package player
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+
+	samples := map[string]AudioFormat{
+		"fLaC\x00\x00\x00\x22":         FormatFLAC,
+		"OggS\x00\x02\x00\x00":         FormatOgg,
+		"RIFF\x24\x08\x00\x00WAVEfmt ": FormatWAV,
+		"ID3\x04\x00\x00\x00\x00\x00":  FormatMP3,
+		"\xff\xfb\x90\x64\x00":         FormatMP3,
+		"RIFF\x24\x08\x00\x00AVI LIST": FormatUnknown,
+		"\x00\x00\x00\x20ftypM4A ":     FormatMP4,
+		"hello world":                  FormatUnknown,
+		"":                             FormatUnknown,
+	}
+
+	for k, v := range samples {
+
+		got, err := DetectFormat(bytes.NewReader([]byte(k)))
+		if err != nil && k != "" {
+			t.Errorf("DetectFormat(%q); unexpected error %v", k, err)
+		}
+
+		if got != v {
+			t.Errorf("DetectFormat(%q); expected %s got %s", k, v, got)
+		}
+	}
+}
+
+func TestHasSupportedExt(t *testing.T) {
+
+	samples := map[string]bool{
+		"song.mp3":       true,
+		"song.FLAC":      true,
+		"/music/a/b.ogg": true,
+		"track.wav":      true,
+		"cover.jpg":      false,
+		"README":         false,
+		"v1.2 sessions":  false,
+	}
+
+	for k, v := range samples {
+		if got := HasSupportedExt(k); got != v {
+			t.Errorf("HasSupportedExt(%s); expected %v got %v", k, v, got)
+		}
+	}
+}
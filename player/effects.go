@@ -0,0 +1,218 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/faiface/beep"
+)
+
+// Effect processes the samples before they reach the output, the samples are
+// at the output sample rate.
+type Effect interface {
+	// Process modifies the samples in place.
+	Process(samples [][2]float64)
+}
+
+// effectChain applies the effects of the player in order. It is the stream
+// passed to the output, which holds the lock of the player while streaming.
+type effectChain struct {
+	p        *Player
+	streamer beep.Streamer
+}
+
+func (c *effectChain) Stream(samples [][2]float64) (n int, ok bool) {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	n, ok = c.streamer.Stream(samples)
+	for _, e := range c.p.effects {
+		e.Process(samples[:n])
+	}
+	return n, ok
+}
+
+func (c *effectChain) Err() error {
+	return c.streamer.Err()
+}
+
+// SetEffects replaces the effects applied after the volume, in order. It
+// takes effect immediately when a song is playing.
+func (p *Player) SetEffects(effects ...Effect) {
+	rate := p.getStatus().rate
+	for _, e := range effects {
+		if r, ok := e.(sampleRateSetter); ok {
+			r.SetSampleRate(rate)
+		}
+	}
+	p.mu.Lock()
+	p.effects = effects
+	p.mu.Unlock()
+}
+
+// sampleRateSetter is implemented by the effects which depend on the sample
+// rate, the player sets it to the rate of the output.
+type sampleRateSetter interface {
+	SetSampleRate(rate beep.SampleRate)
+}
+
+// EqualizerBands are the center frequencies in Hz of the equalizer bands.
+var EqualizerBands = []float64{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// MaxEqualizerGain is the largest boost or cut of a band in dB.
+const MaxEqualizerGain = 12.0
+
+// equalizerQ is the quality factor of the bands, about an octave wide.
+const equalizerQ = 1.41
+
+// EqualizerPresets are the gains in dB of the bands of common presets.
+var EqualizerPresets = map[string][]float64{
+	"flat":       {0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	"bass":       {6, 5, 4, 2, 0, 0, 0, 0, 0, 0},
+	"treble":     {0, 0, 0, 0, 0, 0, 2, 4, 5, 6},
+	"rock":       {5, 4, 2, -1, -2, -1, 2, 3, 4, 4},
+	"pop":        {-1, 1, 3, 4, 3, 0, -1, -1, 1, 2},
+	"jazz":       {3, 2, 1, 2, -1, -1, 0, 1, 2, 3},
+	"classical":  {4, 3, 2, 1, 0, 0, 0, 1, 2, 3},
+	"electronic": {5, 4, 1, 0, -2, 1, 0, 1, 4, 5},
+	"vocal":      {-2, -1, 0, 2, 4, 4, 3, 1, 0, -1},
+	"loudness":   {5, 3, 0, 0, -1, 0, -1, 0, 3, 4},
+}
+
+// EqualizerPresetNames returns the names of the presets sorted.
+func EqualizerPresetNames() []string {
+	var names []string
+	for name := range EqualizerPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setPeaking sets the coefficients of a peaking filter from the audio EQ
+// cookbook of Robert Bristow-Johnson.
+func (f *biquad) setPeaking(freq, gain, q float64, rate beep.SampleRate) {
+
+	a := math.Pow(10, gain/40)
+	w0 := 2 * math.Pi * freq / float64(rate)
+	alpha := math.Sin(w0) / (2 * q)
+	cos := math.Cos(w0)
+
+	a0 := 1 + alpha/a
+	f.b0 = (1 + alpha*a) / a0
+	f.b1 = -2 * cos / a0
+	f.b2 = (1 - alpha*a) / a0
+	f.a1 = -2 * cos / a0
+	f.a2 = (1 - alpha/a) / a0
+}
+
+// Equalizer is a graphic equalizer of peaking filters at EqualizerBands.
+type Equalizer struct {
+	mu      sync.Mutex
+	rate    beep.SampleRate
+	gains   []float64
+	filters []biquad
+}
+
+// NewEqualizer returns an equalizer with the gains in dB of the bands.
+func NewEqualizer(gains []float64) *Equalizer {
+	e := &Equalizer{
+		rate:    defaultSampleRate,
+		gains:   make([]float64, len(EqualizerBands)),
+		filters: make([]biquad, len(EqualizerBands)),
+	}
+	e.SetGains(gains)
+	return e
+}
+
+// Gains returns the gains in dB of the bands.
+func (e *Equalizer) Gains() []float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]float64(nil), e.gains...)
+}
+
+// SetGains sets the gains in dB of the bands, missing bands are flat. The
+// state of the filters is kept so there is no click while playing.
+func (e *Equalizer) SetGains(gains []float64) {
+	for band := range EqualizerBands {
+		gain := 0.0
+		if band < len(gains) {
+			gain = gains[band]
+		}
+		e.SetGain(band, gain)
+	}
+}
+
+// SetGain sets the gain in dB of the band, limited to MaxEqualizerGain.
+func (e *Equalizer) SetGain(band int, gain float64) {
+
+	if band < 0 || band >= len(EqualizerBands) {
+		return
+	}
+
+	gain = math.Max(-MaxEqualizerGain, math.Min(MaxEqualizerGain, gain))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.gains[band] = gain
+	e.filters[band].setPeaking(EqualizerBands[band], gain, equalizerQ, e.rate)
+}
+
+// SetSampleRate sets the sample rate of the samples to be processed.
+func (e *Equalizer) SetSampleRate(rate beep.SampleRate) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.rate = rate
+	for band, gain := range e.gains {
+		e.filters[band].setPeaking(EqualizerBands[band], gain, equalizerQ, rate)
+	}
+}
+
+// Process implements Effect.
+func (e *Equalizer) Process(samples [][2]float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.filters {
+		// a flat band leaves the samples as they are
+		if e.gains[i] == 0 {
+			continue
+		}
+		for j := range samples {
+			samples[j][0] = e.filters[i].process(0, samples[j][0])
+			samples[j][1] = e.filters[i].process(1, samples[j][1])
+		}
+	}
+}
+
+// Balance attenuates one of the channels, from -1 for the left channel only
+// to 1 for the right channel only.
+type Balance float64
+
+// Process implements Effect.
+func (b Balance) Process(samples [][2]float64) {
+
+	left := math.Min(1, 1-float64(b))
+	right := math.Min(1, 1+float64(b))
+
+	for i := range samples {
+		samples[i][0] *= left
+		samples[i][1] *= right
+	}
+}
+
+// Mono downmixes both channels into each of them.
+type Mono struct{}
+
+// Process implements Effect.
+func (Mono) Process(samples [][2]float64) {
+	for i := range samples {
+		mid := (samples[i][0] + samples[i][1]) / 2
+		samples[i][0], samples[i][1] = mid, mid
+	}
+}
@@ -0,0 +1,91 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+// sine returns n samples of a sine wave of the frequency at the sample rate.
+func sine(freq float64, n int) [][2]float64 {
+	samples := make([][2]float64, n)
+	for i := range samples {
+		v := math.Sin(2 * math.Pi * freq * float64(i) / float64(defaultSampleRate))
+		samples[i] = [2]float64{v, v}
+	}
+	return samples
+}
+
+// peak returns the largest amplitude of the left channel.
+func peak(samples [][2]float64) float64 {
+	max := 0.0
+	for _, s := range samples {
+		max = math.Max(max, math.Abs(s[0]))
+	}
+	return max
+}
+
+func TestEqualizer(t *testing.T) {
+
+	flat := sine(1000, 4800)
+	NewEqualizer(EqualizerPresets["flat"]).Process(flat)
+
+	if got, expected := flat[100], sine(1000, 4800)[100]; got != expected {
+		t.Errorf("Expected flat equalizer to leave samples; got %v expected %v", got, expected)
+	}
+
+	gains := make([]float64, len(EqualizerBands))
+	gains[5] = 6
+
+	eq := NewEqualizer(gains)
+	boosted := sine(1000, 48000)
+	eq.Process(boosted)
+
+	// skip the transient of the filter
+	got := 20 * math.Log10(peak(boosted[24000:]))
+	if math.Abs(got-6) > 0.5 {
+		t.Errorf("Expected 1 kHz to be boosted by 6 dB; got %.2f dB", got)
+	}
+
+	// far from the band is not changed
+	low := sine(31, 48000)
+	eq.Process(low)
+
+	got = 20 * math.Log10(peak(low[24000:]))
+	if math.Abs(got) > 0.5 {
+		t.Errorf("Expected 31 Hz to be left; got %.2f dB", got)
+	}
+
+	eq.SetGain(0, 100)
+	if gain := eq.Gains()[0]; gain != MaxEqualizerGain {
+		t.Errorf("Expected gain to be limited to %v; got %v", MaxEqualizerGain, gain)
+	}
+}
+
+func TestBalance(t *testing.T) {
+
+	samples := [][2]float64{{1, 1}}
+
+	Balance(0.5).Process(samples)
+
+	if samples[0] != [2]float64{0.5, 1} {
+		t.Errorf("Expected left channel to be halved; got %v", samples[0])
+	}
+
+	samples = [][2]float64{{1, 1}}
+	Balance(-1).Process(samples)
+
+	if samples[0] != [2]float64{1, 0} {
+		t.Errorf("Expected left channel only; got %v", samples[0])
+	}
+}
+
+func TestMono(t *testing.T) {
+
+	samples := [][2]float64{{1, 0}, {0.5, -0.5}}
+
+	Mono{}.Process(samples)
+
+	if samples[0] != [2]float64{0.5, 0.5} || samples[1] != [2]float64{0, 0} {
+		t.Errorf("Expected channels to be mixed; got %v", samples)
+	}
+}
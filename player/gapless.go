@@ -0,0 +1,347 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"os"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/effects"
+	"github.com/ztrue/tracerr"
+)
+
+// preloadAhead is how long before the end of the current song the next song
+// gets decoded.
+const preloadAhead = 5 * time.Second
+
+// track is a decoded song resampled to the output sample rate.
+type track struct {
+	audio  Audio
+	stream beep.StreamSeekCloser
+	format beep.Format
+	// the output sample rate
+	rate beep.SampleRate
+	// linear replaygain factor
+	scale     float64
+	resampled beep.Streamer
+	// live is a stream which has no end
+	live bool
+}
+
+func (p *Player) openTrack(audio Audio) (*track, error) {
+
+	if IsStream(audio.Path()) {
+		return p.openStreamTrack(audio, func(title string) {
+			p.notify(func() { p.streamTitleChanged(audio, title) })
+		})
+	}
+
+	f, err := os.Open(audio.Path())
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	stream, format, err := Decode(f)
+	if err != nil {
+		f.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	t := &track{
+		audio:  audio,
+		stream: stream,
+		format: format,
+		rate:   p.getStatus().rate,
+		scale:  p.replayGainScale(audio.Path()),
+	}
+	t.resample()
+
+	return t, nil
+}
+
+// resample builds the chain from the decoded stream to the output sample
+// rate with replaygain applied.
+func (t *track) resample() {
+
+	// resample to adapt to sample rate of new songs
+	resampled := beep.Resample(4, t.format.SampleRate, t.rate, t.stream)
+
+	if t.scale == 1 {
+		t.resampled = resampled
+		return
+	}
+
+	// effects.Gain multiplies the samples by 1 + Gain
+	t.resampled = &effects.Gain{Streamer: resampled, Gain: t.scale - 1}
+}
+
+// remaining returns the duration left to be played.
+func (t *track) remaining() time.Duration {
+	if t.live {
+		return liveRemaining
+	}
+	return t.format.SampleRate.D(t.stream.Len() - t.stream.Position())
+}
+
+// rewind seeks to the beginning and discards the buffered resampled samples.
+func (t *track) rewind() error {
+	if err := t.stream.Seek(0); err != nil {
+		return err
+	}
+	t.resample()
+	return nil
+}
+
+// fadeChunk is the number of samples streamed with the same gain while
+// crossfading.
+const fadeChunk = 512
+
+// fade mixes the outgoing track with the incoming one.
+type fade struct {
+	outgoing *track
+	mixer    *beep.Mixer
+	out      *effects.Gain
+	in       *effects.Gain
+	pos      int
+	len      int
+	// the incoming track has become dominant and replaced the outgoing one
+	handedOver bool
+}
+
+// gaplessStreamer plays the current track and splices the preloaded next
+// track in at the exact sample the current one ends, or crossfades them when
+// crossfade is set. All fields are guarded by the lock of the player as
+// Stream is called by the output.
+type gaplessStreamer struct {
+	p       *Player
+	current *track
+	next    *track
+	fade    *fade
+	// preload is only requested once per track
+	preloadRequested bool
+	done             bool
+}
+
+func (g *gaplessStreamer) Stream(samples [][2]float64) (n int, ok bool) {
+
+	if g.done {
+		return 0, false
+	}
+
+	for n < len(samples) {
+
+		crossfade := g.p.crossfade
+
+		if !g.preloadRequested && g.current.remaining() < preloadAhead+crossfade {
+			// right after a splice the head of the queue is the current song
+			// until its finish callback has dequeued it
+			if next := g.p.peekNextSong(); next != nil && next != g.current.audio {
+				g.preloadRequested = true
+				go g.p.preload(g, g.current, next)
+			}
+		}
+
+		if g.fade == nil && crossfade > 0 && g.next != nil &&
+			g.current.remaining() <= crossfade && g.p.peekNextSong() == g.next.audio {
+			g.startFade()
+		}
+
+		if g.fade != nil {
+			n += g.streamFade(samples[n:])
+			continue
+		}
+
+		chunk := samples[n:]
+
+		// stop right where the crossfade should start
+		if crossfade > 0 && g.next != nil {
+			until := g.current.rate.N(g.current.remaining() - crossfade)
+			if until > 0 && until < len(chunk) {
+				chunk = chunk[:until]
+			}
+		}
+
+		sn, sok := g.current.resampled.Stream(chunk)
+		n += sn
+
+		if !sok {
+			if !g.advance() {
+				g.done = true
+				return n, n > 0
+			}
+			continue
+		}
+
+		if sn == 0 {
+			break
+		}
+	}
+
+	return n, true
+}
+
+func (g *gaplessStreamer) Err() error {
+	return g.current.resampled.Err()
+}
+
+// advance switches to the preloaded track if it is still the next song to be
+// played. It returns false if there is nothing to continue with.
+func (g *gaplessStreamer) advance() bool {
+
+	p := g.p
+	prev := g.current
+	prev.stream.Close()
+
+	next := g.next
+	g.next = nil
+
+	// the queue may have changed since the song was preloaded
+	if next == nil || p.peekNextSong() != next.audio {
+		if next != nil {
+			next.stream.Close()
+		}
+		p.lastPosition = p.length
+		p.format = nil
+		p.streamSeekCloser = nil
+		p.notify(func() { p.ended(g, prev.audio) })
+		return false
+	}
+
+	g.current = next
+	g.preloadRequested = false
+	g.handOver(prev, next)
+
+	return true
+}
+
+// handOver makes next the song reported by the player. The finish callback of
+// prev leads to Run being called with next.
+func (g *gaplessStreamer) handOver(prev, next *track) {
+
+	p := g.p
+
+	p.streamSeekCloser = next.stream
+	p.format = &next.format
+	p.length = next.format.SampleRate.D(next.stream.Len())
+	p.spliced = next.audio
+
+	p.notify(func() { p.handedOver(g, prev.audio, next.audio) })
+}
+
+// startFade starts mixing the preloaded track in while the current one fades
+// out during the remaining time.
+func (g *gaplessStreamer) startFade() {
+
+	f := &fade{
+		outgoing: g.current,
+		mixer:    &beep.Mixer{},
+		out:      &effects.Gain{Streamer: g.current.resampled},
+		in:       &effects.Gain{Streamer: g.next.resampled, Gain: -1},
+		len:      g.current.rate.N(g.current.remaining()),
+	}
+	f.mixer.Add(f.out, f.in)
+
+	g.fade = f
+	g.current = g.next
+	g.next = nil
+	g.preloadRequested = false
+}
+
+// streamFade streams the mix of both tracks with linear gains and returns the
+// number of samples streamed.
+func (g *gaplessStreamer) streamFade(samples [][2]float64) int {
+
+	f := g.fade
+	n := 0
+
+	for n < len(samples) && f.pos < f.len {
+
+		progress := float64(f.pos) / float64(f.len)
+		// effects.Gain multiplies the samples by 1 + Gain
+		f.out.Gain = -progress
+		f.in.Gain = progress - 1
+
+		// the incoming track becomes dominant half way through
+		if !f.handedOver && progress >= 0.5 {
+			f.handedOver = true
+			g.handOver(f.outgoing, g.current)
+		}
+
+		chunk := len(samples) - n
+		if chunk > fadeChunk {
+			chunk = fadeChunk
+		}
+		if chunk > f.len-f.pos {
+			chunk = f.len - f.pos
+		}
+
+		sn, _ := f.mixer.Stream(samples[n : n+chunk])
+		n += sn
+		f.pos += sn
+
+		if sn == 0 || g.current.remaining() <= 0 || f.outgoing.remaining() <= 0 {
+			break
+		}
+	}
+
+	if f.pos >= f.len || g.current.remaining() <= 0 || f.outgoing.remaining() <= 0 {
+		g.endFade()
+	}
+
+	return n
+}
+
+// endFade releases the outgoing track once it has faded out.
+func (g *gaplessStreamer) endFade() {
+
+	f := g.fade
+	g.fade = nil
+
+	f.outgoing.stream.Close()
+
+	if !f.handedOver {
+		g.handOver(f.outgoing, g.current)
+	}
+}
+
+// cancelFade puts the outgoing track back when it has been seeked before the
+// incoming one became dominant. The next track is faded in again later.
+func (g *gaplessStreamer) cancelFade() {
+
+	f := g.fade
+	if f == nil || f.handedOver {
+		return
+	}
+
+	g.fade = nil
+	g.next = g.current
+	g.current = f.outgoing
+	g.preloadRequested = true
+
+	if err := g.next.rewind(); err != nil {
+		g.next.stream.Close()
+		g.next = nil
+		g.preloadRequested = false
+	}
+}
+
+// replace swaps the current track for t, the preloaded track is kept.
+func (g *gaplessStreamer) replace(t *track) {
+	g.current.stream.Close()
+	g.current = t
+}
+
+// close releases every track held by the streamer.
+func (g *gaplessStreamer) close() {
+	g.current.stream.Close()
+	if g.fade != nil {
+		g.fade.outgoing.stream.Close()
+		g.fade = nil
+	}
+	if g.next != nil {
+		g.next.stream.Close()
+		g.next = nil
+	}
+	g.done = true
+}
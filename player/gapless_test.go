@@ -0,0 +1,246 @@
+package player
+
+import (
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+type testAudio string
+
+func (a testAudio) Name() string { return string(a) }
+func (a testAudio) Path() string { return string(a) }
+
+// testStream yields its value for each sample.
+type testStream struct {
+	value  float64
+	len    int
+	pos    int
+	closed bool
+}
+
+func (s *testStream) Stream(samples [][2]float64) (n int, ok bool) {
+	for n < len(samples) && s.pos < s.len {
+		samples[n] = [2]float64{s.value, s.value}
+		n++
+		s.pos++
+	}
+	return n, n > 0
+}
+
+func (s *testStream) Err() error       { return nil }
+func (s *testStream) Len() int         { return s.len }
+func (s *testStream) Position() int    { return s.pos }
+func (s *testStream) Seek(p int) error { s.pos = p; return nil }
+func (s *testStream) Close() error     { s.closed = true; return nil }
+
+func newTestTrack(name string, value float64, n int) *track {
+	stream := &testStream{value: value, len: n}
+	return &track{
+		audio:     testAudio(name),
+		stream:    stream,
+		format:    beep.Format{SampleRate: defaultSampleRate},
+		rate:      defaultSampleRate,
+		scale:     1,
+		resampled: stream,
+	}
+}
+
+func TestGaplessSplice(t *testing.T) {
+
+	finished := make(chan Audio, 2)
+
+	p := New(100)
+	p.SetSongFinish(func(a Audio) { finished <- a })
+
+	a := newTestTrack("a", 1, 3)
+	b := newTestTrack("b", 2, 5)
+
+	p.SetNextSong(func() Audio { return b.audio })
+
+	g := &gaplessStreamer{p: p, current: a, next: b, preloadRequested: true}
+	// events of other streamers are ignored
+	p.tracks = g
+
+	samples := make([][2]float64, 6)
+	n, ok := g.Stream(samples)
+	if n != 6 || !ok {
+		t.Fatalf("expected 6 samples got %d %v", n, ok)
+	}
+
+	expected := []float64{1, 1, 1, 2, 2, 2}
+	for i, v := range expected {
+		if samples[i][0] != v {
+			t.Errorf("sample %d; expected %v got %v", i, v, samples[i][0])
+		}
+	}
+
+	if got := <-finished; got != a.audio {
+		t.Errorf("expected %s to finish got %s", a.audio.Name(), got.Name())
+	}
+
+	if !a.stream.(*testStream).closed {
+		t.Error("finished track is not closed")
+	}
+
+	if p.spliced != b.audio || p.GetCurrentSong() != b.audio {
+		t.Errorf("expected %s to be spliced in", b.audio.Name())
+	}
+
+	// b is still the head of the queue and must not preload itself
+	if g.preloadRequested {
+		t.Errorf("expected %s not to be preloaded again", b.audio.Name())
+	}
+
+	// nothing left to continue with
+	p.SetNextSong(func() Audio { return nil })
+
+	n, ok = g.Stream(samples)
+	if n != 2 || !ok {
+		t.Fatalf("expected remaining 2 samples got %d %v", n, ok)
+	}
+
+	if got := <-finished; got != b.audio {
+		t.Errorf("expected %s to finish got %s", b.audio.Name(), got.Name())
+	}
+
+	if n, ok = g.Stream(samples); n != 0 || ok {
+		t.Errorf("expected drained streamer got %d %v", n, ok)
+	}
+}
+
+func TestGaplessQueueChanged(t *testing.T) {
+
+	finished := make(chan Audio, 1)
+
+	p := New(100)
+	p.SetSongFinish(func(a Audio) { finished <- a })
+
+	a := newTestTrack("a", 1, 2)
+	b := newTestTrack("b", 2, 2)
+
+	// b was preloaded but is no longer the head of the queue
+	p.SetNextSong(func() Audio { return testAudio("c") })
+
+	g := &gaplessStreamer{p: p, current: a, next: b, preloadRequested: true}
+	p.tracks = g
+
+	samples := make([][2]float64, 4)
+	n, ok := g.Stream(samples)
+	if n != 2 || !ok {
+		t.Fatalf("expected 2 samples got %d %v", n, ok)
+	}
+
+	select {
+	case got := <-finished:
+		if got != a.audio {
+			t.Errorf("expected %s to finish got %s", a.audio.Name(), got.Name())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("song finish callback is not executed")
+	}
+
+	if !b.stream.(*testStream).closed {
+		t.Error("stale preloaded track is not closed")
+	}
+
+	if p.spliced != nil || p.IsRunning() {
+		t.Error("player should stop when the preloaded song is stale")
+	}
+}
+
+func TestCrossfade(t *testing.T) {
+
+	finished := make(chan Audio, 1)
+
+	p := New(100)
+	p.SetSongFinish(func(a Audio) { finished <- a })
+	p.SetCrossfade(time.Second)
+
+	a := newTestTrack("a", 1, defaultSampleRate.N(2*time.Second))
+	b := newTestTrack("b", 1, defaultSampleRate.N(3*time.Second))
+
+	p.SetNextSong(func() Audio { return b.audio })
+
+	g := &gaplessStreamer{p: p, current: a, next: b, preloadRequested: true}
+	p.tracks = g
+
+	stream := func(d time.Duration) [][2]float64 {
+		samples := make([][2]float64, defaultSampleRate.N(d))
+		n, ok := g.Stream(samples)
+		if n != len(samples) || !ok {
+			t.Fatalf("expected %d samples got %d %v", len(samples), n, ok)
+		}
+		return samples
+	}
+
+	// fading starts at 1s and the incoming song is dominant at 1.5s
+	stream(1400 * time.Millisecond)
+
+	select {
+	case <-finished:
+		t.Fatal("outgoing song finished before the incoming song is dominant")
+	default:
+	}
+
+	if g.fade == nil {
+		t.Fatal("crossfade has not started")
+	}
+
+	samples := stream(200 * time.Millisecond)
+
+	if got := <-finished; got != a.audio {
+		t.Errorf("expected %s to finish got %s", a.audio.Name(), got.Name())
+	}
+
+	if p.spliced != b.audio {
+		t.Errorf("expected %s to be spliced in", b.audio.Name())
+	}
+
+	// linear gains of two equal signals add up to the same level
+	for i, s := range samples {
+		if s[0] < 0.99 || s[0] > 1.01 {
+			t.Fatalf("sample %d; expected constant level got %v", i, s[0])
+		}
+	}
+
+	stream(500 * time.Millisecond)
+
+	if g.fade != nil || !a.stream.(*testStream).closed {
+		t.Error("outgoing song is not released after crossfade")
+	}
+}
+
+func TestCrossfadeCancel(t *testing.T) {
+
+	p := New(100)
+	p.SetCrossfade(time.Second)
+
+	a := newTestTrack("a", 1, defaultSampleRate.N(2*time.Second))
+	b := newTestTrack("b", 1, defaultSampleRate.N(3*time.Second))
+
+	p.SetNextSong(func() Audio { return b.audio })
+
+	g := &gaplessStreamer{p: p, current: a, next: b, preloadRequested: true}
+	p.tracks = g
+
+	samples := make([][2]float64, defaultSampleRate.N(1200*time.Millisecond))
+	g.Stream(samples)
+
+	if g.fade == nil {
+		t.Fatal("crossfade has not started")
+	}
+
+	// seeking back in the outgoing song
+	a.stream.Seek(0)
+	g.cancelFade()
+
+	if g.fade != nil || g.current != a || g.next != b {
+		t.Fatal("crossfade is not cancelled")
+	}
+
+	if b.stream.Position() != 0 {
+		t.Errorf("incoming song is not rewound, position %d", b.stream.Position())
+	}
+}
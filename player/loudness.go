@@ -0,0 +1,239 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"math"
+	"os"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/ztrue/tracerr"
+)
+
+// referenceLoudness is the replaygain 2.0 reference level in LUFS.
+const referenceLoudness = -18
+
+// biquad is a second order IIR filter with state for each channel.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             [2]float64
+}
+
+func (f *biquad) process(ch int, x float64) float64 {
+	y := f.b0*x + f.z1[ch]
+	f.z1[ch] = f.b1*x - f.a1*y + f.z2[ch]
+	f.z2[ch] = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the high shelf and high pass filters of ITU-R BS.1770
+// for the sample rate.
+func kWeighting(rate float64) (shelf, highpass biquad) {
+
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+
+	highpass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	return shelf, highpass
+}
+
+// loudnessMeter measures the loudness of a stream according to EBU R128 with
+// 400ms gating blocks overlapping by 75%.
+type loudnessMeter struct {
+	shelf    biquad
+	highpass biquad
+	channels int
+	// number of samples in 100ms
+	step int
+
+	energy float64
+	count  int
+	// energies of the last four 100ms steps
+	steps  []float64
+	blocks []float64
+	peak   float64
+}
+
+func newLoudnessMeter(format beep.Format) *loudnessMeter {
+
+	shelf, highpass := kWeighting(float64(format.SampleRate))
+
+	channels := format.NumChannels
+	if channels < 1 || channels > 2 {
+		channels = 2
+	}
+
+	return &loudnessMeter{
+		shelf:    shelf,
+		highpass: highpass,
+		channels: channels,
+		step:     format.SampleRate.N(time.Second / 10),
+	}
+}
+
+func (m *loudnessMeter) add(samples [][2]float64) {
+
+	for _, s := range samples {
+
+		// mono is duplicated to both channels by the decoders
+		for ch := 0; ch < m.channels; ch++ {
+			if a := math.Abs(s[ch]); a > m.peak {
+				m.peak = a
+			}
+			y := m.highpass.process(ch, m.shelf.process(ch, s[ch]))
+			m.energy += y * y
+		}
+
+		m.count++
+		if m.count < m.step {
+			continue
+		}
+
+		m.steps = append(m.steps, m.energy)
+		if len(m.steps) > 4 {
+			m.steps = m.steps[1:]
+		}
+
+		if len(m.steps) == 4 {
+			var sum float64
+			for _, e := range m.steps {
+				sum += e
+			}
+			m.blocks = append(m.blocks, sum/float64(4*m.step))
+		}
+
+		m.energy, m.count = 0, 0
+	}
+}
+
+func energyToLoudness(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+// integratedLoudness applies the absolute gate of -70 LUFS and the relative
+// gate of -10 LU to the block energies. It returns -Inf for silence.
+func integratedLoudness(blocks []float64) float64 {
+
+	gate := func(threshold float64) (float64, int) {
+		var sum float64
+		var n int
+		for _, e := range blocks {
+			if energyToLoudness(e) > threshold {
+				sum += e
+				n++
+			}
+		}
+		return sum, n
+	}
+
+	sum, n := gate(-70)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	relative := energyToLoudness(sum/float64(n)) - 10
+
+	sum, n = gate(relative)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	return energyToLoudness(sum / float64(n))
+}
+
+// gainOf returns the replaygain of the measured loudness, silence is left
+// untouched.
+func gainOf(loudness float64) float64 {
+	if math.IsInf(loudness, -1) {
+		return 0
+	}
+	return referenceLoudness - loudness
+}
+
+// ScanReplayGain measures the loudness of the songs according to EBU R128 and
+// returns their replaygain. The album gain covers all the songs.
+func ScanReplayGain(paths []string) ([]ReplayGain, error) {
+
+	gains := make([]ReplayGain, len(paths))
+
+	var albumBlocks []float64
+	var albumPeak float64
+
+	for i, path := range paths {
+
+		m, err := measureLoudness(path)
+		if err != nil {
+			return nil, tracerr.Wrap(err)
+		}
+
+		gains[i] = ReplayGain{
+			TrackGain: gainOf(integratedLoudness(m.blocks)),
+			TrackPeak: m.peak,
+			HasTrack:  true,
+		}
+
+		albumBlocks = append(albumBlocks, m.blocks...)
+		albumPeak = math.Max(albumPeak, m.peak)
+	}
+
+	albumGain := gainOf(integratedLoudness(albumBlocks))
+
+	for i := range gains {
+		gains[i].AlbumGain = albumGain
+		gains[i].AlbumPeak = albumPeak
+		gains[i].HasAlbum = true
+	}
+
+	return gains, nil
+}
+
+func measureLoudness(path string) (*loudnessMeter, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, format, err := Decode(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer stream.Close()
+
+	m := newLoudnessMeter(format)
+	samples := make([][2]float64, 4096)
+
+	for {
+		n, ok := stream.Stream(samples)
+		m.add(samples[:n])
+		if !ok {
+			break
+		}
+	}
+
+	return m, tracerr.Wrap(stream.Err())
+}
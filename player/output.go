@@ -0,0 +1,220 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/speaker"
+	"github.com/ztrue/tracerr"
+)
+
+// Output plays the stream of the player.
+type Output interface {
+	// Init is called once before the first song plays with the sample rate
+	// of the stream and the number of samples to buffer.
+	Init(rate beep.SampleRate, bufferSize int) error
+	// Play starts streaming s until it is drained.
+	Play(s beep.Streamer)
+	// Close stops streaming and releases the output.
+	Close() error
+}
+
+// speakerOutput plays through the sound card.
+type speakerOutput struct{}
+
+// NewSpeaker returns the output playing through the sound card.
+func NewSpeaker() Output {
+	return speakerOutput{}
+}
+
+func (speakerOutput) Init(rate beep.SampleRate, bufferSize int) error {
+	return tracerr.Wrap(speaker.Init(rate, bufferSize))
+}
+
+func (speakerOutput) Play(s beep.Streamer) {
+	speaker.Play(s)
+}
+
+func (speakerOutput) Close() error {
+	speaker.Close()
+	return nil
+}
+
+// sink pulls the stream in real time like a sound card and writes the samples
+// as 16 bit little endian stereo PCM, or discards them when there is nowhere
+// to write.
+type sink struct {
+	mu    sync.Mutex
+	mixer beep.Mixer
+	w     io.Writer
+	// the file is a wav file whose header is completed on Close
+	file *os.File
+	wav  bool
+	rate beep.SampleRate
+	// written is the number of bytes of samples written
+	written int64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewNullOutput returns an output which plays without a sound card and
+// discards the samples, e.g. for tests.
+func NewNullOutput() Output {
+	return &sink{}
+}
+
+// NewPCMOutput returns an output writing raw 16 bit little endian stereo PCM
+// to w, e.g. os.Stdout to pipe it to another program.
+func NewPCMOutput(w io.Writer) Output {
+	return &sink{w: w}
+}
+
+// NewWavOutput returns an output writing a wav file at the path.
+func NewWavOutput(path string) (Output, error) {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return &sink{w: f, file: f, wav: true}, nil
+}
+
+func (s *sink) Init(rate beep.SampleRate, bufferSize int) error {
+
+	s.rate = rate
+
+	// the sizes are filled in once the file is complete
+	if s.wav {
+		if err := writeWavHeader(s.w, rate, 0); err != nil {
+			return tracerr.Wrap(err)
+		}
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.run(rate.D(bufferSize), make([][2]float64, bufferSize))
+
+	return nil
+}
+
+func (s *sink) Play(streamer beep.Streamer) {
+	s.mu.Lock()
+	s.mixer.Add(streamer)
+	s.mu.Unlock()
+}
+
+// run streams a buffer every interval, the mixer streams silence when there
+// is nothing to play.
+func (s *sink) run(interval time.Duration, samples [][2]float64) {
+
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	buf := make([]byte, len(samples)*4)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		s.mixer.Stream(samples)
+		s.mu.Unlock()
+
+		if s.w == nil {
+			continue
+		}
+
+		for i, sample := range samples {
+			binary.LittleEndian.PutUint16(buf[i*4:], uint16(toInt16(sample[0])))
+			binary.LittleEndian.PutUint16(buf[i*4+2:], uint16(toInt16(sample[1])))
+		}
+
+		n, err := s.w.Write(buf)
+		s.written += int64(n)
+		// e.g. the reading end of the pipe has gone away
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *sink) Close() error {
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	if s.file == nil {
+		return nil
+	}
+
+	defer s.file.Close()
+
+	if !s.wav || s.rate == 0 {
+		return nil
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return tracerr.Wrap(writeWavHeader(s.file, s.rate, s.written))
+}
+
+// toInt16 converts the sample to 16 bit, clipping it.
+func toInt16(sample float64) int16 {
+	return int16(math.Max(-1, math.Min(1, sample)) * math.MaxInt16)
+}
+
+// writeWavHeader writes the header of 16 bit stereo PCM with the size of the
+// data in bytes.
+func writeWavHeader(w io.Writer, rate beep.SampleRate, size int64) error {
+
+	header := struct {
+		Riff          [4]byte
+		FileSize      uint32
+		Wave          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		Riff:          [4]byte{'R', 'I', 'F', 'F'},
+		FileSize:      uint32(36 + size),
+		Wave:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        1,
+		Channels:      2,
+		SampleRate:    uint32(rate),
+		ByteRate:      uint32(rate) * 4,
+		BlockAlign:    4,
+		BitsPerSample: 16,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      uint32(size),
+	}
+
+	return binary.Write(w, binary.LittleEndian, header)
+}
@@ -0,0 +1,125 @@
+package player
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+func TestWavOutput(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "out.wav")
+
+	output, err := NewWavOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10ms buffers
+	if err := output.Init(8000, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	output.Play(&testStream{value: 0.5, len: 400})
+	time.Sleep(100 * time.Millisecond)
+
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) < 44+400*4 || string(content[:4]) != "RIFF" || string(content[8:12]) != "WAVE" {
+		t.Fatalf("Expected a wav file with the samples; got %d bytes", len(content))
+	}
+
+	if rate := binary.LittleEndian.Uint32(content[24:]); rate != 8000 {
+		t.Errorf("Expected sample rate 8000; got %d", rate)
+	}
+
+	if size := binary.LittleEndian.Uint32(content[40:]); int(size) != len(content)-44 {
+		t.Errorf("Expected data size %d; got %d", len(content)-44, size)
+	}
+
+	// left and right of the first sample
+	for _, i := range []int{44, 46} {
+		if got := int16(binary.LittleEndian.Uint16(content[i:])); got != 16383 {
+			t.Errorf("Expected sample 16383 at %d; got %d", i, got)
+		}
+	}
+}
+
+func TestNullOutput(t *testing.T) {
+
+	finished := make(chan Audio, 1)
+
+	p := New(100)
+	p.SetSongFinish(func(a Audio) { finished <- a })
+	p.SetOutput(NewNullOutput(), 8000, 10*time.Millisecond)
+
+	a := newTestTrack("a", 1, 400)
+	a.format = beep.Format{SampleRate: 8000, NumChannels: 2, Precision: 2}
+	a.rate = 8000
+
+	var err error
+	p.do(func() { err = p.start(a) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.HasInit() || p.State() != Playing {
+		t.Fatalf("Expected the song to play; got %v", p.State())
+	}
+
+	select {
+	case got := <-finished:
+		if got != a.audio {
+			t.Errorf("Expected %s to finish; got %s", a.audio.Name(), got.Name())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the song to be played to the end")
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartAt(t *testing.T) {
+
+	p := New(100)
+	p.SetOutput(NewNullOutput(), 8000, 10*time.Millisecond)
+
+	a := newTestTrack("a", 1, 8000*10)
+	a.format = beep.Format{SampleRate: 8000, NumChannels: 2, Precision: 2}
+	a.rate = 8000
+
+	// a resumed session starts paused at the saved position
+	p.StartAt(4 * time.Second)
+	p.Pause()
+
+	var err error
+	p.do(func() { err = p.start(a) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.State() != Paused {
+		t.Errorf("Expected the song to start paused; got %v", p.State())
+	}
+
+	if got := p.GetPosition(); got != 4*time.Second {
+		t.Errorf("Expected the song to start at 4s; got %v", got)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,38 +2,89 @@
 package player
 
 import (
+	"math"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/faiface/beep"
 	"github.com/faiface/beep/effects"
-	"github.com/faiface/beep/mp3"
-	"github.com/faiface/beep/speaker"
 	"github.com/ztrue/tracerr"
 )
 
+// defaultSampleRate is the sample rate of the output unless it is set with
+// SetOutput, every song is resampled to it.
+const defaultSampleRate = beep.SampleRate(48000)
+
+// defaultBufferSize is the duration buffered by the output unless it is set
+// with SetOutput.
+const defaultBufferSize = 100 * time.Millisecond
+
 type Audio interface {
 	Name() string
 	Path() string
 }
 
+// Player plays one song at a time. Its state is only changed by an event
+// loop which runs the commands of the exported methods in order, so it can be
+// driven from any goroutine.
 type Player struct {
-	hasInit   bool
-	isRunning bool
-	volume    float64
+	commands chan func()
+	// notes are posted by the output goroutine which must not block
+	notes  *mailbox
+	events *mailbox
+
+	statusMu sync.RWMutex
+	status   status
+
+	// owned by the event loop
+	// generation changes whenever a song is started or stopped, a song
+	// decoded in the meantime is discarded
+	generation int
+	// the song being loaded starts paused
+	pauseNext bool
+	// the song being loaded starts at this position
+	startNext time.Duration
+	// pendingTitle is the title of the preloaded stream, it is set once the
+	// stream takes over
+	pendingTitle streamTitle
+	// output and bufferSize are set until the output is initialized
+	output     Output
+	bufferSize time.Duration
+
+	// mu guards the state read by the stream, the output holds it while
+	// streaming
+	mu        sync.Mutex
+	crossfade time.Duration
+
+	replayGainMode  ReplayGainMode
+	preamp          float64
+	preventClipping bool
+
+	speed         float64
+	preservePitch bool
 
 	vol              *effects.Volume
+	resampler        *beep.Resampler
+	stretch          *timeStretch
+	effects          []Effect
 	ctrl             *beep.Ctrl
 	format           *beep.Format
 	length           time.Duration
-	currentSong      Audio
 	streamSeekCloser beep.StreamSeekCloser
-
+	tracks           *gaplessStreamer
+	// spliced is the preloaded song which took over the stream and is yet
+	// to be passed to Run
+	spliced Audio
+	// lastPosition is the position of the song which was stopped
+	lastPosition time.Duration
+	nextSong     func() Audio
+
+	handlersMu sync.Mutex
 	songFinish func(Audio)
 	songStart  func(Audio)
-	songSkip   func(Audio)
-	mu         sync.Mutex
+	songSkip   func(Audio, time.Duration)
+	handlers   []func(Event)
 }
 
 // New returns new Player instance.
@@ -47,242 +98,685 @@ func New(volume int) *Player {
 		initVol = 0
 	}
 
-	return &Player{volume: initVol}
+	p := &Player{
+		commands:      make(chan func()),
+		notes:         newMailbox(),
+		events:        newMailbox(),
+		status:        status{volume: initVol, rate: defaultSampleRate},
+		output:        NewSpeaker(),
+		bufferSize:    defaultBufferSize,
+		speed:         1,
+		preservePitch: true,
+	}
+
+	go p.loop()
+	go p.dispatch()
+
+	return p
 }
 
 // SetSongFinish accepts callback which will be executed when the song finishes.
 func (p *Player) SetSongFinish(f func(Audio)) {
+	p.handlersMu.Lock()
 	p.songFinish = f
+	p.handlersMu.Unlock()
 }
 
 // SetSongStart accepts callback which will be executed when the song starts.
 func (p *Player) SetSongStart(f func(Audio)) {
+	p.handlersMu.Lock()
 	p.songStart = f
+	p.handlersMu.Unlock()
 }
 
-// SetSongSkip accepts callback which will be executed when the song is skipped.
-func (p *Player) SetSongSkip(f func(Audio)) {
+// SetSongSkip accepts callback which will be executed when the song is skipped
+// with the position it was skipped at.
+func (p *Player) SetSongSkip(f func(Audio, time.Duration)) {
+	p.handlersMu.Lock()
 	p.songSkip = f
+	p.handlersMu.Unlock()
 }
 
-// executes songFinish callback.
-func (p *Player) execSongFinish(a Audio) {
-	if p.songFinish != nil {
-		p.songFinish(a)
-	}
+// SetNextSong accepts callback which returns the song to be played after the
+// current one or nil if there is none. The song is decoded ahead of time so
+// there is no gap between the two songs. It is called by the output
+// goroutine.
+func (p *Player) SetNextSong(f func() Audio) {
+	p.mu.Lock()
+	p.nextSong = f
+	p.mu.Unlock()
 }
 
-// executes songStart callback.
-func (p *Player) execSongStart(a Audio) {
-	if p.songStart != nil {
-		p.songStart(a)
-	}
+// SetOutput sets where the songs are played, the sample rate every song is
+// resampled to and the duration buffered by the output. It only takes effect
+// before the first song plays.
+func (p *Player) SetOutput(output Output, sampleRate int, bufferSize time.Duration) {
+
+	rate := beep.SampleRate(sampleRate)
+
+	p.do(func() {
+
+		if p.getStatus().hasInit {
+			return
+		}
+
+		p.output = output
+		p.bufferSize = bufferSize
+
+		p.statusMu.Lock()
+		p.status.rate = rate
+		p.statusMu.Unlock()
+
+		p.mu.Lock()
+		for _, e := range p.effects {
+			if r, ok := e.(sampleRateSetter); ok {
+				r.SetSampleRate(rate)
+			}
+		}
+		p.mu.Unlock()
+	})
+}
+
+// SetCrossfade sets the duration the ending song fades out while the next
+// song fades in. Zero disables crossfade.
+func (p *Player) SetCrossfade(d time.Duration) {
+	p.mu.Lock()
+	p.crossfade = d
+	p.mu.Unlock()
+}
+
+// SetReplayGain sets which replaygain is applied to the songs played next.
+// preamp in dB is added to the gain and preventClipping lowers the gain so
+// the peak of the song does not clip.
+func (p *Player) SetReplayGain(mode ReplayGainMode, preamp float64, preventClipping bool) {
+	p.mu.Lock()
+	p.replayGainMode = mode
+	p.preamp = preamp
+	p.preventClipping = preventClipping
+	p.mu.Unlock()
 }
 
-// executes songFinish callback.
-func (p *Player) execSongSkip(a Audio) {
-	if p.songSkip != nil {
-		p.songSkip(a)
+// SetSpeed sets the playback speed limited to MinSpeed and MaxSpeed and
+// returns the speed which was set. It takes effect immediately when a song is
+// playing.
+func (p *Player) SetSpeed(speed float64) float64 {
+
+	speed = math.Max(MinSpeed, math.Min(MaxSpeed, speed))
+	// steps of 0.05 do not add up to exactly 1
+	speed = math.Round(speed*100) / 100
+
+	p.mu.Lock()
+	p.speed = speed
+	p.applySpeed()
+	p.mu.Unlock()
+
+	return speed
+}
+
+// Speed returns the playback speed.
+func (p *Player) Speed() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.speed
+}
+
+// SetPreservePitch sets whether the pitch stays the same when the speed
+// changes, otherwise the song is played faster or slower like a tape.
+func (p *Player) SetPreservePitch(preserve bool) {
+	p.mu.Lock()
+	p.preservePitch = preserve
+	p.applySpeed()
+	p.mu.Unlock()
+}
+
+// applySpeed changes the speed of the stream, mu must be held.
+func (p *Player) applySpeed() {
+
+	ratio, stretch := p.speed, 1.0
+	if p.preservePitch {
+		ratio, stretch = 1, p.speed
+	}
+
+	if p.resampler != nil {
+		p.resampler.SetRatio(ratio)
+	}
+
+	if p.stretch != nil {
+		p.stretch.setSpeed(stretch)
 	}
 }
 
-// Run plays the passed Audio.
-func (p *Player) Run(currSong Audio) error {
+// replayGainScale reads the replaygain tags of the song and returns the
+// linear factor to be applied.
+func (p *Player) replayGainScale(audioPath string) float64 {
 
-	p.isRunning = true
-	p.execSongStart(currSong)
+	p.mu.Lock()
+	mode, preamp, preventClipping := p.replayGainMode, p.preamp, p.preventClipping
+	p.mu.Unlock()
 
-	f, err := os.Open(currSong.Path())
-	if err != nil {
-		return tracerr.Wrap(err)
+	if mode == ReplayGainOff {
+		return 1
 	}
 
-	stream, format, err := mp3.Decode(f)
+	tag, err := OpenTag(audioPath)
 	if err != nil {
-		return tracerr.Wrap(err)
+		return 1
 	}
+	defer tag.Close()
 
-	p.streamSeekCloser = stream
+	return ReadReplayGain(tag).Scale(mode, preamp, preventClipping)
+}
 
-	// song duration
-	p.length = format.SampleRate.D(p.streamSeekCloser.Len())
+// Run plays the passed Audio. The song is decoded by the calling goroutine,
+// a song passed to Run or stopped in the meantime replaces it.
+func (p *Player) Run(currSong Audio) error {
+
+	resumed := false
+	generation := 0
+
+	p.do(func() {
+
+		p.mu.Lock()
+		spliced := p.spliced
+		p.spliced = nil
+		p.mu.Unlock()
+
+		// the song is already playing as it was spliced in after the
+		// previous one
+		if spliced != nil && spliced == currSong {
+			resumed = true
+			p.emit(SongStarted{Song: currSong})
+			return
+		}
+
+		p.drain()
+
+		p.mu.Lock()
+		p.lastPosition = 0
+		p.mu.Unlock()
+
+		p.generation++
+		generation = p.generation
+		p.pendingTitle = streamTitle{}
+
+		// the song start callback may ask for the current song
+		p.setSong(currSong)
+		p.setState(Loading)
+		p.emit(SongStarted{Song: currSong})
+	})
+
+	if resumed {
+		return nil
+	}
+
+	t, err := p.openTrack(currSong)
+
+	p.do(func() {
+
+		if p.generation != generation {
+			if t != nil {
+				t.stream.Close()
+			}
+			err = nil
+			return
+		}
+
+		if err == nil {
+			err = p.start(t)
+		}
+
+		if err != nil {
+			p.pauseNext = false
+			p.startNext = 0
+			p.setState(Stopped)
+		}
+	})
+
+	return tracerr.Wrap(err)
+}
+
+// start plays the decoded track, it is called by the event loop.
+func (p *Player) start(t *track) error {
 
-	sr := beep.SampleRate(48000)
-	if !p.hasInit {
+	st := p.getStatus()
 
-		// p.mu.Lock()
-		err := speaker.Init(sr, sr.N(time.Second/10))
-		// p.mu.Unlock()
+	if !st.hasInit {
 
+		err := p.output.Init(st.rate, st.rate.N(p.bufferSize))
 		if err != nil {
+			t.stream.Close()
 			return tracerr.Wrap(err)
 		}
 
-		p.hasInit = true
+		p.statusMu.Lock()
+		p.status.hasInit = true
+		p.statusMu.Unlock()
 	}
 
-	p.currentSong = currSong
-
-	// resample to adapt to sample rate of new songs
-	resampled := beep.Resample(4, format.SampleRate, sr, p.streamSeekCloser)
+	// seeked before the output pulls any sample, a stream has no position
+	if p.startNext > 0 && !t.live {
+		n := t.format.SampleRate.N(p.startNext)
+		if length := t.stream.Len(); n > length {
+			n = length
+		}
+		if err := t.stream.Seek(n); err != nil {
+			t.stream.Close()
+			return tracerr.Wrap(err)
+		}
+		t.resample()
+	}
+	p.startNext = 0
 
-	sstreamer := beep.Seq(resampled, beep.Callback(func() {
-		p.isRunning = false
-		p.format = nil
-		p.streamSeekCloser.Close()
-		go p.execSongFinish(currSong)
-	}))
+	tracks := &gaplessStreamer{p: p, current: t}
 
 	ctrl := &beep.Ctrl{
-		Streamer: sstreamer,
-		Paused:   false,
+		Streamer: tracks,
+		Paused:   p.pauseNext,
 	}
 
-	p.mu.Lock()
-	p.format = &format
-	p.ctrl = ctrl
-	p.mu.Unlock()
+	// the speed is changed by resampling unless the pitch is preserved
 	resampler := beep.ResampleRatio(4, 1, ctrl)
+	stretch := newTimeStretch(resampler, 1)
 
 	volume := &effects.Volume{
-		Streamer: resampler,
+		Streamer: stretch,
 		Base:     2,
-		Volume:   0,
+		Volume:   st.volume,
 		Silent:   false,
 	}
 
-	// sets the volume of previous player
-	volume.Volume += p.volume
+	p.mu.Lock()
+	p.streamSeekCloser = t.stream
+	p.format = &t.format
+	// song duration
+	p.length = t.format.SampleRate.D(t.stream.Len())
+	p.ctrl = ctrl
+	p.tracks = tracks
+	p.resampler = resampler
+	p.stretch = stretch
+	p.applySpeed()
 	p.vol = volume
+	p.mu.Unlock()
+
+	if p.pauseNext {
+		p.setState(Paused)
+	} else {
+		p.setState(Playing)
+	}
+	p.pauseNext = false
 
 	// starts playing the audio
-	speaker.Play(p.vol)
+	p.output.Play(&effectChain{p: p, streamer: volume})
 
 	return nil
 }
 
-// Pause pauses Player.
+// peekNextSong returns the song to be played next.
+func (p *Player) peekNextSong() Audio {
+	if p.nextSong == nil {
+		return nil
+	}
+	return p.nextSong()
+}
+
+// preload decodes the next song in the background and hands it over to the
+// streamer unless the current track has changed in the meantime.
+func (p *Player) preload(g *gaplessStreamer, current *track, audio Audio) {
+
+	// on failure the song is played the regular way which reports the error
+	next, err := p.openTrack(audio)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if g.done || g.current != current {
+		next.stream.Close()
+		return
+	}
+
+	g.next = next
+}
+
+// drain stops the stream without running any callback, the last position is
+// kept. It is called by the event loop.
+func (p *Player) drain() {
+	p.mu.Lock()
+	if p.format != nil && p.streamSeekCloser != nil {
+		p.lastPosition = p.format.SampleRate.D(p.streamSeekCloser.Position())
+	}
+	if p.ctrl != nil {
+		p.ctrl.Streamer = nil
+	}
+	if p.tracks != nil {
+		p.tracks.close()
+	}
+	p.tracks = nil
+	p.spliced = nil
+	p.format = nil
+	p.streamSeekCloser = nil
+	p.mu.Unlock()
+}
+
+// ended is called by the event loop when the streamer has nothing left to
+// play.
+func (p *Player) ended(g *gaplessStreamer, song Audio) {
+
+	// a song has been started since
+	if g != p.tracks {
+		return
+	}
+
+	p.mu.Lock()
+	p.tracks = nil
+	p.mu.Unlock()
+
+	p.setState(Stopped)
+	p.emit(SongFinished{Song: song})
+}
+
+// handedOver is called by the event loop when the streamer has continued with
+// the preloaded song.
+func (p *Player) handedOver(g *gaplessStreamer, prev, next Audio) {
+
+	if g != p.tracks {
+		return
+	}
+
+	p.setSong(next)
+	p.emit(SongFinished{Song: prev})
+
+	if p.pendingTitle.song == next {
+		p.setStreamTitle(next, p.pendingTitle.title)
+	}
+	p.pendingTitle = streamTitle{}
+}
+
+// streamTitle is a title sent by the station of the stream of the song.
+type streamTitle struct {
+	song  Audio
+	title string
+}
+
+// streamTitleChanged is called by the event loop when the station of a
+// stream sends a title. The title of a stream which is preloaded is kept
+// until it takes over.
+func (p *Player) streamTitleChanged(song Audio, title string) {
+
+	if p.getStatus().song == song {
+		p.setStreamTitle(song, title)
+		return
+	}
+
+	p.pendingTitle = streamTitle{song: song, title: title}
+}
+
+// Pause pauses Player. While no song is playing the next song starts paused.
 func (p *Player) Pause() {
-	speaker.Lock()
-	p.ctrl.Paused = true
-	p.isRunning = false
-	speaker.Unlock()
+	p.do(func() {
+		switch p.getStatus().state {
+		case Playing:
+			p.mu.Lock()
+			p.ctrl.Paused = true
+			p.mu.Unlock()
+			p.setState(Paused)
+		case Loading, Stopped:
+			p.pauseNext = true
+		}
+	})
+}
+
+// StartAt makes the next song passed to Run start at the position, e.g. when
+// the previous session is resumed. Pause is used to start it paused.
+func (p *Player) StartAt(position time.Duration) {
+	p.do(func() {
+		p.startNext = position
+	})
 }
 
 // Play unpauses Player.
 func (p *Player) Play() {
-	speaker.Lock()
-	p.ctrl.Paused = false
-	p.isRunning = true
-	speaker.Unlock()
+	p.do(func() {
+		switch p.getStatus().state {
+		case Paused:
+			p.mu.Lock()
+			p.ctrl.Paused = false
+			p.mu.Unlock()
+			p.setState(Playing)
+		case Loading, Stopped:
+			p.pauseNext = false
+		}
+	})
 }
 
 // SetVolume set volume up and volume down using -0.5 or +0.5.
 func (p *Player) SetVolume(v float64) float64 {
 
-	// check if no songs playing currently
-	if p.vol == nil {
-		p.volume += v
-		return p.volume
-	}
+	var volume float64
+
+	p.do(func() {
 
-	speaker.Lock()
-	p.vol.Volume += v
-	p.volume = p.vol.Volume
-	speaker.Unlock()
-	return p.volume
+		p.statusMu.Lock()
+		p.status.volume += v
+		volume = p.status.volume
+		p.statusMu.Unlock()
+
+		p.mu.Lock()
+		if p.vol != nil {
+			p.vol.Volume = volume
+		}
+		p.mu.Unlock()
+
+		p.emit(VolumeChanged{Volume: volume})
+	})
+
+	return volume
 }
 
 // TogglePause toggles the pause state.
 func (p *Player) TogglePause() {
+	p.do(func() {
+		switch p.getStatus().state {
+		case Playing:
+			p.mu.Lock()
+			p.ctrl.Paused = true
+			p.mu.Unlock()
+			p.setState(Paused)
+		case Paused:
+			p.mu.Lock()
+			p.ctrl.Paused = false
+			p.mu.Unlock()
+			p.setState(Playing)
+		}
+	})
+}
 
-	if p.ctrl == nil {
-		return
-	}
+// Skip current song. The skip callback is followed by the finish callback.
+func (p *Player) Skip() {
+	p.do(func() {
 
-	if p.ctrl.Paused {
-		p.Play()
-	} else {
-		p.Pause()
-	}
+		st := p.getStatus()
+		if st.state == Stopped || st.song == nil {
+			return
+		}
+
+		p.drain()
+		p.generation++
+		p.pauseNext = false
+		p.startNext = 0
+		p.setState(Stopped)
+
+		p.mu.Lock()
+		position := p.lastPosition
+		p.mu.Unlock()
+
+		p.emit(SongSkipped{Song: st.song, Position: position})
+		p.emit(SongFinished{Song: st.song})
+	})
 }
 
-// Skip current song.
-func (p *Player) Skip() {
+// Stop stops the current song without the skip and finish callbacks, e.g.
+// when its file is deleted.
+func (p *Player) Stop() {
+	p.do(func() {
+		p.drain()
+		p.generation++
+		p.setState(Stopped)
+	})
+}
 
-	p.execSongSkip(p.currentSong)
+// Replace plays the song from the position of the current song, e.g. after
+// the file has been renamed. The pause is kept and no callback is run.
+func (p *Player) Replace(song Audio) error {
 
-	if p.currentSong == nil {
-		return
+	t, err := p.openTrack(song)
+	if err != nil {
+		return tracerr.Wrap(err)
 	}
 
-	// drain the stream
-	speaker.Lock()
-	p.ctrl.Streamer = nil
-	p.streamSeekCloser.Close()
-	p.isRunning = false
-	p.format = nil
-	speaker.Unlock()
+	p.do(func() {
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.tracks == nil || p.format == nil || p.streamSeekCloser == nil {
+			t.stream.Close()
+			return
+		}
+
+		position := p.format.SampleRate.D(p.streamSeekCloser.Position())
+		if err = t.stream.Seek(t.format.SampleRate.N(position)); err != nil {
+			t.stream.Close()
+			return
+		}
+		t.resample()
+
+		p.tracks.cancelFade()
+		p.tracks.replace(t)
+		p.streamSeekCloser = t.stream
+		p.format = &t.format
+		p.length = t.format.SampleRate.D(t.stream.Len())
+		if p.stretch != nil {
+			p.stretch.reset()
+		}
+
+		p.setSong(song)
+	})
+
+	return tracerr.Wrap(err)
+}
+
+// Close stops the current song and closes the output.
+func (p *Player) Close() error {
+
+	var err error
 
-	p.execSongFinish(p.currentSong)
+	p.do(func() {
+
+		p.drain()
+		p.generation++
+		p.setState(Stopped)
+
+		if p.getStatus().hasInit {
+			err = p.output.Close()
+		}
+	})
+
+	return tracerr.Wrap(err)
 }
 
-// GetPosition returns the current position of audio file.
+// GetPosition returns the current position of audio file. Once the song has
+// stopped it is the position it was stopped at.
 func (p *Player) GetPosition() time.Duration {
 
 	p.mu.Lock()
-	speaker.Lock()
-	defer speaker.Unlock()
 	defer p.mu.Unlock()
 	if p.format == nil || p.streamSeekCloser == nil {
-		return 1
+		return p.lastPosition
 	}
 
 	return p.format.SampleRate.D(p.streamSeekCloser.Position())
 }
 
-// Seek is the function to move forward and rewind
-func (p *Player) Seek(pos int) error {
+// Seek moves to the position in the current song, limited to the length of
+// the song, and emits Seeked. It works while paused as well.
+func (p *Player) Seek(position time.Duration) error {
+
+	var err error
+
+	p.do(func() {
+
+		p.mu.Lock()
+
+		if p.format == nil || p.streamSeekCloser == nil {
+			p.mu.Unlock()
+			return
+		}
+
+		n := p.format.SampleRate.N(position)
+		if n < 0 {
+			n = 0
+		}
+		if length := p.streamSeekCloser.Len(); n > length {
+			n = length
+		}
+
+		err = p.streamSeekCloser.Seek(n)
+		// the time stretch holds samples from before the seek
+		if p.stretch != nil {
+			p.stretch.reset()
+		}
+		// seeking the outgoing song stops the crossfade
+		if p.tracks != nil {
+			p.tracks.cancelFade()
+		}
+		position = p.format.SampleRate.D(n)
+
+		p.mu.Unlock()
+
+		if err == nil {
+			p.emit(Seeked{Song: p.getStatus().song, Position: position})
+		}
+	})
+
+	return tracerr.Wrap(err)
+}
+
+// GetLength returns the length of the current song, a stream has none.
+func (p *Player) GetLength() time.Duration {
 	p.mu.Lock()
-	speaker.Lock()
-	defer speaker.Unlock()
 	defer p.mu.Unlock()
-	err := p.streamSeekCloser.Seek(pos * int(p.format.SampleRate))
-	return err
+	return p.length
 }
 
 // IsPaused is used to distinguish the player between pause and stop
 func (p *Player) IsPaused() bool {
-	p.mu.Lock()
-	speaker.Lock()
-	defer speaker.Unlock()
-	defer p.mu.Unlock()
-	if p.ctrl == nil {
-		return false
-	}
-
-	return p.ctrl.Paused
+	return p.getStatus().state == Paused
 }
 
 // GetVolume returns current volume.
 func (p *Player) GetVolume() float64 {
-	return p.volume
+	return p.getStatus().volume
 }
 
 // GetCurrentSong returns current song.
 func (p *Player) GetCurrentSong() Audio {
-	return p.currentSong
+	return p.getStatus().song
 }
 
-// HasInit checks if the speaker has been initialized or not. Speaker
+// HasInit checks if the output has been initialized or not. Output
 // initialization will only happen once.
 func (p *Player) HasInit() bool {
-	return p.hasInit
+	return p.getStatus().hasInit
 }
 
-// IsRunning returns true if Player is running an audio.
+// IsRunning returns true if Player is running an audio, which includes
+// loading it.
 func (p *Player) IsRunning() bool {
-	return p.isRunning
+	state := p.getStatus().state
+	return state == Playing || state == Loading
 }
 
 // GetLength return the length of the song in the queue
@@ -295,7 +789,7 @@ func GetLength(audioPath string) (time.Duration, error) {
 
 	defer f.Close()
 
-	streamer, format, err := mp3.Decode(f)
+	streamer, format, err := Decode(f)
 
 	if err != nil {
 		return 0, tracerr.Wrap(err)
@@ -0,0 +1,188 @@
+package player
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// startTest makes the player play the track without initializing the output.
+func startTest(p *Player, t *track) *gaplessStreamer {
+	g := &gaplessStreamer{p: p, current: t}
+	p.do(func() {
+		p.mu.Lock()
+		p.tracks = g
+		p.ctrl = &beep.Ctrl{Streamer: g}
+		p.streamSeekCloser = t.stream
+		p.format = &t.format
+		p.mu.Unlock()
+		p.setSong(t.audio)
+		p.setState(Playing)
+	})
+	return g
+}
+
+func TestSeek(t *testing.T) {
+
+	stream := &testStream{len: 44100 * 10}
+	p := New(80)
+
+	// nothing to seek
+	if err := p.Seek(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	p.streamSeekCloser = stream
+	p.format = &beep.Format{SampleRate: 44100}
+
+	samples := []struct {
+		position time.Duration
+		expected int
+	}{
+		{1500 * time.Millisecond, 66150},
+		{-time.Second, 0},
+		{time.Minute, 441000},
+	}
+
+	for _, s := range samples {
+		if err := p.Seek(s.position); err != nil {
+			t.Fatal(err)
+		}
+		if stream.pos != s.expected {
+			t.Errorf("Expected seeking to %v to be at %d; got %d", s.position, s.expected, stream.pos)
+		}
+	}
+}
+
+func TestPlayerEvents(t *testing.T) {
+
+	events := make(chan Event, 16)
+
+	skippedAt := make(chan time.Duration, 1)
+
+	p := New(80)
+	p.OnEvent(func(e Event) { events <- e })
+	p.SetSongSkip(func(_ Audio, position time.Duration) { skippedAt <- position })
+
+	// nothing to skip
+	p.Skip()
+
+	a := newTestTrack("a", 1, 100)
+	startTest(p, a)
+
+	// the position is rounded to a sample
+	position := defaultSampleRate.D(20)
+	if err := p.Seek(position); err != nil {
+		t.Fatal(err)
+	}
+	volume := p.SetVolume(-1)
+
+	p.TogglePause()
+	if !p.IsPaused() || p.IsRunning() {
+		t.Errorf("Expected the player to be paused; got %v", p.State())
+	}
+
+	a.stream.Seek(40)
+	p.Skip()
+
+	if p.State() != Stopped || p.GetCurrentSong() != a.audio {
+		t.Errorf("Expected %s to be stopped; got %v", a.audio.Name(), p.State())
+	}
+
+	// the position is kept for the skip callback
+	if got := p.GetPosition(); got != defaultSampleRate.D(40) {
+		t.Errorf("Expected the position it was skipped at; got %v", got)
+	}
+
+	expected := []Event{
+		StateChanged{From: Stopped, To: Playing},
+		Seeked{Song: a.audio, Position: defaultSampleRate.D(defaultSampleRate.N(position))},
+		VolumeChanged{Volume: volume},
+		StateChanged{From: Playing, To: Paused},
+		StateChanged{From: Paused, To: Stopped},
+		SongSkipped{Song: a.audio, Position: defaultSampleRate.D(40)},
+		SongFinished{Song: a.audio},
+	}
+
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			if got != e {
+				t.Errorf("Expected %#v; got %#v", e, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %#v; got nothing", e)
+		}
+	}
+	// the skip callback is passed the position the song was skipped at
+	select {
+	case got := <-skippedAt:
+		if got != defaultSampleRate.D(40) {
+			t.Errorf("Expected the skip callback to get %v; got %v", defaultSampleRate.D(40), got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the skip callback to run")
+	}
+}
+
+func TestPlayerConcurrency(t *testing.T) {
+
+	p := New(80)
+	a := newTestTrack("a", 1, defaultSampleRate.N(time.Minute))
+	startTest(p, a)
+
+	var wg sync.WaitGroup
+
+	// the output
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		samples := make([][2]float64, 512)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p.mu.Lock()
+			p.ctrl.Stream(samples)
+			p.mu.Unlock()
+		}
+	}()
+
+	var callers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		callers.Add(1)
+		go func() {
+			defer callers.Done()
+			for j := 0; j < 100; j++ {
+				p.TogglePause()
+				p.SetVolume(0.1)
+				if err := p.Seek(time.Second); err != nil {
+					t.Error(err)
+				}
+				p.IsPaused()
+				p.IsRunning()
+				p.GetPosition()
+				p.GetCurrentSong()
+			}
+		}()
+	}
+
+	callers.Wait()
+	close(stop)
+	wg.Wait()
+
+	if got, expected := p.GetVolume(), AbsVolume(80)+40; math.Abs(got-expected) > 1e-6 {
+		t.Errorf("Expected volume %v; got %v", expected, got)
+	}
+
+	// an even number of toggles
+	if p.State() != Playing {
+		t.Errorf("Expected the player to be playing; got %v", p.State())
+	}
+}
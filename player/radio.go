@@ -0,0 +1,537 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/mp3"
+	"github.com/faiface/beep/vorbis"
+	"github.com/ztrue/tracerr"
+)
+
+// radioRetries is the number of times in a row a lost stream is reconnected
+// before it is given up.
+const radioRetries = 5
+
+// radioRetryDelay is waited before reconnecting, once more for every failed
+// attempt.
+const radioRetryDelay = time.Second
+
+// radioTimeout is how long the station may send nothing before the
+// connection is considered lost.
+const radioTimeout = 15 * time.Second
+
+// radioDialTimeout is how long connecting to the station may take.
+const radioDialTimeout = 10 * time.Second
+
+// liveBuffer is the most audio decoded ahead of the output.
+const liveBuffer = 10 * time.Second
+
+// livePrebuffer is the audio decoded before a stream starts playing, or
+// plays again after the network could not keep up.
+const livePrebuffer = 2 * time.Second
+
+// liveRemaining is what is left to be played of a live stream as far as
+// preloading and crossfading are concerned, it never ends.
+const liveRemaining = time.Hour
+
+// errRadioClosed is returned by the reads after the stream has been closed.
+var errRadioClosed = errors.New("the stream has been closed")
+
+// radioClient connects to the stations. There is no timeout for the whole
+// request as the audio is sent for as long as the stream plays.
+var radioClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: radioDialTimeout}).DialContext,
+		TLSHandshakeTimeout:   radioDialTimeout,
+		ResponseHeaderTimeout: radioTimeout,
+	},
+}
+
+// IsStream returns true if the path of the song is the URL of a stream
+// rather than a local file.
+func IsStream(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// radioReader reads the audio of an Icecast or Shoutcast station. The ICY
+// metadata interleaved with the audio is taken out and the title is passed
+// to onTitle. A lost connection is reconnected.
+type radioReader struct {
+	url     string
+	onTitle func(title string)
+
+	// contentType is the type of the audio sent by the station
+	contentType string
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	closed bool
+	stop   chan struct{}
+
+	// owned by the reading goroutine
+	metaInt int
+	// bytes of audio left until the next metadata block
+	left     int
+	title    string
+	attempts int
+}
+
+// openRadio connects to the station, it fails right away if the station
+// cannot be reached.
+func openRadio(streamURL string, onTitle func(title string)) (*radioReader, error) {
+
+	r := &radioReader{
+		url:     streamURL,
+		onTitle: onTitle,
+		stop:    make(chan struct{}),
+	}
+
+	if err := r.connect(); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return r, nil
+}
+
+func (r *radioReader) connect() error {
+
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	// asks for the title of the songs to be sent along the audio
+	req.Header.Set("Icy-MetaData", "1")
+	req.Header.Set("User-Agent", "gomu")
+
+	resp, err := radioClient.Do(req)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return tracerr.Errorf("%s: %s", r.url, resp.Status)
+	}
+
+	metaInt, _ := strconv.Atoi(resp.Header.Get("icy-metaint"))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		resp.Body.Close()
+		return tracerr.Wrap(errRadioClosed)
+	}
+
+	r.body = resp.Body
+	r.contentType = resp.Header.Get("Content-Type")
+	r.metaInt = metaInt
+	r.left = metaInt
+
+	return nil
+}
+
+// Read reads the audio without the metadata. It reconnects when the
+// connection is lost and only fails once reconnecting has been given up.
+func (r *radioReader) Read(b []byte) (int, error) {
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	for {
+		r.mu.Lock()
+		body, closed := r.body, r.closed
+		r.mu.Unlock()
+
+		if closed {
+			return 0, errRadioClosed
+		}
+
+		var n int
+		var err error
+
+		if body == nil {
+			err = r.connect()
+		} else {
+			n, err = r.readAudio(body, b)
+		}
+
+		if n > 0 {
+			r.attempts = 0
+			return n, nil
+		}
+
+		if err == nil {
+			continue
+		}
+
+		if err := r.retry(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readAudio reads the audio up to the next metadata block, the block is read
+// when it is reached. A stalled connection is closed.
+func (r *radioReader) readAudio(body io.ReadCloser, b []byte) (int, error) {
+
+	stalled := time.AfterFunc(radioTimeout, func() { body.Close() })
+	defer stalled.Stop()
+
+	if r.metaInt > 0 && r.left == 0 {
+		if err := r.readMetadata(body); err != nil {
+			return 0, err
+		}
+		r.left = r.metaInt
+	}
+
+	if r.metaInt > 0 && len(b) > r.left {
+		b = b[:r.left]
+	}
+
+	n, err := body.Read(b)
+	r.left -= n
+
+	// the rest is read after reconnecting
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// readMetadata reads a metadata block, its first byte is the length of the
+// rest in 16 bytes.
+func (r *radioReader) readMetadata(body io.Reader) error {
+
+	size := make([]byte, 1)
+	if _, err := io.ReadFull(body, size); err != nil {
+		return err
+	}
+
+	block := make([]byte, int(size[0])*16)
+	if _, err := io.ReadFull(body, block); err != nil {
+		return err
+	}
+
+	title, ok := parseStreamTitle(string(bytes.TrimRight(block, "\x00")))
+	if ok && title != r.title {
+		r.title = title
+		if r.onTitle != nil {
+			r.onTitle(title)
+		}
+	}
+
+	return nil
+}
+
+// retry drops the connection and waits before the next attempt. It returns
+// the error once there have been too many attempts in a row.
+func (r *radioReader) retry(err error) error {
+
+	r.mu.Lock()
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.mu.Unlock()
+
+	r.attempts++
+	if r.attempts > radioRetries {
+		return tracerr.Wrap(err)
+	}
+
+	select {
+	case <-r.stop:
+		return errRadioClosed
+	case <-time.After(time.Duration(r.attempts) * radioRetryDelay):
+	}
+
+	return nil
+}
+
+// Close closes the connection, a blocked Read returns.
+func (r *radioReader) Close() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	close(r.stop)
+
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	return nil
+}
+
+// parseStreamTitle gets the title from metadata such as
+// StreamTitle='Artist - Title';
+func parseStreamTitle(metadata string) (string, bool) {
+
+	const key = "StreamTitle='"
+
+	i := strings.Index(metadata, key)
+	if i < 0 {
+		return "", false
+	}
+
+	title := metadata[i+len(key):]
+
+	// the title itself may contain quotes
+	if end := strings.Index(title, "';"); end >= 0 {
+		title = title[:end]
+	} else {
+		title = strings.TrimSuffix(title, "'")
+	}
+
+	return strings.TrimSpace(title), true
+}
+
+// decodeRadio picks a decoder based on the content of the stream, or on the
+// type sent by the station as a stream may start in the middle of a frame.
+func decodeRadio(r *radioReader) (beep.StreamSeekCloser, beep.Format, error) {
+
+	buffered := bufio.NewReader(r)
+
+	header, err := buffered.Peek(12)
+	if err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	format, err := DetectFormat(bytes.NewReader(header))
+	if err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	if format == FormatUnknown {
+		switch strings.ToLower(strings.TrimSpace(strings.Split(r.contentType, ";")[0])) {
+		case "audio/mpeg", "audio/mp3":
+			format = FormatMP3
+		case "audio/ogg", "application/ogg", "audio/vorbis":
+			format = FormatOgg
+		}
+	}
+
+	rc := struct {
+		io.Reader
+		io.Closer
+	}{buffered, r}
+
+	var (
+		stream     beep.StreamSeekCloser
+		fmtDecoded beep.Format
+	)
+
+	switch format {
+	case FormatMP3:
+		stream, fmtDecoded, err = mp3.Decode(rc)
+	case FormatOgg:
+		stream, fmtDecoded, err = vorbis.Decode(rc)
+	default:
+		return nil, beep.Format{}, tracerr.Wrap(ErrUnsupportedFormat)
+	}
+
+	if err != nil {
+		return nil, beep.Format{}, tracerr.Wrap(err)
+	}
+
+	return stream, fmtDecoded, nil
+}
+
+// liveStream decodes a stream ahead of the output in its own goroutine, so
+// the output never waits for the network. It streams silence while there is
+// not enough decoded. A live stream has no length and cannot be seeked.
+type liveStream struct {
+	source beep.StreamSeekCloser
+	// closed to unblock the source
+	closer io.Closer
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  [][2]float64
+	// the most samples buffered and the samples buffered before playing
+	size      int
+	prebuffer int
+	buffering bool
+	pos       int
+	// the source has ended
+	done   bool
+	err    error
+	closed bool
+
+	finished chan struct{}
+}
+
+func newLiveStream(source beep.StreamSeekCloser, format beep.Format, closer io.Closer) *liveStream {
+
+	s := &liveStream{
+		source:    source,
+		closer:    closer,
+		size:      format.SampleRate.N(liveBuffer),
+		prebuffer: format.SampleRate.N(livePrebuffer),
+		buffering: true,
+		finished:  make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	go s.fill()
+
+	return s
+}
+
+// fill decodes the source until it ends, waiting while the buffer is full.
+func (s *liveStream) fill() {
+
+	defer close(s.finished)
+
+	chunk := make([][2]float64, 512)
+
+	for {
+		n, ok := s.source.Stream(chunk)
+
+		s.mu.Lock()
+
+		s.buf = append(s.buf, chunk[:n]...)
+		s.cond.Broadcast()
+
+		if !ok {
+			s.done = true
+			s.err = s.source.Err()
+			s.mu.Unlock()
+			return
+		}
+
+		for len(s.buf) >= s.size && !s.closed {
+			s.cond.Wait()
+		}
+
+		closed := s.closed
+		s.mu.Unlock()
+
+		if closed {
+			return
+		}
+	}
+}
+
+func (s *liveStream) Stream(samples [][2]float64) (n int, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buffering && (len(s.buf) >= s.prebuffer || s.done) {
+		s.buffering = false
+	}
+
+	if !s.buffering {
+		n = copy(samples, s.buf)
+		s.buf = s.buf[n:]
+		s.pos += n
+		s.cond.Broadcast()
+	}
+
+	if n == len(samples) {
+		return n, true
+	}
+
+	if s.done {
+		return n, n > 0
+	}
+
+	// the network could not keep up
+	for i := range samples[n:] {
+		samples[n+i] = [2]float64{}
+	}
+	s.buffering = true
+
+	return len(samples), true
+}
+
+func (s *liveStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Len is zero as a live stream has no end.
+func (s *liveStream) Len() int {
+	return 0
+}
+
+// Position is the number of samples played since the stream started.
+func (s *liveStream) Position() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos
+}
+
+// Seek is ignored, a live stream cannot be seeked.
+func (s *liveStream) Seek(p int) error {
+	return nil
+}
+
+func (s *liveStream) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	// the source may be blocked reading
+	s.closer.Close()
+	<-s.finished
+
+	return tracerr.Wrap(s.source.Close())
+}
+
+// openStreamTrack connects to the stream of the song. The titles sent by the
+// station are passed to onTitle.
+func (p *Player) openStreamTrack(audio Audio, onTitle func(title string)) (*track, error) {
+
+	r, err := openRadio(audio.Path(), onTitle)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	source, format, err := decodeRadio(r)
+	if err != nil {
+		r.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	t := &track{
+		audio:  audio,
+		stream: newLiveStream(source, format, r),
+		format: format,
+		rate:   p.getStatus().rate,
+		scale:  1,
+		live:   true,
+	}
+	t.resample()
+
+	return t, nil
+}
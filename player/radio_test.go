@@ -0,0 +1,191 @@
+package player
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+func TestParseStreamTitle(t *testing.T) {
+
+	samples := []struct {
+		metadata string
+		title    string
+		ok       bool
+	}{
+		{"StreamTitle='Artist - Title';StreamUrl='';", "Artist - Title", true},
+		{"StreamTitle='Rock 'n' Roll';", "Rock 'n' Roll", true},
+		{"StreamTitle='';", "", true},
+		{"StreamUrl='http://example.com';", "", false},
+	}
+
+	for _, s := range samples {
+		title, ok := parseStreamTitle(s.metadata)
+		if title != s.title || ok != s.ok {
+			t.Errorf("Expected %q %v for %q; got %q %v", s.title, s.ok, s.metadata, title, ok)
+		}
+	}
+}
+
+func TestRadioReader(t *testing.T) {
+
+	// metadata block after every 4 bytes of audio
+	block := func(title string) string {
+		return string(icyMetadata(title))
+	}
+
+	responses := []string{
+		"abcd" + block("first") + "efgh" + block("first") + "ij",
+		// reconnected
+		"klmn" + block("second") + "op",
+	}
+
+	var mu sync.Mutex
+	connections := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Error("Expected the metadata to be asked for")
+		}
+
+		mu.Lock()
+		i := connections
+		connections++
+		mu.Unlock()
+
+		if i >= len(responses) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-metaint", "4")
+		w.Write([]byte(responses[i]))
+	}))
+	defer server.Close()
+
+	var titles []string
+
+	r, err := openRadio(server.URL, func(title string) {
+		titles = append(titles, title)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var audio bytes.Buffer
+	buf := make([]byte, 3)
+
+	for audio.Len() < len("abcdefghijklmnop") {
+		n, err := r.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		audio.Write(buf[:n])
+	}
+
+	if got := audio.String(); got != "abcdefghijklmnop" {
+		t.Errorf("Expected the audio without the metadata; got %q", got)
+	}
+
+	if strings.Join(titles, ",") != "first,second" {
+		t.Errorf("Expected the titles to be reported once; got %v", titles)
+	}
+}
+
+func TestRadioReaderClose(t *testing.T) {
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abcd"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	r, err := openRadio(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Read(buf)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the read to fail once closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the blocked read to return")
+	}
+}
+
+func TestLiveStream(t *testing.T) {
+
+	// 200 samples are buffered before playing
+	format := beep.Format{SampleRate: 100, NumChannels: 2, Precision: 2}
+	source := &testStream{value: 1, len: 300}
+
+	s := newLiveStream(source, format, ioutil.NopCloser(nil))
+
+	samples := make([][2]float64, 100)
+	played := 0
+
+	for i := 0; i < 100; i++ {
+
+		n, ok := s.Stream(samples)
+		if !ok {
+			break
+		}
+
+		for _, sample := range samples[:n] {
+			if sample[0] == 1 {
+				played++
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if played != 300 {
+		t.Errorf("Expected every sample to be played; got %d", played)
+	}
+
+	if s.Position() != 300 || s.Len() != 0 {
+		t.Errorf("Expected position 300 and no length; got %d %d", s.Position(), s.Len())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !source.closed {
+		t.Error("Expected the source to be closed")
+	}
+}
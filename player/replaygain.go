@@ -0,0 +1,115 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ReplayGainMode selects which replaygain is applied to the songs.
+type ReplayGainMode int
+
+const (
+	ReplayGainOff ReplayGainMode = iota
+	ReplayGainTrack
+	ReplayGainAlbum
+)
+
+// replaygain tag names, the same names are used for TXXX frames, vorbis
+// comments and itunes freeform items
+const (
+	rgTrackGain = "REPLAYGAIN_TRACK_GAIN"
+	rgTrackPeak = "REPLAYGAIN_TRACK_PEAK"
+	rgAlbumGain = "REPLAYGAIN_ALBUM_GAIN"
+	rgAlbumPeak = "REPLAYGAIN_ALBUM_PEAK"
+)
+
+// ParseReplayGainMode parses "off", "track" or "album".
+func ParseReplayGainMode(mode string) (ReplayGainMode, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "off":
+		return ReplayGainOff, nil
+	case "track":
+		return ReplayGainTrack, nil
+	case "album":
+		return ReplayGainAlbum, nil
+	}
+	return ReplayGainOff, fmt.Errorf("invalid replaygain mode %q", mode)
+}
+
+// ReplayGain holds the gains in dB and the linear sample peaks of a song.
+type ReplayGain struct {
+	TrackGain float64
+	TrackPeak float64
+	AlbumGain float64
+	AlbumPeak float64
+	HasTrack  bool
+	HasAlbum  bool
+}
+
+// ReadReplayGain reads the replaygain tags. Gains written as "-6.20 dB" are
+// accepted.
+func ReadReplayGain(tag Tag) ReplayGain {
+
+	var rg ReplayGain
+	var err error
+
+	rg.TrackGain, err = parseGain(tag.UserText(rgTrackGain))
+	rg.HasTrack = err == nil
+	rg.TrackPeak, _ = parseGain(tag.UserText(rgTrackPeak))
+
+	rg.AlbumGain, err = parseGain(tag.UserText(rgAlbumGain))
+	rg.HasAlbum = err == nil
+	rg.AlbumPeak, _ = parseGain(tag.UserText(rgAlbumPeak))
+
+	return rg
+}
+
+// WriteReplayGain stores the gains in replaygain 2.0 format. Album gain is
+// only written if it is present.
+func WriteReplayGain(tag Tag, rg ReplayGain) {
+
+	if rg.HasTrack {
+		tag.SetUserText(rgTrackGain, fmt.Sprintf("%.2f dB", rg.TrackGain))
+		tag.SetUserText(rgTrackPeak, fmt.Sprintf("%.6f", rg.TrackPeak))
+	}
+
+	if rg.HasAlbum {
+		tag.SetUserText(rgAlbumGain, fmt.Sprintf("%.2f dB", rg.AlbumGain))
+		tag.SetUserText(rgAlbumPeak, fmt.Sprintf("%.6f", rg.AlbumPeak))
+	}
+}
+
+func parseGain(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && strings.EqualFold(s[len(s)-2:], "db") {
+		s = strings.TrimSpace(s[:len(s)-2])
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Scale returns the linear factor applied to the samples. Album mode falls
+// back to the track gain and vice versa. The preamp in dB is added to the
+// gain, preventClipping lowers the factor so the peak stays below full scale.
+func (rg ReplayGain) Scale(mode ReplayGainMode, preamp float64, preventClipping bool) float64 {
+
+	if mode == ReplayGainOff || (!rg.HasTrack && !rg.HasAlbum) {
+		return 1
+	}
+
+	gain, peak := rg.TrackGain, rg.TrackPeak
+	if (mode == ReplayGainAlbum && rg.HasAlbum) || !rg.HasTrack {
+		gain, peak = rg.AlbumGain, rg.AlbumPeak
+	}
+
+	scale := math.Pow(10, (gain+preamp)/20)
+
+	if preventClipping && peak > 0 && scale*peak > 1 {
+		scale = 1 / peak
+	}
+
+	return scale
+}
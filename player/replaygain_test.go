@@ -0,0 +1,117 @@
+package player
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+func TestParseGain(t *testing.T) {
+
+	tests := map[string]float64{
+		"-6.20 dB": -6.2,
+		"+3.5 db":  3.5,
+		"0.988":    0.988,
+		" -1 dB ":  -1,
+	}
+
+	for in, expected := range tests {
+		got, err := parseGain(in)
+		if err != nil {
+			t.Errorf("%q; unexpected error %v", in, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("%q; expected %v got %v", in, expected, got)
+		}
+	}
+
+	if _, err := parseGain(""); err == nil {
+		t.Error("expected error for empty gain")
+	}
+}
+
+func TestParseReplayGainMode(t *testing.T) {
+
+	tests := map[string]ReplayGainMode{
+		"":      ReplayGainOff,
+		"off":   ReplayGainOff,
+		"Track": ReplayGainTrack,
+		"album": ReplayGainAlbum,
+	}
+
+	for in, expected := range tests {
+		got, err := ParseReplayGainMode(in)
+		if err != nil || got != expected {
+			t.Errorf("%q; expected %v got %v %v", in, expected, got, err)
+		}
+	}
+
+	if _, err := ParseReplayGainMode("loud"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestReplayGainScale(t *testing.T) {
+
+	rg := ReplayGain{
+		TrackGain: -6, TrackPeak: 0.5,
+		AlbumGain: 6, AlbumPeak: 0.9,
+		HasTrack: true, HasAlbum: true,
+	}
+
+	db := func(gain float64) float64 { return math.Pow(10, gain/20) }
+
+	tests := []struct {
+		name     string
+		rg       ReplayGain
+		mode     ReplayGainMode
+		preamp   float64
+		clipping bool
+		expected float64
+	}{
+		{"off", rg, ReplayGainOff, 0, false, 1},
+		{"track", rg, ReplayGainTrack, 0, false, db(-6)},
+		{"album", rg, ReplayGainAlbum, 0, false, db(6)},
+		{"preamp", rg, ReplayGainTrack, 3, false, db(-3)},
+		{"clipping", rg, ReplayGainAlbum, 0, true, 1 / 0.9},
+		{"no tags", ReplayGain{}, ReplayGainTrack, 0, false, 1},
+		{"album fallback", ReplayGain{TrackGain: -3, HasTrack: true}, ReplayGainAlbum, 0, false, db(-3)},
+		{"track fallback", ReplayGain{AlbumGain: -3, HasAlbum: true}, ReplayGainTrack, 0, false, db(-3)},
+	}
+
+	for _, test := range tests {
+		got := test.rg.Scale(test.mode, test.preamp, test.clipping)
+		if math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("%s; expected %v got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestIntegratedLoudness(t *testing.T) {
+
+	// a full scale 1kHz sine is -3 LUFS on one channel and 0 LUFS on both
+	m := newLoudnessMeter(beep.Format{SampleRate: defaultSampleRate, NumChannels: 2})
+
+	samples := make([][2]float64, defaultSampleRate.N(3*time.Second))
+	for i := range samples {
+		v := math.Sin(2 * math.Pi * 1000 * float64(i) / float64(defaultSampleRate))
+		samples[i] = [2]float64{v, v}
+	}
+	m.add(samples)
+
+	loudness := integratedLoudness(m.blocks)
+	if math.Abs(loudness) > 0.1 {
+		t.Errorf("expected about 0 LUFS got %v", loudness)
+	}
+
+	if math.Abs(m.peak-1) > 1e-3 {
+		t.Errorf("expected peak of 1 got %v", m.peak)
+	}
+
+	if gain := gainOf(math.Inf(-1)); gain != 0 {
+		t.Errorf("expected no gain for silence got %v", gain)
+	}
+}
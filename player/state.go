@@ -0,0 +1,271 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+)
+
+// State is the state of the player.
+type State int
+
+const (
+	// Stopped is when no song is loaded, before the first song or after the
+	// last one has finished.
+	Stopped State = iota
+	// Loading is while the song passed to Run is decoded.
+	Loading
+	Playing
+	Paused
+)
+
+func (s State) String() string {
+	switch s {
+	case Loading:
+		return "loading"
+	case Playing:
+		return "playing"
+	case Paused:
+		return "paused"
+	}
+	return "stopped"
+}
+
+// Event is passed to the handlers added with OnEvent. It is one of
+// StateChanged, SongStarted, SongSkipped, SongFinished, StreamTitleChanged,
+// Seeked and VolumeChanged.
+type Event interface {
+	event()
+}
+
+// StateChanged is sent when the player goes from one state to another.
+type StateChanged struct {
+	From, To State
+}
+
+// SongStarted is sent when a song is passed to Run, before it is decoded.
+type SongStarted struct {
+	Song Audio
+}
+
+// SongSkipped is sent when the song is skipped at the position, it is
+// followed by SongFinished.
+type SongSkipped struct {
+	Song     Audio
+	Position time.Duration
+}
+
+// SongFinished is sent when the song has ended or has been skipped.
+type SongFinished struct {
+	Song Audio
+}
+
+// StreamTitleChanged is sent when the station of the stream being played
+// sends the title of the song it plays.
+type StreamTitleChanged struct {
+	Song  Audio
+	Title string
+}
+
+// Seeked is sent when the position of the song has jumped.
+type Seeked struct {
+	Song     Audio
+	Position time.Duration
+}
+
+// VolumeChanged is sent when the volume has been changed.
+type VolumeChanged struct {
+	Volume float64
+}
+
+func (StateChanged) event()       {}
+func (SongStarted) event()        {}
+func (SongSkipped) event()        {}
+func (SongFinished) event()       {}
+func (StreamTitleChanged) event() {}
+func (Seeked) event()             {}
+func (VolumeChanged) event()      {}
+
+// status is the part of the player read by any goroutine. It is only changed
+// by the event loop and replaced as a whole.
+type status struct {
+	state   State
+	song    Audio
+	volume  float64
+	hasInit bool
+	// rate is the sample rate of the output
+	rate beep.SampleRate
+	// streamTitle is the title last sent by the station of the song
+	streamTitle string
+}
+
+// mailbox is an unbounded queue, posting never blocks so the output
+// goroutine can post while streaming.
+type mailbox struct {
+	mu    sync.Mutex
+	items []interface{}
+	// signal holds a value whenever there are items to be taken
+	signal chan struct{}
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{signal: make(chan struct{}, 1)}
+}
+
+func (m *mailbox) post(item interface{}) {
+
+	m.mu.Lock()
+	m.items = append(m.items, item)
+	m.mu.Unlock()
+
+	select {
+	case m.signal <- struct{}{}:
+	default:
+	}
+}
+
+// take returns every item posted so far in order.
+func (m *mailbox) take() []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := m.items
+	m.items = nil
+	return items
+}
+
+// loop runs the commands and the notifications from the output one at a
+// time. It is the only goroutine changing the state of the player and it
+// never waits for the event handlers.
+func (p *Player) loop() {
+	for {
+		select {
+		case cmd := <-p.commands:
+			cmd()
+		case <-p.notes.signal:
+			for _, note := range p.notes.take() {
+				note.(func())()
+			}
+		}
+	}
+}
+
+// do runs the command on the event loop and waits until it is done. It must
+// not be called from the event loop.
+func (p *Player) do(cmd func()) {
+	done := make(chan struct{})
+	p.commands <- func() {
+		defer close(done)
+		cmd()
+	}
+	<-done
+}
+
+// notify runs the function on the event loop without waiting, it is used by
+// the output goroutine.
+func (p *Player) notify(note func()) {
+	p.notes.post(note)
+}
+
+// dispatch passes the events to the callbacks and the handlers in order.
+func (p *Player) dispatch() {
+	for range p.events.signal {
+		for _, e := range p.events.take() {
+			p.deliver(e.(Event))
+		}
+	}
+}
+
+func (p *Player) deliver(e Event) {
+
+	p.handlersMu.Lock()
+	songStart, songSkip, songFinish := p.songStart, p.songSkip, p.songFinish
+	handlers := p.handlers
+	p.handlersMu.Unlock()
+
+	switch e := e.(type) {
+	case SongStarted:
+		if songStart != nil {
+			songStart(e.Song)
+		}
+	case SongSkipped:
+		if songSkip != nil {
+			songSkip(e.Song, e.Position)
+		}
+	case SongFinished:
+		if songFinish != nil {
+			songFinish(e.Song)
+		}
+	}
+
+	for _, handler := range handlers {
+		handler(e)
+	}
+}
+
+// emit queues the event for the handlers, it is called by the event loop.
+func (p *Player) emit(e Event) {
+	p.events.post(e)
+}
+
+// setState changes the state and emits StateChanged, it is called by the
+// event loop.
+func (p *Player) setState(state State) {
+
+	p.statusMu.Lock()
+	from := p.status.state
+	p.status.state = state
+	p.statusMu.Unlock()
+
+	if from != state {
+		p.emit(StateChanged{From: from, To: state})
+	}
+}
+
+// setSong changes the song reported by the player, it is called by the event
+// loop.
+func (p *Player) setSong(song Audio) {
+	p.statusMu.Lock()
+	p.status.song = song
+	p.status.streamTitle = ""
+	p.statusMu.Unlock()
+}
+
+// setStreamTitle changes the title of the stream being played and emits
+// StreamTitleChanged, it is called by the event loop.
+func (p *Player) setStreamTitle(song Audio, title string) {
+
+	p.statusMu.Lock()
+	p.status.streamTitle = title
+	p.statusMu.Unlock()
+
+	p.emit(StreamTitleChanged{Song: song, Title: title})
+}
+
+func (p *Player) getStatus() status {
+	p.statusMu.RLock()
+	defer p.statusMu.RUnlock()
+	return p.status
+}
+
+// OnEvent adds a handler which is called with every event. The handlers and
+// the song callbacks are called in the order of the events from a single
+// goroutine, so they may call the player.
+func (p *Player) OnEvent(handler func(Event)) {
+	p.handlersMu.Lock()
+	p.handlers = append(p.handlers, handler)
+	p.handlersMu.Unlock()
+}
+
+// State returns the state of the player.
+func (p *Player) State() State {
+	return p.getStatus().state
+}
+
+// StreamTitle returns the title last sent by the station of the stream being
+// played, it is empty for local files.
+func (p *Player) StreamTitle() string {
+	return p.getStatus().streamTitle
+}
@@ -0,0 +1,349 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/ztrue/tracerr"
+)
+
+// icyMetaInt is the number of bytes of audio between the metadata blocks
+// sent to the listeners asking for them.
+const icyMetaInt = 16000
+
+// streamChunk is the size of the chunks read from the encoder.
+const streamChunk = 4096
+
+// streamBacklog is the number of chunks a listener may fall behind before it
+// is disconnected.
+const streamBacklog = 64
+
+// titleInterval is how often the title of the current song is read.
+const titleInterval = time.Second
+
+// StreamConfig configures the stream output.
+type StreamConfig struct {
+	// Addr is the address the server listens on, e.g. ":8000".
+	Addr string
+	// Format is "mp3" or "ogg".
+	Format string
+	// Name is announced to the listeners.
+	Name string
+	// Title returns the title of the current song sent as ICY metadata.
+	Title func() string
+	// Encoder is the command reading 16 bit little endian stereo PCM from
+	// stdin and writing the encoded stream to stdout. It is ffmpeg encoding
+	// to Format when empty.
+	Encoder []string
+}
+
+// streamOutput serves the encoded songs over HTTP like an Icecast server.
+type streamOutput struct {
+	// pcm writes the samples to the encoder in real time
+	pcm    sink
+	config StreamConfig
+
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	listener net.Listener
+	server   *http.Server
+
+	mu        sync.Mutex
+	title     string
+	listeners map[chan []byte]struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewStreamOutput returns an output serving the songs as an Icecast
+// compatible stream, e.g. to be listened to with a browser or mpv.
+func NewStreamOutput(config StreamConfig) (Output, error) {
+
+	if config.Format != "mp3" && config.Format != "ogg" {
+		return nil, tracerr.Errorf("unknown stream format %q", config.Format)
+	}
+
+	if len(config.Encoder) == 0 {
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return nil, tracerr.Wrap(err)
+		}
+	}
+
+	return &streamOutput{
+		config:    config,
+		listeners: make(map[chan []byte]struct{}),
+	}, nil
+}
+
+// encoder returns the command line encoding the samples at the rate.
+func (s *streamOutput) encoder(rate beep.SampleRate) []string {
+
+	if len(s.config.Encoder) > 0 {
+		return s.config.Encoder
+	}
+
+	args := []string{
+		"ffmpeg", "-loglevel", "error",
+		"-f", "s16le", "-ar", strconv.Itoa(int(rate)), "-ac", "2", "-i", "-",
+	}
+
+	if s.config.Format == "ogg" {
+		return append(args, "-c:a", "libvorbis", "-q:a", "5", "-f", "ogg", "-")
+	}
+
+	return append(args, "-c:a", "libmp3lame", "-b:a", "192k", "-f", "mp3", "-")
+}
+
+func (s *streamOutput) Init(rate beep.SampleRate, bufferSize int) error {
+
+	args := s.encoder(rate)
+	cmd := exec.Command(args[0], args[1:]...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		listener.Close()
+		return tracerr.Wrap(err)
+	}
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.listener = listener
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serve)}
+	s.stop = make(chan struct{})
+	s.pcm.w = stdin
+
+	s.wg.Add(2)
+	go s.broadcast(stdout)
+	go s.pollTitle()
+	go s.server.Serve(listener)
+
+	return tracerr.Wrap(s.pcm.Init(rate, bufferSize))
+}
+
+func (s *streamOutput) Play(streamer beep.Streamer) {
+	s.pcm.Play(streamer)
+}
+
+// broadcast passes the encoded stream to every listener, the listeners which
+// cannot keep up are disconnected.
+func (s *streamOutput) broadcast(r io.Reader) {
+
+	defer s.wg.Done()
+
+	for {
+		buf := make([]byte, streamChunk)
+		n, err := r.Read(buf)
+
+		s.mu.Lock()
+		for l := range s.listeners {
+			if n == 0 {
+				continue
+			}
+			select {
+			case l <- buf[:n]:
+			default:
+				delete(s.listeners, l)
+				close(l)
+			}
+		}
+		if err != nil {
+			for l := range s.listeners {
+				delete(s.listeners, l)
+				close(l)
+			}
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pollTitle reads the title of the current song so the listeners do not
+// each ask for it.
+func (s *streamOutput) pollTitle() {
+
+	defer s.wg.Done()
+
+	if s.config.Title == nil {
+		return
+	}
+
+	ticker := time.NewTicker(titleInterval)
+	defer ticker.Stop()
+
+	for {
+		title := s.config.Title()
+
+		s.mu.Lock()
+		s.title = title
+		s.mu.Unlock()
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *streamOutput) getTitle() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.title
+}
+
+// serve streams to a listener from the moment it connects.
+func (s *streamOutput) serve(w http.ResponseWriter, r *http.Request) {
+
+	chunks := make(chan []byte, streamBacklog)
+
+	s.mu.Lock()
+	s.listeners[chunks] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.listeners[chunks]; ok {
+			delete(s.listeners, chunks)
+			close(chunks)
+		}
+		s.mu.Unlock()
+	}()
+
+	contentType := "audio/mpeg"
+	if s.config.Format == "ogg" {
+		contentType = "audio/ogg"
+	}
+
+	metadata := r.Header.Get("Icy-MetaData") == "1"
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("icy-name", s.config.Name)
+	if metadata {
+		header.Set("icy-metaint", strconv.Itoa(icyMetaInt))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+
+	// bytes of audio left until the next metadata block
+	left := icyMetaInt
+	sentTitle := ""
+	first := true
+
+	for {
+		var chunk []byte
+		var ok bool
+
+		select {
+		case chunk, ok = <-chunks:
+		case <-r.Context().Done():
+			return
+		}
+		if !ok {
+			return
+		}
+
+		for metadata && len(chunk) >= left {
+			if _, err := w.Write(chunk[:left]); err != nil {
+				return
+			}
+			chunk = chunk[left:]
+			left = icyMetaInt
+
+			// the title is only sent again when it changes
+			title := s.getTitle()
+			block := []byte{0}
+			if first || title != sentTitle {
+				block = icyMetadata(title)
+				sentTitle = title
+				first = false
+			}
+			if _, err := w.Write(block); err != nil {
+				return
+			}
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+		left -= len(chunk)
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// icyMetadata returns the metadata block with the title, its first byte is
+// the length of the rest in 16 bytes.
+func icyMetadata(title string) []byte {
+
+	// quotes would end the title early
+	title = strings.ReplaceAll(title, "'", "’")
+	text := fmt.Sprintf("StreamTitle='%s';", title)
+
+	// the length has to fit in a byte
+	if len(text) > 255*16 {
+		text = text[:255*16-2] + "';"
+	}
+	blocks := (len(text) + 15) / 16
+
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], text)
+
+	return block
+}
+
+func (s *streamOutput) Close() error {
+
+	if s.cmd == nil {
+		return nil
+	}
+
+	err := s.pcm.Close()
+
+	// the encoder ends the stream once it has encoded what is left
+	close(s.stop)
+	s.stdin.Close()
+	s.wg.Wait()
+	s.cmd.Wait()
+	s.server.Close()
+
+	s.cmd = nil
+
+	return tracerr.Wrap(err)
+}
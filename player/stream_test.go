@@ -0,0 +1,110 @@
+package player
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIcyMetadata(t *testing.T) {
+
+	block := icyMetadata("Tom's song")
+
+	if int(block[0])*16 != len(block)-1 {
+		t.Fatalf("Expected the length in 16 bytes; got %d for %d bytes", block[0], len(block)-1)
+	}
+
+	expected := "StreamTitle='Tom’s song';"
+	if got := string(block[1 : 1+len(expected)]); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+
+	for _, b := range block[1+len(expected):] {
+		if b != 0 {
+			t.Fatalf("Expected zero padding; got %q", block)
+		}
+	}
+}
+
+func TestStreamOutput(t *testing.T) {
+
+	// the samples are passed through as they are
+	output, err := NewStreamOutput(StreamConfig{
+		Addr:    "127.0.0.1:0",
+		Format:  "mp3",
+		Name:    "gomu",
+		Title:   func() string { return "a" },
+		Encoder: []string{"cat"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := output.Init(8000, 80); err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	s := output.(*streamOutput)
+
+	req, err := http.NewRequest("GET", "http://"+s.listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Icy-MetaData", "1")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "audio/mpeg" || resp.Header.Get("icy-name") != "gomu" {
+		t.Errorf("Expected icecast headers; got %v", resp.Header)
+	}
+
+	metaInt, err := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	if err != nil || metaInt != icyMetaInt {
+		t.Fatalf("Expected icy-metaint %d; got %q", icyMetaInt, resp.Header.Get("icy-metaint"))
+	}
+
+	output.Play(&testStream{value: 0.5, len: 8000 * 10})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		r := bufio.NewReader(resp.Body)
+
+		if _, err := io.CopyN(ioutil.Discard, r, int64(metaInt)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		length, err := r.ReadByte()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		block := make([]byte, int(length)*16)
+		if _, err := io.ReadFull(r, block); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if expected := "StreamTitle='a';"; string(block[:len(expected)]) != expected {
+			t.Errorf("Expected %s; got %q", expected, block)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream with metadata")
+	}
+}
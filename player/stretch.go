@@ -0,0 +1,227 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"math"
+
+	"github.com/faiface/beep"
+)
+
+const (
+	// MinSpeed and MaxSpeed are the limits of the playback speed.
+	MinSpeed = 0.5
+	MaxSpeed = 2.0
+)
+
+const (
+	// stretchFrame is the length of the frames which are overlapped, about
+	// 40ms at the default sample rate
+	stretchFrame = 2048
+	// stretchHop is the distance between the output frames, frames overlap
+	// by half
+	stretchHop = stretchFrame / 2
+	// stretchTolerance is how far from its position a frame is moved to
+	// match the previous frame
+	stretchTolerance = 512
+	// stretchStride is the step between the samples compared when matching
+	// the frames, comparing every sample is not worth the cost
+	stretchStride = 4
+)
+
+// stretchWindow is the periodic hann window, windows half a frame apart sum
+// to one.
+var stretchWindow = func() []float64 {
+	w := make([]float64, stretchFrame)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchFrame)
+	}
+	return w
+}()
+
+// timeStretch changes the speed of the streamer without changing its pitch
+// using waveform similarity overlap-add (WSOLA). Every output frame is read
+// around speed times the hop further in the input and moved within
+// stretchTolerance to where it best continues the previous frame.
+type timeStretch struct {
+	streamer beep.Streamer
+	speed    float64
+
+	// input samples starting at the absolute input position start
+	in    [][2]float64
+	start int
+	ended bool
+
+	// pos is the nominal input position of the next frame and prev the
+	// position the previous frame was read at, -1 before the first frame
+	pos  float64
+	prev int
+
+	// second half of the previous windowed frame
+	tail [stretchHop][2]float64
+	// output samples not streamed yet, in buf
+	out [][2]float64
+	buf [stretchHop][2]float64
+}
+
+func newTimeStretch(streamer beep.Streamer, speed float64) *timeStretch {
+	t := &timeStretch{streamer: streamer, speed: speed}
+	t.reset()
+	return t
+}
+
+// setSpeed changes the speed, the audio is passed as is at speed 1.
+func (t *timeStretch) setSpeed(speed float64) {
+	if (speed == 1) != (t.speed == 1) {
+		t.reset()
+	}
+	t.speed = speed
+}
+
+// reset drops the buffered samples, e.g. after seeking.
+func (t *timeStretch) reset() {
+	t.in = t.in[:0]
+	t.out = nil
+	t.start = 0
+	t.ended = false
+	t.pos = 0
+	t.prev = -1
+	t.tail = [stretchHop][2]float64{}
+}
+
+func (t *timeStretch) Stream(samples [][2]float64) (n int, ok bool) {
+
+	if t.speed == 1 && len(t.out) == 0 {
+		return t.streamer.Stream(samples)
+	}
+
+	for n < len(samples) {
+
+		if len(t.out) == 0 && !t.frame() {
+			break
+		}
+
+		copied := copy(samples[n:], t.out)
+		t.out = t.out[copied:]
+		n += copied
+	}
+
+	return n, n > 0
+}
+
+func (t *timeStretch) Err() error {
+	return t.streamer.Err()
+}
+
+// fill reads the input until it holds the absolute position end, the input
+// after the end of the streamer is silence.
+func (t *timeStretch) fill(end int) {
+
+	var buf [512][2]float64
+
+	for !t.ended && t.start+len(t.in) < end {
+		n, ok := t.streamer.Stream(buf[:])
+		t.in = append(t.in, buf[:n]...)
+		if !ok {
+			t.ended = true
+		}
+	}
+}
+
+// at gets the input sample at the absolute position.
+func (t *timeStretch) at(pos int) [2]float64 {
+	i := pos - t.start
+	if i < 0 || i >= len(t.in) {
+		return [2]float64{}
+	}
+	return t.in[i]
+}
+
+// match finds the position within the tolerance of nominal where the frame
+// best continues the previous frame, the position its next frame would have
+// been read at is what the new frame is compared to.
+func (t *timeStretch) match(nominal int) int {
+
+	if t.prev < 0 {
+		return nominal
+	}
+
+	natural := t.prev + stretchHop
+
+	low := nominal - stretchTolerance
+	if low < t.start {
+		low = t.start
+	}
+
+	best, bestScore := nominal, math.Inf(-1)
+
+	for candidate := low; candidate <= nominal+stretchTolerance; candidate++ {
+
+		var score float64
+		for i := 0; i < stretchHop; i += stretchStride {
+			a, b := t.at(candidate+i), t.at(natural+i)
+			score += (a[0] + a[1]) * (b[0] + b[1])
+		}
+
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+
+	return best
+}
+
+// frame overlaps the next frame with the previous one and appends a hop of
+// output, it returns false once the input has been played.
+func (t *timeStretch) frame() bool {
+
+	nominal := int(math.Round(t.pos))
+
+	t.fill(nominal + stretchTolerance + stretchFrame)
+
+	inputEnd := t.start + len(t.in)
+	if t.ended && nominal >= inputEnd {
+		// the last frame fades out in the tail
+		if t.prev >= 0 {
+			t.buf = t.tail
+			t.out = t.buf[:]
+			t.prev = -1
+			t.tail = [stretchHop][2]float64{}
+			return true
+		}
+		return false
+	}
+
+	chosen := t.match(nominal)
+
+	for i := 0; i < stretchFrame; i++ {
+
+		s := t.at(chosen + i)
+		w := stretchWindow[i]
+
+		if i < stretchHop {
+			t.buf[i] = [2]float64{
+				t.tail[i][0] + s[0]*w,
+				t.tail[i][1] + s[1]*w,
+			}
+		} else {
+			t.tail[i-stretchHop] = [2]float64{s[0] * w, s[1] * w}
+		}
+	}
+
+	t.out = t.buf[:]
+	t.prev = chosen
+	t.pos += t.speed * stretchHop
+
+	// drop the input no frame is read from anymore
+	keep := int(t.pos) - stretchTolerance
+	if natural := t.prev + stretchHop; natural < keep {
+		keep = natural
+	}
+	if drop := keep - t.start; drop > 0 && drop <= len(t.in) {
+		t.in = append(t.in[:0], t.in[drop:]...)
+		t.start = keep
+	}
+
+	return true
+}
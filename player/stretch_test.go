@@ -0,0 +1,86 @@
+package player
+
+import (
+	"math"
+	"testing"
+)
+
+// sliceStream streams the samples once.
+type sliceStream struct {
+	samples [][2]float64
+}
+
+func (s *sliceStream) Stream(samples [][2]float64) (n int, ok bool) {
+	n = copy(samples, s.samples)
+	s.samples = s.samples[n:]
+	return n, n > 0
+}
+
+func (s *sliceStream) Err() error { return nil }
+
+// streamAll reads the streamer until it ends.
+func streamAll(t *timeStretch) [][2]float64 {
+	var all [][2]float64
+	buf := make([][2]float64, 1000)
+	for {
+		n, ok := t.Stream(buf)
+		all = append(all, buf[:n]...)
+		if !ok {
+			return all
+		}
+	}
+}
+
+// frequency estimates the frequency of the left channel from its zero
+// crossings.
+func frequency(samples [][2]float64) float64 {
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1][0] < 0) != (samples[i][0] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / defaultSampleRate.D(len(samples)).Seconds()
+}
+
+func TestTimeStretch(t *testing.T) {
+
+	const n = 48000
+
+	for _, speed := range []float64{0.5, 0.8, 1.5, 2} {
+
+		out := streamAll(newTimeStretch(&sliceStream{sine(440, n)}, speed))
+
+		expected := float64(n) / speed
+		if math.Abs(float64(len(out))-expected) > stretchFrame {
+			t.Errorf("Expected about %.0f samples at speed %v; got %d", expected, speed, len(out))
+		}
+
+		// the pitch is the same
+		middle := out[len(out)/4 : len(out)*3/4]
+		if got := frequency(middle); math.Abs(got-440) > 10 {
+			t.Errorf("Expected 440 Hz at speed %v; got %.1f Hz", speed, got)
+		}
+
+		// the frames are matched so the amplitude does not drop between them
+		if got := peak(middle); got < 0.9 || got > 1.1 {
+			t.Errorf("Expected amplitude of 1 at speed %v; got %.2f", speed, got)
+		}
+	}
+}
+
+func TestTimeStretchSpeedOne(t *testing.T) {
+
+	in := sine(440, 4800)
+	out := streamAll(newTimeStretch(&sliceStream{sine(440, 4800)}, 1))
+
+	if len(out) != len(in) {
+		t.Fatalf("Expected %d samples; got %d", len(in), len(out))
+	}
+
+	for i := range in {
+		if in[i] != out[i] {
+			t.Fatalf("Expected samples to be passed as is; sample %d is %v, expected %v", i, out[i], in[i])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+// ErrTagNotSupported is returned when the container has no tag format gomu
+// knows how to read.
+var ErrTagNotSupported = errors.New("tag is not supported for this format")
+
+// Picture is an image embedded in the audio file, usually the album cover.
+type Picture struct {
+	MimeType    string
+	Description string
+	Data        []byte
+}
+
+// Tag is a format agnostic view over the metadata of an audio file. Setters
+// only modify the in-memory copy, call Save to write the changes to disk.
+type Tag interface {
+	Title() string
+	SetTitle(title string)
+	Artist() string
+	SetArtist(artist string)
+	Album() string
+	SetAlbum(album string)
+	TrackNumber() int
+	SetTrackNumber(track int)
+	Genre() string
+	SetGenre(genre string)
+	// Year returns the year of the release date or 0 if unknown.
+	Year() int
+	SetYear(year int)
+	// Rating returns the rating from 1 to 5 stars or 0 if unrated.
+	Rating() int
+	// SetRating stores the rating from 1 to 5 stars, 0 removes it.
+	SetRating(stars int)
+
+	// Lyrics returns embedded lrc lyrics keyed by their language extension.
+	Lyrics() map[string]string
+	SetLyric(langExt, lrc string) error
+	DeleteLyric(langExt string)
+
+	Pictures() []Picture
+	// SetPictures replaces all the embedded pictures.
+	SetPictures(pics []Picture)
+
+	// Length returns the duration stored in the tag or 0 if unknown.
+	Length() time.Duration
+	// SetLength stores the duration for formats which do not have it in
+	// their headers, it is a no-op otherwise.
+	SetLength(length time.Duration)
+
+	// UserText returns a custom text field such as REPLAYGAIN_TRACK_GAIN,
+	// stored as TXXX in id3v2, vorbis comment or itunes freeform item.
+	UserText(name string) string
+	// SetUserText replaces the custom text field, an empty value removes it.
+	SetUserText(name, value string)
+
+	Save() error
+	Close() error
+}
+
+// OpenTag parses the tag of the audio file according to its format.
+func OpenTag(audioPath string) (Tag, error) {
+
+	format, err := DetectFileFormat(audioPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	switch format {
+	case FormatMP3:
+		return openID3Tag(audioPath)
+	case FormatFLAC:
+		return openFLACTag(audioPath)
+	case FormatOgg:
+		return openOggTag(audioPath)
+	case FormatMP4:
+		return openMP4Tag(audioPath)
+	}
+
+	return nil, tracerr.Wrap(ErrTagNotSupported)
+}
+
+// ratingField is the custom text field storing the rating as a percentage in
+// vorbis comments and itunes freeform items.
+const ratingField = "RATING"
+
+// parsePercentRating converts a rating from 0 to 100 to stars.
+func parsePercentRating(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return clampRating((n + 10) / 20)
+}
+
+// percentRating converts stars to a rating from 0 to 100, 0 stars is empty.
+func percentRating(stars int) string {
+	stars = clampRating(stars)
+	if stars == 0 {
+		return ""
+	}
+	return strconv.Itoa(stars * 20)
+}
+
+func clampRating(stars int) int {
+	if stars < 0 {
+		return 0
+	}
+	if stars > 5 {
+		return 5
+	}
+	return stars
+}
+
+// replaceFile atomically replaces the file at audioPath with content written
+// by write. The temporary file is created in the same directory so the
+// rename does not cross filesystems.
+func replaceFile(audioPath string, write func(f *os.File) error) error {
+
+	info, err := os.Stat(audioPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(audioPath), ".gomu-tag-*")
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.Rename(tmp.Name(), audioPath); err != nil {
+		os.Remove(tmp.Name())
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
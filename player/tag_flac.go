@@ -0,0 +1,207 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+// flac metadata block types
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockPadding       = 1
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+)
+
+var errInvalidFLAC = errors.New("invalid flac metadata")
+
+type flacBlock struct {
+	blockType byte
+	data      []byte
+}
+
+// flacTag implements Tag for flac files using vorbis comment and picture
+// metadata blocks.
+type flacTag struct {
+	*vorbisComments
+	path     string
+	blocks   []flacBlock
+	pictures []*flacPicture
+	// offset of the first audio frame
+	audioOffset int64
+	length      time.Duration
+}
+
+func openFLACTag(audioPath string) (*flacTag, error) {
+
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	t := &flacTag{path: audioPath}
+
+	if err := t.readBlocks(bufio.NewReader(f)); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return t, nil
+}
+
+func (t *flacTag) readBlocks(r io.Reader) error {
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return errInvalidFLAC
+	}
+
+	t.audioOffset = 4
+
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return errInvalidFLAC
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return errInvalidFLAC
+		}
+
+		t.audioOffset += int64(4 + length)
+
+		switch blockType {
+		case flacBlockStreamInfo:
+			if length < 18 {
+				return errInvalidFLAC
+			}
+			// 20 bits sample rate followed by 3 bits channels, 5 bits bits
+			// per sample and 36 bits total samples
+			sampleRate := uint64(data[10])<<12 | uint64(data[11])<<4 | uint64(data[12])>>4
+			totalSamples := uint64(data[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(data[14:18]))
+			if sampleRate > 0 {
+				t.length = time.Duration(float64(totalSamples) / float64(sampleRate) * float64(time.Second))
+			}
+			t.blocks = append(t.blocks, flacBlock{blockType, data})
+		case flacBlockVorbisComment:
+			vc, err := parseVorbisComments(data)
+			if err != nil {
+				return err
+			}
+			t.vorbisComments = vc
+		case flacBlockPicture:
+			pic, err := parseFLACPicture(data)
+			if err == nil {
+				t.pictures = append(t.pictures, pic)
+			}
+		case flacBlockPadding:
+			// padding is recreated on save
+		default:
+			t.blocks = append(t.blocks, flacBlock{blockType, data})
+		}
+
+		if last {
+			break
+		}
+	}
+
+	if t.vorbisComments == nil {
+		t.vorbisComments = &vorbisComments{vendor: "gomu"}
+	}
+
+	return nil
+}
+
+func (t *flacTag) Pictures() []Picture {
+	pics := make([]Picture, 0, len(t.pictures))
+	for _, pic := range t.pictures {
+		pics = append(pics, pic.picture())
+	}
+	return pics
+}
+
+func (t *flacTag) SetPictures(pics []Picture) {
+	t.pictures = nil
+	for _, pic := range pics {
+		t.pictures = append(t.pictures, newFLACPicture(pic))
+	}
+}
+
+// Length is computed from the stream info block.
+func (t *flacTag) Length() time.Duration {
+	return t.length
+}
+
+func (t *flacTag) SetLength(length time.Duration) {}
+
+// Save rewrites the metadata blocks followed by the untouched audio frames.
+func (t *flacTag) Save() error {
+
+	blocks := append([]flacBlock{}, t.blocks...)
+	blocks = append(blocks, flacBlock{flacBlockVorbisComment, t.vorbisComments.bytes()})
+	for _, pic := range t.pictures {
+		blocks = append(blocks, flacBlock{flacBlockPicture, pic.bytes()})
+	}
+	// leave some room for other taggers to edit in place
+	blocks = append(blocks, flacBlock{flacBlockPadding, make([]byte, 1024)})
+
+	var header bytes.Buffer
+	header.WriteString("fLaC")
+
+	for i, block := range blocks {
+		if len(block.data) >= 1<<24 {
+			return tracerr.Wrap(errors.New("flac metadata block is too large"))
+		}
+		blockType := block.blockType
+		if i == len(blocks)-1 {
+			blockType |= 0x80
+		}
+		length := len(block.data)
+		header.Write([]byte{blockType, byte(length >> 16), byte(length >> 8), byte(length)})
+		header.Write(block.data)
+	}
+
+	src, err := os.Open(t.path)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer src.Close()
+
+	if _, err := src.Seek(t.audioOffset, io.SeekStart); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = replaceFile(t.path, func(dst *os.File) error {
+		if _, err := dst.Write(header.Bytes()); err != nil {
+			return err
+		}
+		_, err := io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	t.audioOffset = int64(header.Len())
+
+	return nil
+}
+
+// Close is a no-op, the file is only opened while reading and saving.
+func (t *flacTag) Close() error {
+	return nil
+}
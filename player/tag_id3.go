@@ -0,0 +1,406 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tramhao/id3v2"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/lyric"
+)
+
+// id3Tag implements Tag for mp3 files.
+type id3Tag struct {
+	tag *id3v2.Tag
+}
+
+func openID3Tag(audioPath string) (*id3Tag, error) {
+	tag, err := id3v2.Open(audioPath, id3v2.Options{Parse: true})
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	return &id3Tag{tag: tag}, nil
+}
+
+func (t *id3Tag) Title() string           { return t.tag.Title() }
+func (t *id3Tag) SetTitle(title string)   { t.tag.SetTitle(title) }
+func (t *id3Tag) Artist() string          { return t.tag.Artist() }
+func (t *id3Tag) SetArtist(artist string) { t.tag.SetArtist(artist) }
+func (t *id3Tag) Album() string           { return t.tag.Album() }
+func (t *id3Tag) SetAlbum(album string)   { t.tag.SetAlbum(album) }
+
+// TrackNumber parses TRCK frame which may be in the form of "3/12".
+func (t *id3Tag) TrackNumber() int {
+	trck := t.tag.GetTextFrame(t.tag.CommonID("Track number/Position in set"))
+	return parseTrackNumber(trck.Text)
+}
+
+func (t *id3Tag) SetTrackNumber(track int) {
+	id := t.tag.CommonID("Track number/Position in set")
+	if track <= 0 {
+		t.tag.DeleteFrames(id)
+		return
+	}
+	t.tag.AddTextFrame(id, id3v2.EncodingUTF8, strconv.Itoa(track))
+}
+
+// Genre reads TCON frame, genres referred by their id3v1 number such as
+// "(17)" or "17" are converted to their names.
+func (t *id3Tag) Genre() string {
+	return parseID3Genre(t.tag.Genre())
+}
+
+func (t *id3Tag) SetGenre(genre string) { t.tag.SetGenre(genre) }
+
+// Year reads TYER frame in id3v2.3 or TDRC frame in id3v2.4.
+func (t *id3Tag) Year() int {
+	return parseYear(t.tag.Year())
+}
+
+func (t *id3Tag) SetYear(year int) {
+	if year <= 0 {
+		t.tag.DeleteFrames(t.tag.CommonID("Year"))
+		return
+	}
+	t.tag.SetYear(strconv.Itoa(year))
+}
+
+// popmEmail identifies the POPM frame written by gomu, the rating of other
+// players is read when there is none.
+const popmEmail = "gomu"
+
+// Rating reads the POPM frame, the rating from 1 to 255 is converted to stars
+// the way most players do.
+func (t *id3Tag) Rating() int {
+
+	var rating uint8
+	found := false
+
+	for _, f := range t.tag.GetFrames(t.tag.CommonID("Popularimeter")) {
+		popm, ok := f.(id3v2.PopularimeterFrame)
+		if !ok {
+			continue
+		}
+		if !found || popm.Email == popmEmail {
+			rating = popm.Rating
+			found = true
+		}
+	}
+
+	return popmStars(rating)
+}
+
+// SetRating replaces the POPM frame of gomu, the play counter is kept.
+func (t *id3Tag) SetRating(stars int) {
+
+	id := t.tag.CommonID("Popularimeter")
+	frames := t.tag.GetFrames(id)
+	t.tag.DeleteFrames(id)
+
+	counter := big.NewInt(0)
+
+	for _, f := range frames {
+		popm, ok := f.(id3v2.PopularimeterFrame)
+		if !ok {
+			continue
+		}
+		if popm.Email == popmEmail {
+			if popm.Counter != nil {
+				counter = popm.Counter
+			}
+			continue
+		}
+		t.tag.AddFrame(id, popm)
+	}
+
+	if stars <= 0 {
+		return
+	}
+
+	t.tag.AddFrame(id, id3v2.PopularimeterFrame{
+		Email:   popmEmail,
+		Rating:  popmRating(stars),
+		Counter: counter,
+	})
+}
+
+// Lyrics reads USLT frames, the content descriptor is used to store the
+// language extension.
+func (t *id3Tag) Lyrics() map[string]string {
+
+	lyrics := make(map[string]string)
+
+	usltFrames := t.tag.GetFrames(t.tag.CommonID("Unsynchronised lyrics/text transcription"))
+	for _, f := range usltFrames {
+		uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame)
+		if !ok {
+			continue
+		}
+		lyrics[uslf.ContentDescriptor] = uslf.Lyrics
+	}
+
+	return lyrics
+}
+
+// SetLyric writes both USLT and SYLT frames so other players are able to show
+// synced lyric.
+func (t *id3Tag) SetLyric(langExt, lrc string) error {
+
+	var l lyric.Lyric
+	err := l.NewFromLRC(lrc)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	t.DeleteLyric(langExt)
+
+	t.tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
+		Encoding:          id3v2.EncodingUTF8,
+		Language:          "eng",
+		ContentDescriptor: langExt,
+		Lyrics:            lrc,
+	})
+
+	t.tag.AddSynchronisedLyricsFrame(id3v2.SynchronisedLyricsFrame{
+		Encoding:          id3v2.EncodingUTF8,
+		Language:          "eng",
+		TimestampFormat:   2,
+		ContentType:       1,
+		ContentDescriptor: langExt,
+		SynchronizedTexts: l.SyncedCaptions,
+	})
+
+	return nil
+}
+
+// DeleteLyric deletes the lyric frames with the same language by deleting all
+// of them and adding the others back.
+func (t *id3Tag) DeleteLyric(langExt string) {
+
+	usltID := t.tag.CommonID("Unsynchronised lyrics/text transcription")
+	usltFrames := t.tag.GetFrames(usltID)
+	t.tag.DeleteFrames(usltID)
+
+	for _, f := range usltFrames {
+		uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame)
+		if !ok || uslf.ContentDescriptor == langExt {
+			continue
+		}
+		t.tag.AddUnsynchronisedLyricsFrame(uslf)
+	}
+
+	syltID := t.tag.CommonID("Synchronised lyrics/text")
+	syltFrames := t.tag.GetFrames(syltID)
+	t.tag.DeleteFrames(syltID)
+
+	for _, f := range syltFrames {
+		sylf, ok := f.(id3v2.SynchronisedLyricsFrame)
+		if !ok || strings.Contains(sylf.ContentDescriptor, langExt) {
+			continue
+		}
+		t.tag.AddSynchronisedLyricsFrame(sylf)
+	}
+}
+
+func (t *id3Tag) Pictures() []Picture {
+
+	var pics []Picture
+
+	for _, f := range t.tag.GetFrames(t.tag.CommonID("Attached picture")) {
+		pic, ok := f.(id3v2.PictureFrame)
+		if !ok {
+			continue
+		}
+		pics = append(pics, Picture{
+			MimeType:    pic.MimeType,
+			Description: pic.Description,
+			Data:        pic.Picture,
+		})
+	}
+
+	return pics
+}
+
+func (t *id3Tag) SetPictures(pics []Picture) {
+
+	t.tag.DeleteFrames(t.tag.CommonID("Attached picture"))
+
+	for _, pic := range pics {
+		t.tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			MimeType:    pic.MimeType,
+			PictureType: id3v2.PTFrontCover,
+			Description: pic.Description,
+			Picture:     pic.Data,
+		})
+	}
+}
+
+// Length reads the length in milliseconds from the TLEN user defined text
+// frame.
+func (t *id3Tag) Length() time.Duration {
+
+	ms, err := strconv.ParseInt(t.UserText("TLEN"), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (t *id3Tag) SetLength(length time.Duration) {
+	t.SetUserText("TLEN", strconv.FormatInt(length.Milliseconds(), 10))
+}
+
+// UserText reads the TXXX frame with the given description.
+func (t *id3Tag) UserText(description string) string {
+
+	txxxFrames := t.tag.GetFrames(t.tag.CommonID("User defined text information frame"))
+	for _, f := range txxxFrames {
+		txxx, ok := f.(id3v2.UserDefinedTextFrame)
+		if ok && txxx.Description == description {
+			return txxx.Value
+		}
+	}
+
+	return ""
+}
+
+// SetUserText replaces the TXXX frame with the given description, an empty
+// value removes it.
+func (t *id3Tag) SetUserText(description, value string) {
+
+	id := t.tag.CommonID("User defined text information frame")
+	frames := t.tag.GetFrames(id)
+	t.tag.DeleteFrames(id)
+
+	for _, f := range frames {
+		txxx, ok := f.(id3v2.UserDefinedTextFrame)
+		if !ok || txxx.Description == description {
+			continue
+		}
+		t.tag.AddUserDefinedTextFrame(txxx)
+	}
+
+	if value == "" {
+		return
+	}
+
+	t.tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+		Encoding:    id3v2.EncodingUTF8,
+		Description: description,
+		Value:       value,
+	})
+}
+
+func (t *id3Tag) Save() error {
+	return tracerr.Wrap(t.tag.Save())
+}
+
+func (t *id3Tag) Close() error {
+	return t.tag.Close()
+}
+
+// parseTrackNumber parses track number in the form of "3" or "3/12".
+func parseTrackNumber(s string) int {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "/"); i >= 0 {
+		s = s[:i]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// parseYear parses the year of a date in the form of "1997" or "1997-05-21".
+func parseYear(s string) int {
+	s = strings.TrimSpace(s)
+	if len(s) > 4 {
+		s = s[:4]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// popmRatings are the POPM ratings written for 1 to 5 stars.
+var popmRatings = []uint8{1, 64, 128, 196, 255}
+
+// popmStars converts the POPM rating from 1 to 255 to stars, 0 is unrated.
+func popmStars(rating uint8) int {
+	switch {
+	case rating == 0:
+		return 0
+	case rating < 32:
+		return 1
+	case rating < 96:
+		return 2
+	case rating < 160:
+		return 3
+	case rating < 224:
+		return 4
+	}
+	return 5
+}
+
+func popmRating(stars int) uint8 {
+	return popmRatings[clampRating(stars)-1]
+}
+
+// id3v1Genres are the genres of id3v1 which may be referred by number in
+// TCON frame.
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+}
+
+// parseID3Genre converts genres in the form of "(17)", "(17)Rock" or "17" to
+// their names.
+func parseID3Genre(s string) string {
+
+	s = strings.TrimSpace(s)
+
+	if strings.HasPrefix(s, "(") {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return s
+		}
+		// the refinement after the number is preferred
+		if refinement := strings.TrimSpace(s[end+1:]); refinement != "" {
+			return refinement
+		}
+		s = s[1:end]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return s
+	}
+
+	if n >= 0 && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+
+	return ""
+}
@@ -0,0 +1,641 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+var errInvalidMP4 = errors.New("invalid mp4 atom")
+
+// ilst item names
+const (
+	mp4Title     = "\xa9nam"
+	mp4Artist    = "\xa9ART"
+	mp4Album     = "\xa9alb"
+	mp4Track     = "trkn"
+	mp4Genre     = "\xa9gen"
+	mp4Date      = "\xa9day"
+	mp4Lyrics    = "\xa9lyr"
+	mp4Cover     = "covr"
+	mp4Freeform  = "----"
+	mp4ITunesKey = "com.apple.iTunes"
+)
+
+// data atom type indicators
+const (
+	mp4TypeImplicit = 0
+	mp4TypeUTF8     = 1
+	mp4TypeJPEG     = 13
+	mp4TypePNG      = 14
+)
+
+// mp4Atom is a parsed box. Only containers in the path to ilst and the
+// sample tables are parsed, the rest are kept as raw payload.
+type mp4Atom struct {
+	name     string
+	data     []byte
+	children []*mp4Atom
+}
+
+// mp4Containers are atoms which payload is a list of atoms. meta is a full
+// atom, its children start after 4 bytes of version and flags.
+var mp4Containers = map[string]bool{
+	"moov": true, "udta": true, "meta": true, "ilst": true, "trak": true,
+	"mdia": true, "minf": true, "stbl": true,
+}
+
+func parseMP4Atoms(data []byte, parent string) ([]*mp4Atom, error) {
+
+	var atoms []*mp4Atom
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errInvalidMP4
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		name := string(data[4:8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errInvalidMP4
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, errInvalidMP4
+		}
+
+		atom := &mp4Atom{name: name, data: data[headerSize:size]}
+
+		// items of ilst are containers of data atoms
+		if mp4Containers[name] || parent == "ilst" {
+			payload := atom.data
+			if name == "meta" {
+				if len(payload) < 4 {
+					return nil, errInvalidMP4
+				}
+				payload = payload[4:]
+			}
+			children, err := parseMP4Atoms(payload, name)
+			if err != nil {
+				return nil, err
+			}
+			atom.children = children
+		}
+
+		atoms = append(atoms, atom)
+		data = data[size:]
+	}
+
+	return atoms, nil
+}
+
+func (a *mp4Atom) child(name string) *mp4Atom {
+	for _, c := range a.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// bytes serializes the atom, containers are rebuilt from their children.
+func (a *mp4Atom) bytes() []byte {
+
+	payload := a.data
+
+	if a.children != nil || mp4Containers[a.name] {
+		var buf bytes.Buffer
+		if a.name == "meta" {
+			buf.Write([]byte{0, 0, 0, 0})
+		}
+		for _, c := range a.children {
+			buf.Write(c.bytes())
+		}
+		payload = buf.Bytes()
+	}
+
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(8+len(payload)))
+	copy(buf[4:], a.name)
+
+	return append(buf, payload...)
+}
+
+// newMP4Data creates an ilst item holding a single data atom.
+func newMP4Data(dataType uint32, value []byte) *mp4Atom {
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(payload, dataType)
+	return &mp4Atom{name: "data", data: append(payload, value...)}
+}
+
+// mp4Tag implements Tag for mp4/m4a files using iTunes style ilst atoms.
+type mp4Tag struct {
+	path string
+	// top level atoms, only moov is parsed
+	moovOffset int64
+	moovSize   int64
+	moov       *mp4Atom
+	// true if mdat comes after moov so chunk offsets need to be patched
+	mdatAfterMoov bool
+	length        time.Duration
+}
+
+func openMP4Tag(audioPath string) (*mp4Tag, error) {
+
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	t := &mp4Tag{path: audioPath, moovOffset: -1}
+
+	var offset int64
+	header := make([]byte, 16)
+
+	for {
+		n, err := io.ReadFull(f, header[:8])
+		if err == io.EOF {
+			break
+		}
+		if err != nil || n < 8 {
+			return nil, tracerr.Wrap(errInvalidMP4)
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		name := string(header[4:8])
+		headerSize := int64(8)
+
+		if size == 1 {
+			if _, err := io.ReadFull(f, header[8:16]); err != nil {
+				return nil, tracerr.Wrap(errInvalidMP4)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		} else if size == 0 {
+			info, err := f.Stat()
+			if err != nil {
+				return nil, tracerr.Wrap(err)
+			}
+			size = info.Size() - offset
+		}
+
+		if size < headerSize {
+			return nil, tracerr.Wrap(errInvalidMP4)
+		}
+
+		switch name {
+		case "moov":
+			data := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, tracerr.Wrap(errInvalidMP4)
+			}
+			children, err := parseMP4Atoms(data, name)
+			if err != nil {
+				return nil, tracerr.Wrap(err)
+			}
+			t.moov = &mp4Atom{name: name, children: children}
+			t.moovOffset = offset
+			t.moovSize = size
+		case "mdat":
+			if t.moovOffset >= 0 {
+				t.mdatAfterMoov = true
+			}
+			fallthrough
+		default:
+			if _, err := f.Seek(offset+size, io.SeekStart); err != nil {
+				return nil, tracerr.Wrap(err)
+			}
+		}
+
+		offset += size
+	}
+
+	if t.moov == nil {
+		return nil, tracerr.Wrap(errInvalidMP4)
+	}
+
+	t.length = t.readLength()
+
+	return t, nil
+}
+
+// readLength reads the duration and time scale of mvhd.
+func (t *mp4Tag) readLength() time.Duration {
+
+	mvhd := t.moov.child("mvhd")
+	if mvhd == nil || len(mvhd.data) < 20 {
+		return 0
+	}
+
+	var timescale, duration uint64
+	if mvhd.data[0] == 1 {
+		if len(mvhd.data) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd.data[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd.data[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(mvhd.data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd.data[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// ilst returns the item list creating moov.udta.meta.ilst if needed.
+func (t *mp4Tag) ilst() *mp4Atom {
+
+	parent := t.moov
+	for _, name := range []string{"udta", "meta", "ilst"} {
+		child := parent.child(name)
+		if child == nil {
+			child = &mp4Atom{name: name, children: []*mp4Atom{}}
+			if name == "meta" {
+				// itunes requires metadata handler
+				hdlr := make([]byte, 25)
+				copy(hdlr[8:], "mdirappl")
+				child.children = append(child.children, &mp4Atom{name: "hdlr", data: hdlr})
+			}
+			parent.children = append(parent.children, child)
+		}
+		parent = child
+	}
+
+	return parent
+}
+
+// items returns the data atoms of the ilst item.
+func (t *mp4Tag) items(name string) []*mp4Atom {
+
+	var items []*mp4Atom
+
+	for _, item := range t.ilst().children {
+		if item.name != name {
+			continue
+		}
+		for _, c := range item.children {
+			if c.name == "data" && len(c.data) >= 8 {
+				items = append(items, c)
+			}
+		}
+	}
+
+	return items
+}
+
+func (t *mp4Tag) getText(name string) string {
+	items := t.items(name)
+	if len(items) == 0 {
+		return ""
+	}
+	return string(items[0].data[8:])
+}
+
+func (t *mp4Tag) deleteItems(match func(item *mp4Atom) bool) {
+	ilst := t.ilst()
+	children := ilst.children[:0]
+	for _, item := range ilst.children {
+		if !match(item) {
+			children = append(children, item)
+		}
+	}
+	ilst.children = children
+}
+
+func (t *mp4Tag) setItem(name string, data ...*mp4Atom) {
+	t.deleteItems(func(item *mp4Atom) bool { return item.name == name })
+	if len(data) == 0 {
+		return
+	}
+	ilst := t.ilst()
+	ilst.children = append(ilst.children, &mp4Atom{name: name, children: data})
+}
+
+func (t *mp4Tag) setText(name, value string) {
+	if value == "" {
+		t.setItem(name)
+		return
+	}
+	t.setItem(name, newMP4Data(mp4TypeUTF8, []byte(value)))
+}
+
+func (t *mp4Tag) Title() string           { return t.getText(mp4Title) }
+func (t *mp4Tag) SetTitle(title string)   { t.setText(mp4Title, title) }
+func (t *mp4Tag) Artist() string          { return t.getText(mp4Artist) }
+func (t *mp4Tag) SetArtist(artist string) { t.setText(mp4Artist, artist) }
+func (t *mp4Tag) Album() string           { return t.getText(mp4Album) }
+func (t *mp4Tag) SetAlbum(album string)   { t.setText(mp4Album, album) }
+
+// TrackNumber reads trkn which payload is 2 bytes padding, 2 bytes track
+// number and 2 bytes total tracks.
+func (t *mp4Tag) TrackNumber() int {
+	items := t.items(mp4Track)
+	if len(items) == 0 || len(items[0].data) < 12 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(items[0].data[10:12]))
+}
+
+func (t *mp4Tag) SetTrackNumber(track int) {
+	if track <= 0 {
+		t.setItem(mp4Track)
+		return
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint16(value[2:4], uint16(track))
+	t.setItem(mp4Track, newMP4Data(mp4TypeImplicit, value))
+}
+
+func (t *mp4Tag) Genre() string         { return t.getText(mp4Genre) }
+func (t *mp4Tag) SetGenre(genre string) { t.setText(mp4Genre, genre) }
+
+func (t *mp4Tag) Year() int {
+	return parseYear(t.getText(mp4Date))
+}
+
+func (t *mp4Tag) SetYear(year int) {
+	if year <= 0 {
+		t.setItem(mp4Date)
+		return
+	}
+	t.setText(mp4Date, strconv.Itoa(year))
+}
+
+// Rating reads the RATING freeform item as there is no standard item.
+func (t *mp4Tag) Rating() int {
+	return parsePercentRating(t.getFreeform(ratingField))
+}
+
+func (t *mp4Tag) SetRating(stars int) {
+	t.setFreeform(ratingField, percentRating(stars))
+}
+
+// freeformName returns the name of ---- item, or empty string if the item is
+// not an itunes freeform item.
+func freeformName(item *mp4Atom) string {
+	if item.name != mp4Freeform {
+		return ""
+	}
+	mean, name := item.child("mean"), item.child("name")
+	if mean == nil || name == nil || len(mean.data) < 4 || len(name.data) < 4 {
+		return ""
+	}
+	if string(mean.data[4:]) != mp4ITunesKey {
+		return ""
+	}
+	return string(name.data[4:])
+}
+
+// Lyrics reads ©lyr as lyric without language, other languages are stored in
+// freeform LYRICS:<lang> items.
+func (t *mp4Tag) Lyrics() map[string]string {
+
+	lyrics := make(map[string]string)
+
+	if lyr := t.getText(mp4Lyrics); lyr != "" {
+		lyrics[""] = lyr
+	}
+
+	for _, item := range t.ilst().children {
+		name := freeformName(item)
+		if !strings.HasPrefix(name, vcLyrics+":") {
+			continue
+		}
+		if data := item.child("data"); data != nil && len(data.data) >= 8 {
+			lyrics[name[len(vcLyrics)+1:]] = string(data.data[8:])
+		}
+	}
+
+	return lyrics
+}
+
+func (t *mp4Tag) SetLyric(langExt, lrc string) error {
+
+	if langExt == "" {
+		t.setText(mp4Lyrics, lrc)
+		return nil
+	}
+
+	t.setFreeform(lyricField(langExt), lrc)
+
+	return nil
+}
+
+func (t *mp4Tag) DeleteLyric(langExt string) {
+
+	if langExt == "" {
+		t.setItem(mp4Lyrics)
+		return
+	}
+
+	t.setFreeform(lyricField(langExt), "")
+}
+
+func (t *mp4Tag) getFreeform(field string) string {
+	for _, item := range t.ilst().children {
+		if freeformName(item) != field {
+			continue
+		}
+		if data := item.child("data"); data != nil && len(data.data) >= 8 {
+			return string(data.data[8:])
+		}
+	}
+	return ""
+}
+
+// setFreeform replaces the itunes freeform item, an empty value removes it.
+func (t *mp4Tag) setFreeform(field, value string) {
+
+	t.deleteItems(func(item *mp4Atom) bool {
+		return freeformName(item) == field
+	})
+
+	if value == "" {
+		return
+	}
+
+	mean := append([]byte{0, 0, 0, 0}, mp4ITunesKey...)
+	name := append([]byte{0, 0, 0, 0}, field...)
+
+	ilst := t.ilst()
+	ilst.children = append(ilst.children, &mp4Atom{
+		name: mp4Freeform,
+		children: []*mp4Atom{
+			{name: "mean", data: mean},
+			{name: "name", data: name},
+			newMP4Data(mp4TypeUTF8, []byte(value)),
+		},
+	})
+}
+
+// UserText reads the itunes freeform item.
+func (t *mp4Tag) UserText(name string) string {
+	return t.getFreeform(name)
+}
+
+func (t *mp4Tag) SetUserText(name, value string) {
+	t.setFreeform(name, value)
+}
+
+func (t *mp4Tag) Pictures() []Picture {
+
+	var pics []Picture
+
+	for _, data := range t.items(mp4Cover) {
+		mimeType := "image/jpeg"
+		if binary.BigEndian.Uint32(data.data[:4]) == mp4TypePNG {
+			mimeType = "image/png"
+		}
+		pics = append(pics, Picture{MimeType: mimeType, Data: data.data[8:]})
+	}
+
+	return pics
+}
+
+func (t *mp4Tag) SetPictures(pics []Picture) {
+
+	var data []*mp4Atom
+
+	for _, pic := range pics {
+		dataType := uint32(mp4TypeJPEG)
+		if pic.MimeType == "image/png" {
+			dataType = mp4TypePNG
+		}
+		data = append(data, newMP4Data(dataType, pic.Data))
+	}
+
+	t.setItem(mp4Cover, data...)
+}
+
+// Length is computed from the movie header.
+func (t *mp4Tag) Length() time.Duration {
+	return t.length
+}
+
+func (t *mp4Tag) SetLength(length time.Duration) {}
+
+// patchChunkOffsets shifts the sample table chunk offsets of every track by
+// delta. This is needed when moov is placed before mdat and changes size.
+func (t *mp4Tag) patchChunkOffsets(delta int64) error {
+
+	for _, trak := range t.moov.children {
+		if trak.name != "trak" {
+			continue
+		}
+
+		stbl := trak
+		for _, name := range []string{"mdia", "minf", "stbl"} {
+			if stbl = stbl.child(name); stbl == nil {
+				break
+			}
+		}
+		if stbl == nil {
+			continue
+		}
+
+		for _, table := range stbl.children {
+			if table.name != "stco" && table.name != "co64" {
+				continue
+			}
+			if len(table.data) < 8 {
+				return errInvalidMP4
+			}
+
+			// copy as data may point into the original buffer
+			data := append([]byte{}, table.data...)
+			count := int(binary.BigEndian.Uint32(data[4:8]))
+
+			entrySize := 4
+			if table.name == "co64" {
+				entrySize = 8
+			}
+			if len(data) < 8+count*entrySize {
+				return errInvalidMP4
+			}
+
+			for i := 0; i < count; i++ {
+				entry := data[8+i*entrySize:]
+				if entrySize == 4 {
+					v := int64(binary.BigEndian.Uint32(entry)) + delta
+					binary.BigEndian.PutUint32(entry, uint32(v))
+				} else {
+					v := int64(binary.BigEndian.Uint64(entry)) + delta
+					binary.BigEndian.PutUint64(entry, uint64(v))
+				}
+			}
+
+			table.data = data
+		}
+	}
+
+	return nil
+}
+
+// Save rewrites the moov atom in place of the old one.
+func (t *mp4Tag) Save() error {
+
+	moov := t.moov.bytes()
+	delta := int64(len(moov)) - t.moovSize
+
+	if t.mdatAfterMoov && delta != 0 {
+		if err := t.patchChunkOffsets(delta); err != nil {
+			return tracerr.Wrap(err)
+		}
+		moov = t.moov.bytes()
+	}
+
+	src, err := os.Open(t.path)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer src.Close()
+
+	err = replaceFile(t.path, func(dst *os.File) error {
+		if _, err := io.CopyN(dst, src, t.moovOffset); err != nil {
+			return err
+		}
+		if _, err := dst.Write(moov); err != nil {
+			return err
+		}
+		if _, err := src.Seek(t.moovOffset+t.moovSize, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	t.moovSize = int64(len(moov))
+
+	return nil
+}
+
+// Close is a no-op, the file is only opened while reading and saving.
+func (t *mp4Tag) Close() error {
+	return nil
+}
@@ -0,0 +1,363 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+var errInvalidOgg = errors.New("invalid ogg vorbis stream")
+
+// oggCRCTable is the crc32 table with polynomial 0x04c11db7 used by ogg. It
+// differs from hash/crc32 as the bits are not reflected.
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggPage is a single page of ogg bitstream.
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	segments   []byte
+	data       []byte
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if string(header[:4]) != "OggS" {
+		return nil, errInvalidOgg
+	}
+
+	page := &oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:14]),
+		serial:     binary.LittleEndian.Uint32(header[14:18]),
+		sequence:   binary.LittleEndian.Uint32(header[18:22]),
+		segments:   make([]byte, header[26]),
+	}
+
+	if _, err := io.ReadFull(r, page.segments); err != nil {
+		return nil, errInvalidOgg
+	}
+
+	size := 0
+	for _, s := range page.segments {
+		size += int(s)
+	}
+
+	page.data = make([]byte, size)
+	if _, err := io.ReadFull(r, page.data); err != nil {
+		return nil, errInvalidOgg
+	}
+
+	return page, nil
+}
+
+// bytes serializes the page and computes its checksum.
+func (p *oggPage) bytes() []byte {
+
+	buf := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(buf, "OggS")
+	buf[5] = p.headerType
+	binary.LittleEndian.PutUint64(buf[6:14], p.granule)
+	binary.LittleEndian.PutUint32(buf[14:18], p.serial)
+	binary.LittleEndian.PutUint32(buf[18:22], p.sequence)
+	buf[26] = byte(len(p.segments))
+	buf = append(buf, p.segments...)
+	buf = append(buf, p.data...)
+
+	binary.LittleEndian.PutUint32(buf[22:26], oggCRC(buf))
+
+	return buf
+}
+
+// oggTag implements Tag for ogg vorbis files.
+type oggTag struct {
+	*vorbisComments
+	path string
+	// identification, comment and setup header packets
+	packets [3][]byte
+	serial  uint32
+	// number of header pages in the original file
+	headerPages int
+	length      time.Duration
+}
+
+func openOggTag(audioPath string) (*oggTag, error) {
+
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	t := &oggTag{path: audioPath}
+
+	if err := t.readHeaders(bufio.NewReader(f)); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	t.length, err = t.readLength(f)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return t, nil
+}
+
+// readHeaders reassembles the three vorbis header packets.
+func (t *oggTag) readHeaders(r io.Reader) error {
+
+	packet := 0
+
+	for packet < 3 {
+		page, err := readOggPage(r)
+		if err != nil {
+			return errInvalidOgg
+		}
+
+		if t.headerPages == 0 {
+			t.serial = page.serial
+		}
+		t.headerPages++
+
+		offset := 0
+		for _, s := range page.segments {
+			if packet >= 3 {
+				// audio data must start on a fresh page
+				return errInvalidOgg
+			}
+			t.packets[packet] = append(t.packets[packet], page.data[offset:offset+int(s)]...)
+			offset += int(s)
+			if s < 255 {
+				packet++
+			}
+		}
+	}
+
+	id, comment := t.packets[0], t.packets[1]
+
+	if len(id) < 16 || !bytes.HasPrefix(id, []byte("\x01vorbis")) {
+		return errInvalidOgg
+	}
+
+	if len(comment) < 7 || !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+		return errInvalidOgg
+	}
+
+	vc, err := parseVorbisComments(comment[7:])
+	if err != nil {
+		return err
+	}
+	t.vorbisComments = vc
+
+	return nil
+}
+
+// readLength reads the granule position of the last page which is the total
+// number of samples.
+func (t *oggTag) readLength(f *os.File) (time.Duration, error) {
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// maximum size of an ogg page
+	const maxPage = 65307
+
+	start := info.Size() - maxPage
+	if start < 0 {
+		start = 0
+	}
+
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	sampleRate := binary.LittleEndian.Uint32(t.packets[0][12:16])
+	if sampleRate == 0 {
+		return 0, nil
+	}
+
+	for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+		page, err := readOggPage(bytes.NewReader(buf[i:]))
+		if err != nil || page.serial != t.serial || page.granule == ^uint64(0) {
+			continue
+		}
+		seconds := float64(page.granule) / float64(sampleRate)
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	return 0, nil
+}
+
+func (t *oggTag) Pictures() []Picture {
+	return t.commentPictures()
+}
+
+func (t *oggTag) SetPictures(pics []Picture) {
+	t.setCommentPictures(pics)
+}
+
+// Length is computed from the granule position of the last page.
+func (t *oggTag) Length() time.Duration {
+	return t.length
+}
+
+func (t *oggTag) SetLength(length time.Duration) {}
+
+// paginateHeaders paginates the header packets. The identification header is
+// required to be alone in the first page.
+func (t *oggTag) paginateHeaders() []*oggPage {
+
+	comment := append([]byte("\x03vorbis"), t.vorbisComments.bytes()...)
+	// framing bit
+	comment = append(comment, 1)
+
+	var pages []*oggPage
+
+	newPage := func() *oggPage {
+		page := &oggPage{serial: t.serial, granule: ^uint64(0)}
+		pages = append(pages, page)
+		return page
+	}
+
+	addPacket := func(page *oggPage, packet []byte) *oggPage {
+		continued := false
+		for {
+			if len(page.segments) == 255 {
+				page = newPage()
+				if continued {
+					page.headerType = 0x01
+				}
+			}
+			continued = true
+			n := len(packet)
+			if n > 255 {
+				n = 255
+			}
+			page.segments = append(page.segments, byte(n))
+			page.data = append(page.data, packet[:n]...)
+			packet = packet[n:]
+			if n < 255 {
+				// packet finished in this page
+				page.granule = 0
+				return page
+			}
+		}
+	}
+
+	first := newPage()
+	first.headerType = 0x02
+	addPacket(first, t.packets[0])
+
+	page := addPacket(newPage(), comment)
+	addPacket(page, t.packets[2])
+
+	for i, page := range pages {
+		page.sequence = uint32(i)
+	}
+
+	return pages
+}
+
+// Save repaginates the header packets and renumbers the audio pages that
+// follow.
+func (t *oggTag) Save() error {
+
+	src, err := os.Open(t.path)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer src.Close()
+
+	r := bufio.NewReader(src)
+
+	// skip the original header pages
+	for i := 0; i < t.headerPages; i++ {
+		if _, err := readOggPage(r); err != nil {
+			return tracerr.Wrap(errInvalidOgg)
+		}
+	}
+
+	headers := t.paginateHeaders()
+	seqDelta := uint32(len(headers) - t.headerPages)
+
+	err = replaceFile(t.path, func(dst *os.File) error {
+
+		w := bufio.NewWriter(dst)
+
+		for _, page := range headers {
+			if _, err := w.Write(page.bytes()); err != nil {
+				return err
+			}
+		}
+
+		for {
+			page, err := readOggPage(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if page.serial == t.serial {
+				page.sequence += seqDelta
+			}
+			if _, err := w.Write(page.bytes()); err != nil {
+				return err
+			}
+		}
+
+		return w.Flush()
+	})
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	t.headerPages = len(headers)
+
+	return nil
+}
+
+// Close is a no-op, the file is only opened while reading and saving.
+func (t *oggTag) Close() error {
+	return nil
+}
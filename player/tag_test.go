@@ -0,0 +1,310 @@
+package player
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// audio stands for the encoded frames which must survive tag rewrites
+var audio = bytes.Repeat([]byte("audio frames "), 100)
+
+func writeTemp(t *testing.T, name string, content []byte) string {
+
+	dir, err := ioutil.TempDir("", "gomu-tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func sampleFLAC() []byte {
+
+	// 44100 Hz, 2 channels, 16 bits, 441000 samples
+	streamInfo := make([]byte, 34)
+	streamInfo[10] = 0x0A
+	streamInfo[11] = 0xC4
+	streamInfo[12] = 0x42
+	streamInfo[13] = 0xF0
+	binary.BigEndian.PutUint32(streamInfo[14:18], 441000)
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	buf.Write([]byte{0x80 | flacBlockStreamInfo, 0, 0, byte(len(streamInfo))})
+	buf.Write(streamInfo)
+	buf.Write(audio)
+
+	return buf.Bytes()
+}
+
+func sampleOgg() []byte {
+
+	id := make([]byte, 30)
+	copy(id, "\x01vorbis")
+	id[11] = 2
+	binary.LittleEndian.PutUint32(id[12:16], 44100)
+
+	vc := &vorbisComments{vendor: "test"}
+	setup := append([]byte("\x05vorbis"), make([]byte, 300)...)
+
+	// the comment packet is built from vc
+	tag := &oggTag{serial: 7, packets: [3][]byte{id, nil, setup}, vorbisComments: vc}
+
+	var buf bytes.Buffer
+	for _, page := range tag.paginateHeaders() {
+		buf.Write(page.bytes())
+	}
+
+	last := &oggPage{
+		headerType: 0x04,
+		granule:    441000,
+		serial:     7,
+		sequence:   2,
+		segments:   []byte{byte(len(audio) % 255)},
+		data:       audio[:len(audio)%255],
+	}
+	buf.Write(last.bytes())
+
+	return buf.Bytes()
+}
+
+func mp4Box(name string, data ...[]byte) []byte {
+	content := bytes.Join(data, nil)
+	box := make([]byte, 8, 8+len(content))
+	binary.BigEndian.PutUint32(box, uint32(8+len(content)))
+	copy(box[4:], name)
+	return append(box, content...)
+}
+
+func sampleMP4() []byte {
+
+	// version 0 mvhd with time scale 1000 and duration 10s
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:16], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:20], 10000)
+
+	ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00M4A "))
+
+	stco := make([]byte, 12)
+	binary.BigEndian.PutUint32(stco[4:8], 1)
+
+	newMoov := func() []byte {
+		return mp4Box("moov",
+			mp4Box("mvhd", mvhd),
+			mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stco", stco))))),
+		)
+	}
+
+	// the only chunk starts at the beginning of mdat content
+	binary.BigEndian.PutUint32(stco[8:12], uint32(len(ftyp)+len(newMoov())+8))
+	moov := newMoov()
+
+	return bytes.Join([][]byte{ftyp, moov, mp4Box("mdat", audio)}, nil)
+}
+
+func TestTagRoundTrip(t *testing.T) {
+
+	samples := map[string][]byte{
+		"song.flac": sampleFLAC(),
+		"song.ogg":  sampleOgg(),
+		"song.m4a":  sampleMP4(),
+	}
+
+	cover := Picture{MimeType: "image/png", Description: "cover", Data: []byte("png")}
+
+	for name, content := range samples {
+
+		path := writeTemp(t, name, content)
+
+		tag, err := OpenTag(path)
+		if err != nil {
+			t.Fatalf("%s: OpenTag; unexpected error %v", name, err)
+		}
+
+		if got := tag.Length(); got != 10*time.Second {
+			t.Errorf("%s: expected length 10s got %v", name, got)
+		}
+
+		tag.SetTitle("Karma Police")
+		tag.SetArtist("Radiohead")
+		tag.SetAlbum("OK Computer")
+		tag.SetTrackNumber(6)
+		tag.SetGenre("Alternative")
+		tag.SetYear(1997)
+		tag.SetRating(4)
+		tag.SetPictures([]Picture{cover})
+		if err := tag.SetLyric("en", "[00:01.00]hello"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tag.SetLyric("zh-CN", "[00:01.00]ni hao"); err != nil {
+			t.Fatal(err)
+		}
+		tag.DeleteLyric("zh-CN")
+
+		if err := tag.Save(); err != nil {
+			t.Fatalf("%s: Save; unexpected error %v", name, err)
+		}
+		tag.Close()
+
+		tag, err = OpenTag(path)
+		if err != nil {
+			t.Fatalf("%s: OpenTag after save; unexpected error %v", name, err)
+		}
+
+		if tag.Title() != "Karma Police" || tag.Artist() != "Radiohead" || tag.Album() != "OK Computer" {
+			t.Errorf("%s: got %q %q %q", name, tag.Title(), tag.Artist(), tag.Album())
+		}
+
+		if got := tag.TrackNumber(); got != 6 {
+			t.Errorf("%s: expected track 6 got %d", name, got)
+		}
+
+		if tag.Genre() != "Alternative" || tag.Year() != 1997 {
+			t.Errorf("%s: expected Alternative 1997 got %q %d", name, tag.Genre(), tag.Year())
+		}
+
+		if got := tag.Rating(); got != 4 {
+			t.Errorf("%s: expected rating 4 got %d", name, got)
+		}
+
+		lyrics := tag.Lyrics()
+		if len(lyrics) != 1 || lyrics["en"] != "[00:01.00]hello" {
+			t.Errorf("%s: unexpected lyrics %v", name, lyrics)
+		}
+
+		pics := tag.Pictures()
+		if len(pics) != 1 || !bytes.Equal(pics[0].Data, cover.Data) {
+			t.Errorf("%s: unexpected pictures %v", name, pics)
+		}
+
+		if got := tag.Length(); got != 10*time.Second {
+			t.Errorf("%s: expected length 10s after save got %v", name, got)
+		}
+
+		saved, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != "song.ogg" && !bytes.HasSuffix(saved, audio) {
+			t.Errorf("%s: audio frames are not preserved", name)
+		}
+	}
+}
+
+func TestMP4ChunkOffset(t *testing.T) {
+
+	path := writeTemp(t, "song.m4a", sampleMP4())
+
+	tag, err := OpenTag(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag.SetTitle("a title long enough to grow the moov atom")
+	if err := tag.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := bytes.Index(saved, []byte("stco"))
+	offset := binary.BigEndian.Uint32(saved[i+12 : i+16])
+
+	if !bytes.HasPrefix(saved[offset:], audio) {
+		t.Errorf("chunk offset %d does not point to the audio frames", offset)
+	}
+}
+
+func TestParseTrackNumber(t *testing.T) {
+
+	samples := map[string]int{
+		"3":    3,
+		"3/12": 3,
+		" 7 ":  7,
+		"":     0,
+		"a/b":  0,
+	}
+
+	for k, v := range samples {
+		if got := parseTrackNumber(k); got != v {
+			t.Errorf("parseTrackNumber(%q); expected %d got %d", k, v, got)
+		}
+	}
+}
+
+func TestParseYear(t *testing.T) {
+
+	samples := map[string]int{
+		"1997":       1997,
+		"1997-05-21": 1997,
+		" 2001 ":     2001,
+		"":           0,
+		"unknown":    0,
+	}
+
+	for k, v := range samples {
+		if got := parseYear(k); got != v {
+			t.Errorf("parseYear(%q); expected %d got %d", k, v, got)
+		}
+	}
+}
+
+func TestParseID3Genre(t *testing.T) {
+
+	samples := map[string]string{
+		"Rock":          "Rock",
+		"17":            "Rock",
+		"(17)":          "Rock",
+		"(17)Indie":     "Indie",
+		"(255)":         "",
+		"Drum & Bass":   "Drum & Bass",
+		" Alternative ": "Alternative",
+	}
+
+	for k, v := range samples {
+		if got := parseID3Genre(k); got != v {
+			t.Errorf("parseID3Genre(%q); expected %q got %q", k, v, got)
+		}
+	}
+}
+
+func TestRatingConversion(t *testing.T) {
+
+	for stars := 1; stars <= 5; stars++ {
+		if got := popmStars(popmRating(stars)); got != stars {
+			t.Errorf("popm round trip of %d stars; got %d", stars, got)
+		}
+		if got := parsePercentRating(percentRating(stars)); got != stars {
+			t.Errorf("percent round trip of %d stars; got %d", stars, got)
+		}
+	}
+
+	popm := map[uint8]int{0: 0, 13: 1, 54: 2, 118: 3, 186: 4, 242: 5}
+	for k, v := range popm {
+		if got := popmStars(k); got != v {
+			t.Errorf("popmStars(%d); expected %d got %d", k, v, got)
+		}
+	}
+
+	percent := map[string]int{"": 0, "0": 0, "50": 3, "100": 5, "200": 5, "bad": 0}
+	for k, v := range percent {
+		if got := parsePercentRating(k); got != v {
+			t.Errorf("parsePercentRating(%q); expected %d got %d", k, v, got)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright (C) 2020  Raziman
+
+package player
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// vorbis comment field names, matching is case insensitive
+const (
+	vcTitle       = "TITLE"
+	vcArtist      = "ARTIST"
+	vcAlbum       = "ALBUM"
+	vcTrackNumber = "TRACKNUMBER"
+	vcGenre       = "GENRE"
+	vcDate        = "DATE"
+	vcLyrics      = "LYRICS"
+	vcPicture     = "METADATA_BLOCK_PICTURE"
+)
+
+var errInvalidVorbisComment = errors.New("invalid vorbis comment")
+
+// vorbisComments is the tag format used by flac and ogg vorbis. Field order is
+// preserved so unknown fields are written back untouched.
+type vorbisComments struct {
+	vendor string
+	fields []vorbisField
+}
+
+type vorbisField struct {
+	name  string
+	value string
+}
+
+// parseVorbisComments parses the comment block without framing bit.
+func parseVorbisComments(data []byte) (*vorbisComments, error) {
+
+	r := bytes.NewReader(data)
+
+	vendor, err := readVorbisString(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errInvalidVorbisComment
+	}
+
+	vc := &vorbisComments{vendor: vendor}
+
+	for i := uint32(0); i < count; i++ {
+		field, err := readVorbisString(r)
+		if err != nil {
+			return nil, err
+		}
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			continue
+		}
+		vc.fields = append(vc.fields, vorbisField{field[:eq], field[eq+1:]})
+	}
+
+	return vc, nil
+}
+
+func readVorbisString(r *bytes.Reader) (string, error) {
+
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", errInvalidVorbisComment
+	}
+
+	if int64(length) > int64(r.Len()) {
+		return "", errInvalidVorbisComment
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", errInvalidVorbisComment
+	}
+
+	return string(buf), nil
+}
+
+// bytes serializes the comment block without framing bit.
+func (vc *vorbisComments) bytes() []byte {
+
+	var buf bytes.Buffer
+
+	writeString := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	writeString(vc.vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(vc.fields)))
+	for _, f := range vc.fields {
+		writeString(f.name + "=" + f.value)
+	}
+
+	return buf.Bytes()
+}
+
+// get returns the first value of the field.
+func (vc *vorbisComments) get(name string) string {
+	for _, f := range vc.fields {
+		if strings.EqualFold(f.name, name) {
+			return f.value
+		}
+	}
+	return ""
+}
+
+func (vc *vorbisComments) getAll(name string) []string {
+	var values []string
+	for _, f := range vc.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+func (vc *vorbisComments) del(name string) {
+	fields := vc.fields[:0]
+	for _, f := range vc.fields {
+		if !strings.EqualFold(f.name, name) {
+			fields = append(fields, f)
+		}
+	}
+	vc.fields = fields
+}
+
+// set replaces all values of the field, an empty value removes the field.
+func (vc *vorbisComments) set(name, value string) {
+	vc.del(name)
+	if value != "" {
+		vc.fields = append(vc.fields, vorbisField{name, value})
+	}
+}
+
+func (vc *vorbisComments) Title() string           { return vc.get(vcTitle) }
+func (vc *vorbisComments) SetTitle(title string)   { vc.set(vcTitle, title) }
+func (vc *vorbisComments) Artist() string          { return vc.get(vcArtist) }
+func (vc *vorbisComments) SetArtist(artist string) { vc.set(vcArtist, artist) }
+func (vc *vorbisComments) Album() string           { return vc.get(vcAlbum) }
+func (vc *vorbisComments) SetAlbum(album string)   { vc.set(vcAlbum, album) }
+
+func (vc *vorbisComments) TrackNumber() int {
+	return parseTrackNumber(vc.get(vcTrackNumber))
+}
+
+func (vc *vorbisComments) SetTrackNumber(track int) {
+	if track <= 0 {
+		vc.del(vcTrackNumber)
+		return
+	}
+	vc.set(vcTrackNumber, strconv.Itoa(track))
+}
+
+func (vc *vorbisComments) Genre() string         { return vc.get(vcGenre) }
+func (vc *vorbisComments) SetGenre(genre string) { vc.set(vcGenre, genre) }
+
+func (vc *vorbisComments) Year() int {
+	return parseYear(vc.get(vcDate))
+}
+
+func (vc *vorbisComments) SetYear(year int) {
+	if year <= 0 {
+		vc.del(vcDate)
+		return
+	}
+	vc.set(vcDate, strconv.Itoa(year))
+}
+
+func (vc *vorbisComments) Rating() int {
+	return parsePercentRating(vc.get(ratingField))
+}
+
+func (vc *vorbisComments) SetRating(stars int) {
+	vc.set(ratingField, percentRating(stars))
+}
+
+// lyricField returns the field name used to store lyric for the language.
+// Plain LYRICS field written by other taggers maps to empty language.
+func lyricField(langExt string) string {
+	if langExt == "" {
+		return vcLyrics
+	}
+	return vcLyrics + ":" + langExt
+}
+
+func (vc *vorbisComments) Lyrics() map[string]string {
+
+	lyrics := make(map[string]string)
+
+	for _, f := range vc.fields {
+		name := strings.ToUpper(f.name)
+		switch {
+		case name == vcLyrics:
+			lyrics[""] = f.value
+		case strings.HasPrefix(name, vcLyrics+":"):
+			// keep the case of the language as written
+			lyrics[f.name[len(vcLyrics)+1:]] = f.value
+		}
+	}
+
+	return lyrics
+}
+
+func (vc *vorbisComments) SetLyric(langExt, lrc string) error {
+	vc.set(lyricField(langExt), lrc)
+	return nil
+}
+
+func (vc *vorbisComments) DeleteLyric(langExt string) {
+	vc.del(lyricField(langExt))
+}
+
+func (vc *vorbisComments) UserText(name string) string {
+	return vc.get(name)
+}
+
+func (vc *vorbisComments) SetUserText(name, value string) {
+	vc.set(name, value)
+}
+
+// flacPicture is the PICTURE metadata block, the same structure is base64
+// encoded in METADATA_BLOCK_PICTURE comment of ogg files.
+type flacPicture struct {
+	pictureType uint32
+	mimeType    string
+	description string
+	width       uint32
+	height      uint32
+	depth       uint32
+	colors      uint32
+	data        []byte
+}
+
+func parseFLACPicture(data []byte) (*flacPicture, error) {
+
+	r := bytes.NewReader(data)
+	pic := &flacPicture{}
+
+	readString := func() (string, error) {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return "", err
+		}
+		if int64(length) > int64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		buf := make([]byte, length)
+		_, err := io.ReadFull(r, buf)
+		return string(buf), err
+	}
+
+	var err error
+
+	if err = binary.Read(r, binary.BigEndian, &pic.pictureType); err != nil {
+		return nil, err
+	}
+	if pic.mimeType, err = readString(); err != nil {
+		return nil, err
+	}
+	if pic.description, err = readString(); err != nil {
+		return nil, err
+	}
+	for _, v := range []*uint32{&pic.width, &pic.height, &pic.depth, &pic.colors} {
+		if err = binary.Read(r, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	data2, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	pic.data = []byte(data2)
+
+	return pic, nil
+}
+
+func (pic *flacPicture) bytes() []byte {
+
+	var buf bytes.Buffer
+
+	writeString := func(s []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(s)))
+		buf.Write(s)
+	}
+
+	binary.Write(&buf, binary.BigEndian, pic.pictureType)
+	writeString([]byte(pic.mimeType))
+	writeString([]byte(pic.description))
+	for _, v := range []uint32{pic.width, pic.height, pic.depth, pic.colors} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeString(pic.data)
+
+	return buf.Bytes()
+}
+
+func (pic *flacPicture) picture() Picture {
+	return Picture{
+		MimeType:    pic.mimeType,
+		Description: pic.description,
+		Data:        pic.data,
+	}
+}
+
+// newFLACPicture creates front cover picture block.
+func newFLACPicture(pic Picture) *flacPicture {
+	return &flacPicture{
+		pictureType: 3,
+		mimeType:    pic.MimeType,
+		description: pic.Description,
+		data:        pic.Data,
+	}
+}
+
+// commentPictures decodes METADATA_BLOCK_PICTURE fields.
+func (vc *vorbisComments) commentPictures() []Picture {
+
+	var pics []Picture
+
+	for _, v := range vc.getAll(vcPicture) {
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		pic, err := parseFLACPicture(data)
+		if err != nil {
+			continue
+		}
+		pics = append(pics, pic.picture())
+	}
+
+	return pics
+}
+
+func (vc *vorbisComments) setCommentPictures(pics []Picture) {
+	vc.del(vcPicture)
+	for _, pic := range pics {
+		encoded := base64.StdEncoding.EncodeToString(newFLACPicture(pic).bytes())
+		vc.fields = append(vc.fields, vorbisField{vcPicture, encoded})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -16,7 +17,6 @@ import (
 
 	"github.com/disintegration/imaging"
 	"github.com/rivo/tview"
-	"github.com/tramhao/id3v2"
 	"github.com/ztrue/tracerr"
 	ugo "gitlab.com/diamondburned/ueberzug-go"
 
@@ -33,12 +33,14 @@ type PlayingBar struct {
 	skip             bool
 	text             *tview.TextView
 	hasTag           bool
-	tag              *id3v2.Tag
+	tag              player.Tag
 	subtitle         *lyric.Lyric
 	subtitles        []*lyric.Lyric
 	albumPhoto       *ugo.Image
 	albumPhotoSource image.Image
 	colrowPixel      int32
+	// live is a stream which has no end
+	live bool
 }
 
 func (p *PlayingBar) help() []string {
@@ -74,7 +76,7 @@ func (p *PlayingBar) run() error {
 		progress := p.getProgress()
 		full := p.getFull()
 
-		if progress > full || p.skip {
+		if (progress > full && !p.live) || p.skip {
 			p.skip = false
 			p.setProgress(0)
 			break
@@ -107,7 +109,15 @@ func (p *PlayingBar) run() error {
 			colrowPixel = rowPixel + colPixel
 		})
 
-		progressBar := progresStr(progress, full, width/2, "█", "━")
+		var progressBar string
+		if p.live {
+			progressBar = strings.Repeat("━", width/2)
+		} else {
+			progressBar = progresStr(progress, full, width/2, "█", "━")
+			if current := gomu.player.GetCurrentSong(); current != nil {
+				progressBar = markProgress(progressBar, full, songMarks(current.Path()))
+			}
+		}
 		if p.getColRowPixel() != colrowPixel {
 			p.updatePhoto()
 			p.setColRowPixel(colrowPixel)
@@ -121,17 +131,27 @@ func (p *PlayingBar) run() error {
 			}
 		}
 
+		speed := gomu.player.Speed()
+		endText := fmtDuration(end)
+		if p.live {
+			endText = "live"
+		}
+		if speed != 1 {
+			endText += fmt.Sprintf(" %gx", speed)
+		}
+
 		gomu.app.QueueUpdateDraw(func() {
 			p.text.SetText(fmt.Sprintf("%s ┃%s┫ %s\n\n[%s]%v[-]",
 				fmtDuration(start),
 				progressBar,
-				fmtDuration(end),
+				endText,
 				gomu.colors.subtitle,
 				lyricText,
 			))
 		})
 
-		<-time.After(time.Second)
+		// a second of the song passes faster when the speed is higher
+		<-time.After(time.Duration(float64(time.Second) / speed))
 	}
 
 	return nil
@@ -153,12 +173,19 @@ func (p *PlayingBar) newProgress(currentSong *player.AudioFile, full int) {
 	p.tag = nil
 	p.subtitles = nil
 	p.subtitle = nil
+	p.live = player.IsStream(currentSong.Path())
 	if p.albumPhoto != nil {
 		p.albumPhoto.Clear()
 		p.albumPhoto.Destroy()
 		p.albumPhoto = nil
 	}
 
+	// a stream has neither tags nor lyrics
+	if p.live {
+		p.setSongTitle(currentSong.Name())
+		return
+	}
+
 	err := p.loadLyrics(currentSong.Path())
 	if err != nil {
 		errorPopup(err)
@@ -279,17 +306,16 @@ func (p *PlayingBar) delayLyric(lyricDelay int) (err error) {
 func (p *PlayingBar) loadLyrics(currentSongPath string) error {
 	p.subtitles = nil
 
-	var tag *id3v2.Tag
-	var err error
-	tag, err = id3v2.Open(currentSongPath, id3v2.Options{Parse: true})
+	tag, err := player.OpenTag(currentSongPath)
+	// formats without tag simply have no lyric
+	if errors.Is(err, player.ErrTagNotSupported) {
+		return nil
+	}
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
 	defer tag.Close()
 
-	if tag == nil {
-		return nil
-	}
 	p.hasTag = true
 	p.tag = tag
 
@@ -299,41 +325,27 @@ func (p *PlayingBar) loadLyrics(currentSongPath string) error {
 		p.albumPhoto = nil
 	}
 
-	syltFrames := tag.GetFrames(tag.CommonID("Synchronised lyrics/text"))
-	usltFrames := tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
+	lyrics := tag.Lyrics()
+	langExts := make([]string, 0, len(lyrics))
+	for langExt := range lyrics {
+		langExts = append(langExts, langExt)
+	}
+	sort.Strings(langExts)
 
-	for _, f := range syltFrames {
-		sylf, ok := f.(id3v2.SynchronisedLyricsFrame)
-		if !ok {
-			return fmt.Errorf("sylt error")
-		}
-		for _, u := range usltFrames {
-			uslf, ok := u.(id3v2.UnsynchronisedLyricsFrame)
-			if !ok {
-				return errors.New("USLT error")
-			}
-			if sylf.ContentDescriptor == uslf.ContentDescriptor {
-				var lyric lyric.Lyric
-				err := lyric.NewFromLRC(uslf.Lyrics)
-				if err != nil {
-					return tracerr.Wrap(err)
-				}
-				lyric.SyncedCaptions = sylf.SynchronizedTexts
-				lyric.LangExt = sylf.ContentDescriptor
-				p.subtitles = append(p.subtitles, &lyric)
-			}
+	for _, langExt := range langExts {
+		var lyric lyric.Lyric
+		err := lyric.NewFromLRC(lyrics[langExt])
+		if err != nil {
+			return tracerr.Wrap(err)
 		}
+		lyric.LangExt = langExt
+		p.subtitles = append(p.subtitles, &lyric)
 	}
 
-	pictures := tag.GetFrames(tag.CommonID("Attached picture"))
-	for _, f := range pictures {
-		pic, ok := f.(id3v2.PictureFrame)
-		if !ok {
-			return errors.New("picture frame error")
-		}
+	for _, pic := range tag.Pictures() {
 
 		// Do something with picture frame.
-		imgTmp, err := imaging.Decode(bytes.NewReader(pic.Picture))
+		imgTmp, err := imaging.Decode(bytes.NewReader(pic.Data))
 		if err != nil {
 			return tracerr.Wrap(err)
 		}
@@ -22,7 +22,6 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	spin "github.com/tj/go-spin"
-	"github.com/tramhao/id3v2"
 	"github.com/ztrue/tracerr"
 
 	"github.com/issadarkthing/gomu/lyric"
@@ -39,6 +38,14 @@ type Playlist struct {
 	download int
 	done     chan struct{}
 	yankFile *player.AudioFile
+	view     browseView
+	// directory tree while a browse view is shown
+	dirRoot *tview.TreeNode
+	// identifies the nodes of the browse view across rebuilds
+	browseKeys map[*tview.TreeNode]string
+	lastQuery  string
+	// songs are sorted by their statistics unless it is orderDefault
+	sortOrder statsOrder
 }
 
 func (p *Playlist) help() []string {
@@ -47,7 +54,7 @@ func (p *Playlist) help() []string {
 		"j      down",
 		"k      up",
 		"h      close node",
-		"a      create a playlist",
+		"a      create a playlist or add a radio station",
 		"l      add song to queue",
 		"L      add playlist to queue",
 		"d      delete file from filesystem",
@@ -58,8 +65,14 @@ func (p *Playlist) help() []string {
 		"y/p    yank/paste file",
 		"/      find in playlist",
 		"s      search audio from youtube",
-		"t      edit mp3 tags",
+		"t      edit tags",
 		"1/2    find lyric if available",
+		"N      scan replaygain",
+		"o      load playlist file",
+		"v      switch browse view",
+		"Q      search by tags",
+		"*      rate song",
+		"O      sort songs by play statistics",
 	}
 
 }
@@ -69,18 +82,7 @@ func (p *Playlist) help() []string {
 func newPlaylist(args Args) *Playlist {
 
 	anko := gomu.anko
-
-	m := anko.GetString("General.music_dir")
-	rootDir, err := filepath.Abs(expandTilde(m))
-	if err != nil {
-		err = tracerr.Errorf("unable to find music directory: %e", err)
-		die(err)
-	}
-
-	// if not default value was given
-	if *args.music != "~/music" {
-		rootDir = expandFilePath(*args.music)
-	}
+	rootDir := getMusicDir(args)
 
 	var rootTextView string
 
@@ -121,7 +123,12 @@ func newPlaylist(args Args) *Playlist {
 		SetTitleAlign(tview.AlignLeft).
 		SetBorderPadding(0, 0, 1, 1)
 
-	populate(root, rootDir, gomu.anko.GetBool("General.sort_by_mtime"))
+	err := populateLibrary(root, rootDir, gomu.anko.GetBool("General.sort_by_mtime"))
+	if err != nil {
+		logError(err)
+	}
+
+	populateRadio(root)
 
 	var firstChild *tview.TreeNode
 
@@ -158,6 +165,12 @@ func newPlaylist(args Args) *Playlist {
 		't': "edit_tags",
 		'1': "fetch_lyric",
 		'2': "fetch_lyric_cn2",
+		'N': "replaygain_scan",
+		'o': "load_playlist_file",
+		'v': "switch_browse_view",
+		'Q': "query_search",
+		'*': "rate_song",
+		'O': "sort_playlist",
 	}
 
 	for key, cmdName := range cmds {
@@ -263,7 +276,16 @@ func (p *Playlist) addAllToQueue(root *tview.TreeNode) {
 
 	// gets the parent if the highlighted item is a file
 	if root.GetReference().(*player.AudioFile).IsAudioFile() {
-		childrens = root.GetReference().(*player.AudioFile).ParentNode().GetChildren()
+		childrens = p.parentNode(root).GetChildren()
+	}
+
+	// songs of the subgroups are added along with the group
+	if isBrowseGroup(root.GetReference().(*player.AudioFile)) {
+		childrens = nil
+		root.Walk(func(node, _ *tview.TreeNode) bool {
+			childrens = append(childrens, node)
+			return true
+		})
 	}
 
 	for _, v := range childrens {
@@ -283,14 +305,26 @@ func (p *Playlist) addAllToQueue(root *tview.TreeNode) {
 // Refreshes the playlist and read the whole root music dir
 func (p *Playlist) refresh() {
 
-	root := gomu.playlist.GetRoot()
-	prevNode := gomu.playlist.GetCurrentNode()
+	root := p.directoryRoot()
+	prevNode := p.GetCurrentNode()
 	prevFilepath := prevNode.GetReference().(*player.AudioFile).Path()
 
 	root.ClearChildren()
 	node := root.GetReference().(*player.AudioFile)
 
-	populate(root, node.Path(), gomu.anko.GetBool("General.sort_by_mtime"))
+	err := populateLibrary(root, node.Path(), gomu.anko.GetBool("General.sort_by_mtime"))
+	if err != nil {
+		logError(err)
+	}
+
+	populateRadio(root)
+
+	if p.view != browseDirectory {
+		p.rebuildBrowse(prevFilepath)
+		return
+	}
+
+	sortTreeSongs(root, p.sortOrder)
 
 	root.Walk(func(node, _ *tview.TreeNode) bool {
 
@@ -305,16 +339,39 @@ func (p *Playlist) refresh() {
 
 }
 
+// Gets the parent of the node in the tree shown, the parent of a song in a
+// browse view is its group rather than its directory
+func (p *Playlist) parentNode(node *tview.TreeNode) *tview.TreeNode {
+
+	if p.view == browseDirectory {
+		return node.GetReference().(*player.AudioFile).ParentNode()
+	}
+
+	var found *tview.TreeNode
+
+	p.GetRoot().Walk(func(n, parent *tview.TreeNode) bool {
+		if n == node {
+			found = parent
+		}
+		return found == nil
+	})
+
+	return found
+}
+
 // Adds child while setting reference to audio file
 func (p *Playlist) addSongToPlaylist(
 	audioPath string, selPlaylist *tview.TreeNode,
 ) error {
 
-	f, err := os.Open(audioPath)
+	format, err := player.DetectFileFormat(audioPath)
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
-	defer f.Close()
+
+	if !format.Playable() {
+		return tracerr.Wrap(player.ErrUnsupportedFormat)
+	}
 
 	songName := getName(audioPath)
 	node := tview.NewTreeNode(songName)
@@ -442,7 +499,7 @@ func (p *Playlist) rename(newName string) error {
 	pathToFile, _ := filepath.Split(audio.Path())
 	var newPath string
 	if audio.IsAudioFile() {
-		newPath = pathToFile + newName + ".mp3"
+		newPath = pathToFile + newName + filepath.Ext(audio.Path())
 	} else {
 		newPath = pathToFile + newName
 	}
@@ -451,7 +508,23 @@ func (p *Playlist) rename(newName string) error {
 		return tracerr.Wrap(err)
 	}
 
-	return nil
+	gomu.stats.rename(audio.Path(), newPath)
+
+	return tracerr.Wrap(gomu.bookmarks.rename(audio.Path(), newPath))
+}
+
+// Sorts the songs by the order, the default order is restored by populating
+// the tree again
+func (p *Playlist) setSortOrder(order statsOrder) {
+
+	p.sortOrder = order
+
+	if order == orderDefault {
+		p.refresh()
+		return
+	}
+
+	sortTreeSongs(p.GetRoot(), order)
 }
 
 // updateTitle creates a spinning motion on the title
@@ -568,13 +641,6 @@ func ytdl(url string, selPlaylist *tview.TreeNode) error {
 	}
 
 	// Embed lyric to mp3 as uslt
-	var tag *id3v2.Tag
-	tag, err = id3v2.Open(audioPath, id3v2.Options{Parse: true})
-	if err != nil {
-		return tracerr.Wrap(err)
-	}
-	defer tag.Close()
-
 	pathToFile, _ := filepath.Split(audioPath)
 	files, err := ioutil.ReadDir(pathToFile)
 	if err != nil {
@@ -651,69 +717,82 @@ func populate(root *tview.TreeNode, rootPath string, sortMtime bool) error {
 			continue
 		}
 
-		songName := getName(file.Name())
-		child := tview.NewTreeNode(songName)
+		populateFile(root, path, file, sortMtime)
+	}
 
-		if file.Mode().IsRegular() {
+	return nil
+}
 
-			f, err := os.Open(path)
-			if err != nil {
-				continue
-			}
-			defer f.Close()
+// Adds the node of the file to root, file is the info of path before
+// symlinks were evaluated. Returns nil if the file is not shown.
+func populateFile(root *tview.TreeNode, path string, file os.FileInfo, sortMtime bool) *tview.TreeNode {
 
-			filetype, err := getFileContentType(f)
+	songName := getName(file.Name())
+	child := tview.NewTreeNode(songName)
 
-			if err != nil {
-				continue
-			}
+	// playlist files are shown as directories of their songs
+	if file.Mode().IsRegular() && isPlaylistFile(path) {
 
-			// skip if not mp3 file
-			if filetype != "mpeg" {
-				continue
-			}
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(songName)
+		audioFile.SetPath(path)
+		audioFile.SetIsAudioFile(false)
+		audioFile.SetNode(child)
+		audioFile.SetParentNode(root)
 
-			audioFile := new(player.AudioFile)
-			audioFile.SetName(songName)
-			audioFile.SetPath(path)
-			audioFile.SetIsAudioFile(true)
-			audioFile.SetNode(child)
-			audioFile.SetParentNode(root)
+		child.SetReference(audioFile)
+		child.SetText(setDisplayText(audioFile))
+		root.AddChild(child)
+		populatePlaylistFile(child, path)
 
-			audioLength, err := getTagLength(audioFile.Path())
-			if err != nil {
-				logError(err)
-			}
-
-			audioFile.SetLen(audioLength)
+		return child
+	}
 
-			displayText := setDisplayText(audioFile)
+	if file.Mode().IsRegular() {
 
-			child.SetReference(audioFile)
-			child.SetText(displayText)
-			root.AddChild(child)
+		entry := gomu.library.lookup(path, file)
 
+		// skip if not a supported audio file
+		if !entry.Format.Playable() {
+			return nil
 		}
 
-		if file.IsDir() || file.Mode()&os.ModeSymlink != 0 {
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(songName)
+		audioFile.SetPath(path)
+		audioFile.SetIsAudioFile(true)
+		audioFile.SetNode(child)
+		audioFile.SetParentNode(root)
 
-			audioFile := new(player.AudioFile)
-			audioFile.SetName(songName)
-			audioFile.SetPath(path)
-			audioFile.SetIsAudioFile(false)
-			audioFile.SetNode(child)
-			audioFile.SetParentNode(root)
+		audioFile.SetLen(entry.Length)
 
-			displayText := setDisplayText(audioFile)
+		displayText := setDisplayText(audioFile)
 
-			child.SetReference(audioFile)
-			child.SetColor(gomu.colors.playlistDir)
-			child.SetText(displayText)
-			root.AddChild(child)
-			populate(child, path, sortMtime)
+		child.SetReference(audioFile)
+		child.SetText(displayText)
+		root.AddChild(child)
 
-		}
+		return child
+	}
+
+	if file.IsDir() || file.Mode()&os.ModeSymlink != 0 {
+
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(songName)
+		audioFile.SetPath(path)
+		audioFile.SetIsAudioFile(false)
+		audioFile.SetNode(child)
+		audioFile.SetParentNode(root)
 
+		displayText := setDisplayText(audioFile)
+
+		child.SetReference(audioFile)
+		child.SetColor(gomu.colors.playlistDir)
+		child.SetText(displayText)
+		root.AddChild(child)
+		populate(child, path, sortMtime)
+
+		return child
 	}
 
 	return nil
@@ -727,6 +806,18 @@ func (p *Playlist) yank() error {
 	if p.yankFile.Node() == p.GetRoot() {
 		return errors.New("please don't yank the root directory")
 	}
+	if isBrowseGroup(p.yankFile) {
+		p.yankFile = nil
+		return errBrowseGroup
+	}
+	if isRadio(p.yankFile) {
+		p.yankFile = nil
+		return errRadio
+	}
+	if isPlaylistEntry(p.yankFile) {
+		p.yankFile = nil
+		return errPlaylistEntry
+	}
 	defaultTimedPopup(" Success ", p.yankFile.Name()+"\n has been yanked successfully.")
 
 	return nil
@@ -740,6 +831,16 @@ func (p *Playlist) paste() error {
 	oldAudio := p.yankFile
 	oldPathDir, oldPathFileName := filepath.Split(p.yankFile.Path())
 	pasteFile := p.getCurrentFile()
+	if isBrowseGroup(pasteFile) {
+		return errBrowseGroup
+	}
+	if isRadio(pasteFile) {
+		return errRadio
+	}
+	if isPlaylistEntry(pasteFile) ||
+		(!pasteFile.IsAudioFile() && isPlaylistFile(pasteFile.Path())) {
+		return errPlaylistEntry
+	}
 	var newPathDir string
 	if pasteFile.IsAudioFile() {
 		newPathDir, _ = filepath.Split(pasteFile.Path())
@@ -0,0 +1,337 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// xspfPlaylist is the root element of a XSPF playlist
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	// duration in milliseconds
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+// errPlaylistEntry is returned by the commands managing files when a song
+// listed in a playlist file is highlighted, the song may be anywhere
+var errPlaylistEntry = errors.New("not available for the songs listed in a playlist file")
+
+// Checks whether the file is a playlist file which can be loaded
+func isPlaylistFile(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m3u", ".m3u8", ".pls", ".xspf":
+		return true
+	}
+	return false
+}
+
+// Converts a location read from a playlist file to an absolute path,
+// relative paths are resolved against the directory of the playlist file.
+// Streams are returned as they are.
+func resolvePlaylistEntry(dir, location string) string {
+
+	if player.IsStream(location) {
+		return location
+	}
+
+	if strings.HasPrefix(location, "file://") {
+		u, err := url.Parse(location)
+		if err == nil {
+			location = u.Path
+		}
+	}
+
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(dir, filepath.FromSlash(location))
+	}
+
+	return filepath.Clean(location)
+}
+
+// Reads the songs of a M3U, M3U8, PLS or XSPF playlist
+func readPlaylistFile(playlistPath string) ([]string, error) {
+
+	content, err := ioutil.ReadFile(playlistPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	locations, err := parsePlaylist(playlistPath, content)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	dir := filepath.Dir(playlistPath)
+	songs := make([]string, 0, len(locations))
+
+	for _, location := range locations {
+		songs = append(songs, resolvePlaylistEntry(dir, location))
+	}
+
+	return songs, nil
+}
+
+// Gets the locations listed in the content of the playlist, its format is
+// told by the extension of the name
+func parsePlaylist(name string, content []byte) ([]string, error) {
+
+	// byte order mark written by some editors
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u", ".m3u8":
+		return parseM3U(content), nil
+	case ".pls":
+		return parsePLS(content), nil
+	case ".xspf":
+		locations, err := parseXSPF(content)
+		return locations, tracerr.Wrap(err)
+	}
+
+	return nil, tracerr.Errorf("%s is not a playlist file", name)
+}
+
+// Every line which is not a comment or a directive is a song
+func parseM3U(content []byte) []string {
+
+	var locations []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		locations = append(locations, line)
+	}
+
+	return locations
+}
+
+// Songs are the FileN keys ordered by N
+func parsePLS(content []byte) []string {
+
+	files := make(map[int]string)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 || !strings.HasPrefix(strings.ToLower(line), "file") {
+			continue
+		}
+
+		n, err := strconv.Atoi(line[len("file"):i])
+		if err != nil {
+			continue
+		}
+
+		files[n] = strings.TrimSpace(line[i+1:])
+	}
+
+	keys := make([]int, 0, len(files))
+	for n := range files {
+		keys = append(keys, n)
+	}
+	sort.Ints(keys)
+
+	locations := make([]string, 0, len(keys))
+	for _, n := range keys {
+		locations = append(locations, files[n])
+	}
+
+	return locations
+}
+
+func parseXSPF(content []byte) ([]string, error) {
+
+	var playlist xspfPlaylist
+	if err := xml.Unmarshal(content, &playlist); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	var locations []string
+	for _, track := range playlist.Tracks {
+		location := strings.TrimSpace(track.Location)
+		if location == "" {
+			continue
+		}
+		// relative locations are escaped uris as well
+		if u, err := url.Parse(location); err == nil && u.Scheme == "" {
+			location = u.Path
+		}
+		locations = append(locations, location)
+	}
+
+	return locations, nil
+}
+
+// Writes the songs as a M3U8 or XSPF playlist depending on the extension.
+// Songs under the directory of the playlist are saved with relative paths so
+// that the directory can be moved.
+func writePlaylistFile(playlistPath string, songs []*player.AudioFile) error {
+
+	dir := filepath.Dir(playlistPath)
+
+	relPath := func(songPath string) string {
+		if player.IsStream(songPath) {
+			return songPath
+		}
+		rel, err := filepath.Rel(dir, songPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return songPath
+		}
+		return rel
+	}
+
+	var content bytes.Buffer
+
+	switch strings.ToLower(filepath.Ext(playlistPath)) {
+	case ".m3u", ".m3u8":
+
+		content.WriteString("#EXTM3U\n")
+		for _, song := range songs {
+			fmt.Fprintf(&content, "#EXTINF:%d,%s\n%s\n",
+				int(song.Len().Seconds()), song.Name(), relPath(song.Path()))
+		}
+
+	case ".xspf":
+
+		playlist := xspfPlaylist{
+			Version: "1",
+			Title:   getName(playlistPath),
+		}
+
+		for _, song := range songs {
+			// locations are uris, streams are already
+			location := song.Path()
+			if !player.IsStream(location) {
+				u := &url.URL{Path: filepath.ToSlash(relPath(location))}
+				if filepath.IsAbs(u.Path) {
+					u.Scheme = "file"
+				}
+				location = u.String()
+			}
+			playlist.Tracks = append(playlist.Tracks, xspfTrack{
+				Location: location,
+				Title:    song.Name(),
+				Duration: song.Len().Milliseconds(),
+			})
+		}
+
+		content.WriteString(xml.Header)
+		encoder := xml.NewEncoder(&content)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(playlist); err != nil {
+			return tracerr.Wrap(err)
+		}
+		content.WriteString("\n")
+
+	default:
+		return tracerr.Errorf("unable to save %s, use .m3u8 or .xspf", playlistPath)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err := ioutil.WriteFile(playlistPath, content.Bytes(), 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
+
+// Creates AudioFiles of the songs in the playlist file, missing songs are
+// skipped
+func loadPlaylistFile(
+	playlistPath string,
+	newAudioFile func(songPath string) (*player.AudioFile, error),
+) ([]*player.AudioFile, error) {
+
+	songs, err := readPlaylistFile(playlistPath)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	var audioFiles []*player.AudioFile
+
+	for _, songPath := range songs {
+
+		audioFile, err := newAudioFile(songPath)
+		if err != nil {
+			logError(err)
+			continue
+		}
+
+		audioFiles = append(audioFiles, audioFile)
+	}
+
+	return audioFiles, nil
+}
+
+// Checks whether the song is listed under a playlist file in the Playlist
+// panel rather than being a file of the directory
+func isPlaylistEntry(audioFile *player.AudioFile) bool {
+
+	if audioFile == nil || !audioFile.IsAudioFile() {
+		return false
+	}
+
+	parent := audioFile.Parent()
+
+	return parent != nil && !parent.IsAudioFile() && isPlaylistFile(parent.Path())
+}
+
+// Shows the songs of a playlist file as children of its node in the
+// Playlist panel, the node is collapsed until it is opened
+func populatePlaylistFile(node *tview.TreeNode, playlistPath string) {
+
+	node.SetColor(gomu.colors.playlistDir)
+	node.SetExpanded(false)
+
+	audioFiles, err := loadPlaylistFile(playlistPath, newAudioFile)
+	if err != nil {
+		logError(err)
+		return
+	}
+
+	for _, audioFile := range audioFiles {
+
+		child := tview.NewTreeNode("")
+		audioFile.SetNode(child)
+		audioFile.SetParentNode(node)
+
+		child.SetReference(audioFile)
+		child.SetText(setDisplayText(audioFile))
+		node.AddChild(child)
+	}
+}
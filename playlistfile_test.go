@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func TestReadPlaylistFile(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"list.m3u": "\xef\xbb\xbf#EXTM3U\n#EXTINF:61,A\na.mp3\n\n/abs/b.mp3\nfile:///abs/with%20space.mp3\nhttp://radio.example/stream\n",
+		"list.pls": "[playlist]\nFile2=/abs/b.mp3\nTitle1=A\nFile1=a.mp3\nNumberOfEntries=2\nVersion=2\n",
+		"list.xspf": `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track><location>sub/a%20b.mp3</location></track>
+    <track><location>file:///abs/b.mp3</location></track>
+  </trackList>
+</playlist>`,
+	}
+
+	expected := map[string][]string{
+		"list.m3u": {
+			filepath.Join(dir, "a.mp3"), "/abs/b.mp3", "/abs/with space.mp3",
+			"http://radio.example/stream",
+		},
+		"list.pls":  {filepath.Join(dir, "a.mp3"), "/abs/b.mp3"},
+		"list.xspf": {filepath.Join(dir, "sub", "a b.mp3"), "/abs/b.mp3"},
+	}
+
+	for name, content := range tests {
+
+		playlistPath := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(playlistPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readPlaylistFile(playlistPath)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if !Equal(got, expected[name]) {
+			t.Errorf("%s: expected %v; got %v", name, expected[name], got)
+		}
+	}
+}
+
+func TestWritePlaylistFile(t *testing.T) {
+
+	dir := t.TempDir()
+
+	var songs []*player.AudioFile
+	for _, songPath := range []string{
+		filepath.Join(dir, "music", "a b.mp3"), "/elsewhere/b.mp3",
+		"http://radio.example/128.mp3",
+	} {
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(getName(songPath))
+		audioFile.SetPath(songPath)
+		audioFile.SetLen(90 * time.Second)
+		songs = append(songs, audioFile)
+	}
+
+	for _, name := range []string{"queue.m3u8", "queue.xspf"} {
+
+		playlistPath := filepath.Join(dir, name)
+		if err := writePlaylistFile(playlistPath, songs); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readPlaylistFile(playlistPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{songs[0].Path(), songs[1].Path(), songs[2].Path()}
+		if !Equal(got, expected) {
+			t.Errorf("%s: expected %v; got %v", name, expected, got)
+		}
+	}
+
+	if err := writePlaylistFile(filepath.Join(dir, "queue.pls"), songs); err == nil {
+		t.Error("Expected error when saving as pls")
+	}
+}
+
+func TestIsPlaylistEntry(t *testing.T) {
+
+	newNode := func(songPath string, isAudioFile bool, parent *tview.TreeNode) *player.AudioFile {
+		node := tview.NewTreeNode(songPath)
+		audioFile := new(player.AudioFile)
+		audioFile.SetPath(songPath)
+		audioFile.SetIsAudioFile(isAudioFile)
+		audioFile.SetNode(node)
+		audioFile.SetParentNode(parent)
+		node.SetReference(audioFile)
+		return audioFile
+	}
+
+	dir := newNode("/music", false, nil)
+	playlist := newNode("/music/mix.m3u", false, dir.Node())
+
+	samples := []struct {
+		audioFile *player.AudioFile
+		expected  bool
+	}{
+		{newNode("/elsewhere/a.mp3", true, playlist.Node()), true},
+		{newNode("/music/b.mp3", true, dir.Node()), false},
+		{playlist, false},
+		{dir, false},
+		{nil, false},
+	}
+
+	for _, s := range samples {
+		if got := isPlaylistEntry(s.audioFile); got != s.expected {
+			t.Errorf("Expected %v for %v; got %v", s.expected, s.audioFile, got)
+		}
+	}
+}
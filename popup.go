@@ -257,6 +257,10 @@ func volumePopup(volume float64) {
 	defaultTimedPopup(" Volume ", progress)
 }
 
+func speedPopup(speed float64) {
+	defaultTimedPopup(" Speed ", fmt.Sprintf("\n%gx", speed))
+}
+
 // Shows a list of keybind. The upper list is the local keybindings to specific
 // panel only. The lower list is the global keybindings
 func helpPopup(panel Panel) {
@@ -269,15 +273,23 @@ func helpPopup(panel Panel) {
 		"space  toggle play/pause",
 		"esc    close popup",
 		"n      skip",
+		"P      previous",
+		"H      show history",
 		"q      quit",
 		"+      volume up",
 		"-      volume down",
+		"]/[    speed up/down",
+		"A      mark A-B repeat",
+		"i      add bookmark",
+		"g      show bookmarks",
 		"f/F    forward 10/60 seconds",
 		"b/B    rewind 10/60 seconds",
+		"J      seek to position",
 		"?      toggle help",
 		"m      open repl",
 		"T      switch lyrics",
 		"c      show colors",
+		"E      equalizer",
 	}
 
 	list := tview.NewList().ShowSecondaryText(false)
@@ -334,6 +346,315 @@ func helpPopup(panel Panel) {
 	gomu.popups.push(list)
 }
 
+// Lists the played songs, the last played first. Selected song is added to
+// the queue
+func historyPopup() {
+
+	popupID := "history-popup"
+	songs := gomu.queue.history.songs()
+
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBackgroundColor(gomu.colors.popup).SetTitle(" History ").
+		SetBorder(true)
+	list.SetSelectedBackgroundColor(gomu.colors.accent).
+		SetSelectedTextColor(gomu.colors.foreground)
+
+	for _, v := range songs {
+		text := fmt.Sprintf("[ %s ] %s", fmtDuration(v.Len()), v.Name())
+		list.AddItem(text, v.Path(), 0, nil)
+	}
+
+	if len(songs) == 0 {
+		list.AddItem("  no song played yet", "", 0, nil)
+	}
+
+	list.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			list.SetCurrentItem(list.GetCurrentItem() + 1)
+		case 'k':
+			list.SetCurrentItem(list.GetCurrentItem() - 1)
+		}
+
+		switch e.Key() {
+		case tcell.KeyEsc:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+		case tcell.KeyEnter:
+			if len(songs) == 0 {
+				break
+			}
+			audioFile := songs[list.GetCurrentItem()]
+			if _, err := gomu.queue.enqueue(audioFile); err != nil {
+				errorPopup(err)
+				break
+			}
+			defaultTimedPopup(" Queue ", audioFile.Name()+" added to queue")
+		}
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(list, 60, 20), true, true)
+	gomu.popups.push(list)
+}
+
+// Asks for the rating of the song from 1 to 5 stars
+func ratingPopup(audioFile *player.AudioFile) {
+
+	popupID := "rating-popup"
+
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBackgroundColor(gomu.colors.popup).SetTitle(" Rate " + audioFile.Name() + " ").
+		SetBorder(true)
+	list.SetSelectedBackgroundColor(gomu.colors.accent).
+		SetSelectedTextColor(gomu.colors.foreground)
+
+	// from 5 stars to no rating
+	for stars := 5; stars >= 0; stars-- {
+		text := strings.Repeat("★", stars) + strings.Repeat("☆", 5-stars)
+		if stars == 0 {
+			text = "no rating"
+		}
+		list.AddItem(text, "", 0, nil)
+	}
+
+	list.SetCurrentItem(5 - gomu.stats.rating(audioFile.Path()))
+
+	list.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			list.SetCurrentItem(list.GetCurrentItem() + 1)
+		case 'k':
+			list.SetCurrentItem(list.GetCurrentItem() - 1)
+		}
+
+		switch e.Key() {
+		case tcell.KeyEsc:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+		case tcell.KeyEnter:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+			if err := rateSong(audioFile.Path(), 5-list.GetCurrentItem()); err != nil {
+				errorPopup(err)
+			}
+		}
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(list, 40, 8), true, true)
+	gomu.popups.push(list)
+}
+
+// Asks for the order of the songs by their statistics
+func sortPopup(orders []statsOrder, handler func(order statsOrder)) {
+
+	popupID := "sort-popup"
+
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBackgroundColor(gomu.colors.popup).SetTitle(" Sort by ").
+		SetBorder(true)
+	list.SetSelectedBackgroundColor(gomu.colors.accent).
+		SetSelectedTextColor(gomu.colors.foreground)
+
+	for _, order := range orders {
+		list.AddItem(order.String(), "", 0, nil)
+	}
+
+	list.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			list.SetCurrentItem(list.GetCurrentItem() + 1)
+		case 'k':
+			list.SetCurrentItem(list.GetCurrentItem() - 1)
+		}
+
+		switch e.Key() {
+		case tcell.KeyEsc:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+		case tcell.KeyEnter:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+			handler(orders[list.GetCurrentItem()])
+		}
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(list, 30, len(orders)+2), true, true)
+	gomu.popups.push(list)
+}
+
+// Asks for a query over the tags and shows the matching songs
+func queryPopup() {
+
+	inputPopup("Query", gomu.playlist.lastQuery, func(text string) {
+
+		q, err := parseQuery(text)
+		if err != nil {
+			errorPopup(err)
+			return
+		}
+
+		gomu.playlist.lastQuery = text
+		tracks := q.filter(collectBrowseTracks(gomu.playlist.directoryRoot()))
+
+		// shown once the input popup is closed
+		go gomu.app.QueueUpdateDraw(func() {
+			queryResultsPopup(text, tracks)
+		})
+	})
+}
+
+// Shows the songs matching the query, which can be added to the queue one by
+// one or all at once, or saved as a smart playlist
+func queryResultsPopup(queryText string, tracks []browseTrack) {
+
+	popupID := "query-results-popup"
+
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBackgroundColor(gomu.colors.popup).
+		SetTitle(fmt.Sprintf(" %d songs ─ enter add, L add all, s save ", len(tracks))).
+		SetBorder(true)
+	list.SetSelectedBackgroundColor(gomu.colors.accent).
+		SetSelectedTextColor(gomu.colors.foreground)
+
+	for _, t := range tracks {
+		text := fmt.Sprintf("[ %s ] %s", fmtDuration(t.audioFile.Len()), t.audioFile.Name())
+		list.AddItem(text, t.audioFile.Path(), 0, nil)
+	}
+
+	if len(tracks) == 0 {
+		list.AddItem("  no matching song", "", 0, nil)
+	}
+
+	list.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
+
+		switch e.Rune() {
+		case 'j':
+			list.SetCurrentItem(list.GetCurrentItem() + 1)
+		case 'k':
+			list.SetCurrentItem(list.GetCurrentItem() - 1)
+		case 'L':
+			audioFiles := make([]*player.AudioFile, 0, len(tracks))
+			for _, t := range tracks {
+				audioFiles = append(audioFiles, t.audioFile)
+			}
+			gomu.queue.enqueueAll(audioFiles)
+			defaultTimedPopup(" Queue ", fmt.Sprintf("%d songs added to queue", len(audioFiles)))
+		case 's':
+			inputPopup("Smart playlist name", "", func(name string) {
+				playlist := smartPlaylist{Name: name, Query: queryText}
+				if err := addSmartPlaylist(getSmartPlaylistsPath(), playlist); err != nil {
+					errorPopup(err)
+					return
+				}
+				if gomu.playlist.view == browseSmart {
+					gomu.playlist.refresh()
+				}
+				defaultTimedPopup(" Success ", name+"\nhas been saved as a smart playlist")
+			})
+		}
+
+		switch e.Key() {
+		case tcell.KeyEsc:
+			gomu.pages.RemovePage(popupID)
+			gomu.popups.pop()
+		case tcell.KeyEnter:
+			if len(tracks) == 0 {
+				break
+			}
+			audioFile := tracks[list.GetCurrentItem()].audioFile
+			gomu.queue.enqueueAll([]*player.AudioFile{audioFile})
+			defaultTimedPopup(" Queue ", audioFile.Name()+" added to queue")
+		}
+
+		return nil
+	})
+
+	gomu.pages.AddPage(popupID, center(list, 60, 20), true, true)
+	gomu.popups.push(list)
+}
+
+// Confirmation popup for deleting a smart playlist, the songs are kept
+func deleteSmartPlaylistPopup(name string) {
+
+	confirmationPopup(
+		"Are you sure to delete the smart playlist "+name+"?",
+		func(_ int, label string) {
+
+			if label != "yes" {
+				return
+			}
+
+			if err := removeSmartPlaylist(getSmartPlaylistsPath(), name); err != nil {
+				errorPopup(err)
+				return
+			}
+
+			gomu.playlist.refresh()
+			defaultTimedPopup(" Success ", name+"\nhas been deleted successfully")
+		})
+}
+
+// Input popups for the url and the name of a station saved under the Radio
+// node, the playlist a station links to is resolved to its stream
+func addStationPopup() {
+
+	inputPopup("Station url", "", func(streamURL string) {
+
+		// shown once the input popup is closed
+		go gomu.app.QueueUpdateDraw(func() {
+
+			inputPopup("Station name", streamName(streamURL), func(name string) {
+
+				go func() {
+					resolved, err := resolveStream(streamURL)
+					if err == nil {
+						err = addStation(getRadioPath(), station{Name: name, URL: resolved})
+					}
+					if err != nil {
+						errorPopup(err)
+						gomu.app.Draw()
+						return
+					}
+
+					gomu.app.QueueUpdateDraw(gomu.playlist.refresh)
+					defaultTimedPopup(" Success ", name+"\nhas been added to the radio")
+					gomu.app.Draw()
+				}()
+			})
+		})
+	})
+}
+
+// Confirmation popup for deleting a station of the radio
+func deleteStationPopup(name string) {
+
+	confirmationPopup(
+		"Are you sure to delete the station "+name+"?",
+		func(_ int, label string) {
+
+			if label != "yes" {
+				return
+			}
+
+			if err := removeStation(getRadioPath(), name); err != nil {
+				errorPopup(err)
+				return
+			}
+
+			gomu.playlist.refresh()
+			defaultTimedPopup(" Success ", name+"\nhas been deleted successfully")
+		})
+}
+
 // Input popup. Takes video url from youtube to be downloaded
 func downloadMusicPopup(selPlaylist *tview.TreeNode) {
 
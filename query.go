@@ -0,0 +1,283 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+// queryTerm is a condition of a query, e.g. artist:radiohead or year:>1995.
+// A term without field matches the title, the artist, the album and the file
+// name.
+type queryTerm struct {
+	field  string
+	negate bool
+	// lowercased text of the text fields
+	text string
+	// comparison of the numeric fields, one of = < <= > >= or .. for ranges
+	op       string
+	min, max int64
+}
+
+// query is a structured search over the tags of the songs, all of its terms
+// must match
+type query struct {
+	terms []queryTerm
+}
+
+// fields compared as text, the value only has to be contained
+var queryTextFields = map[string]bool{
+	"title":  true,
+	"artist": true,
+	"album":  true,
+	"genre":  true,
+	"name":   true,
+	"path":   true,
+}
+
+// fields compared as numbers
+var queryNumericFields = map[string]bool{
+	"year":  true,
+	"track": true,
+	"len":   true,
+}
+
+// Parses queries such as `artist:radiohead year:>1995 len:<5m "karma"`.
+// Values containing spaces are quoted, terms starting with - are negated.
+func parseQuery(s string) (*query, error) {
+
+	tokens, err := splitQuery(s)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	q := &query{}
+
+	for _, token := range tokens {
+
+		term := queryTerm{}
+
+		if len(token) > 1 && token[0] == '-' {
+			term.negate = true
+			token = token[1:]
+		}
+
+		// a colon inside of quotes is part of the text
+		if i := strings.IndexByte(token, ':'); i > 0 && !strings.Contains(token[:i], `"`) {
+			term.field = strings.ToLower(token[:i])
+			token = token[i+1:]
+		}
+
+		value := strings.ReplaceAll(token, `"`, "")
+
+		if term.field == "length" {
+			term.field = "len"
+		}
+
+		switch {
+		case term.field == "" || queryTextFields[term.field]:
+			term.text = strings.ToLower(value)
+
+		case queryNumericFields[term.field]:
+			if err := term.parseComparison(value); err != nil {
+				return nil, tracerr.Wrap(err)
+			}
+
+		default:
+			return nil, tracerr.Errorf("unknown field %q", term.field)
+		}
+
+		q.terms = append(q.terms, term)
+	}
+
+	return q, nil
+}
+
+// Splits the query by spaces which are not quoted
+func splitQuery(s string) ([]string, error) {
+
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case (r == ' ' || r == '\t') && !quoted:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, tracerr.New("unterminated quote")
+	}
+
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// Parses comparisons such as >1995, <=5m, 1990..1999 or 6
+func (t *queryTerm) parseComparison(value string) error {
+
+	if i := strings.Index(value, ".."); i >= 0 {
+		min, err := t.parseNumber(value[:i])
+		if err != nil {
+			return err
+		}
+		max, err := t.parseNumber(value[i+2:])
+		if err != nil {
+			return err
+		}
+		t.op, t.min, t.max = "..", min, max
+		return nil
+	}
+
+	t.op = "="
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(value, op) {
+			t.op = op
+			value = value[len(op):]
+			break
+		}
+	}
+
+	n, err := t.parseNumber(value)
+	if err != nil {
+		return err
+	}
+
+	t.min, t.max = n, n
+
+	return nil
+}
+
+// Lengths are durations such as 5m, 3m30s or 3:30, or seconds
+func (t *queryTerm) parseNumber(value string) (int64, error) {
+
+	if t.field != "len" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, tracerr.Errorf("invalid number %q for %s", value, t.field)
+		}
+		return n, nil
+	}
+
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		min, errMin := strconv.Atoi(value[:i])
+		sec, errSec := strconv.Atoi(value[i+1:])
+		if errMin != nil || errSec != nil {
+			return 0, tracerr.Errorf("invalid length %q", value)
+		}
+		return int64(time.Duration(min)*time.Minute + time.Duration(sec)*time.Second), nil
+	}
+
+	if sec, err := strconv.Atoi(value); err == nil {
+		return int64(time.Duration(sec) * time.Second), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, tracerr.Errorf("invalid length %q", value)
+	}
+
+	return int64(d), nil
+}
+
+// Checks whether the song matches every term of the query
+func (q *query) match(t browseTrack) bool {
+	for _, term := range q.terms {
+		if term.match(t) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (term queryTerm) match(t browseTrack) bool {
+
+	title := t.entry.Title
+	if title == "" {
+		title = t.audioFile.Name()
+	}
+
+	switch term.field {
+	case "":
+		return containsFold(title, term.text) ||
+			containsFold(t.entry.Artist, term.text) ||
+			containsFold(t.entry.Album, term.text) ||
+			containsFold(t.audioFile.Name(), term.text)
+	case "title":
+		return containsFold(title, term.text)
+	case "artist":
+		return containsFold(t.entry.Artist, term.text)
+	case "album":
+		return containsFold(t.entry.Album, term.text)
+	case "genre":
+		return containsFold(t.entry.Genre, term.text)
+	case "name":
+		return containsFold(t.audioFile.Name(), term.text)
+	case "path":
+		return containsFold(t.audioFile.Path(), term.text)
+	case "year":
+		return term.compare(int64(t.entry.Year))
+	case "track":
+		return term.compare(int64(t.entry.TrackNumber))
+	case "len":
+		return term.compare(int64(t.audioFile.Len()))
+	}
+
+	return false
+}
+
+// Compares the value of the song, songs without the value never match
+func (term queryTerm) compare(n int64) bool {
+
+	if n <= 0 {
+		return false
+	}
+
+	switch term.op {
+	case "<":
+		return n < term.min
+	case "<=":
+		return n <= term.min
+	case ">":
+		return n > term.min
+	case ">=":
+		return n >= term.min
+	}
+
+	return n >= term.min && n <= term.max
+}
+
+func containsFold(s, lowerSubstr string) bool {
+	return strings.Contains(strings.ToLower(s), lowerSubstr)
+}
+
+// Gets the songs matching the query in their order
+func (q *query) filter(tracks []browseTrack) []browseTrack {
+
+	var matched []browseTrack
+
+	for _, t := range tracks {
+		if q.match(t) {
+			matched = append(matched, t)
+		}
+	}
+
+	return matched
+}
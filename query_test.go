@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func newQueryTrack(name string, length time.Duration, entry libraryEntry) browseTrack {
+	audioFile := new(player.AudioFile)
+	audioFile.SetName(name)
+	audioFile.SetPath("/music/" + name + ".mp3")
+	audioFile.SetIsAudioFile(true)
+	audioFile.SetLen(length)
+	return browseTrack{audioFile, &entry}
+}
+
+func TestQuery(t *testing.T) {
+
+	karma := newQueryTrack("karma police", 4*time.Minute+21*time.Second, libraryEntry{
+		Title: "Karma Police", Artist: "Radiohead", Album: "OK Computer",
+		Genre: "Alternative", Year: 1997, TrackNumber: 6,
+	})
+
+	creep := newQueryTrack("creep", 3*time.Minute+56*time.Second, libraryEntry{
+		Title: "Creep", Artist: "Radiohead", Album: "Pablo Honey", Year: 1993, TrackNumber: 2,
+	})
+
+	untagged := newQueryTrack("untitled", 6*time.Minute, libraryEntry{})
+
+	tests := []struct {
+		query    string
+		expected []browseTrack
+	}{
+		{`artist:radiohead year:>1995 len:<5m "karma"`, []browseTrack{karma}},
+		{`artist:radiohead`, []browseTrack{karma, creep}},
+		{`-artist:radiohead`, []browseTrack{untagged}},
+		{`year:1990..1995`, []browseTrack{creep}},
+		{`year:<=1997 track:>=6`, []browseTrack{karma}},
+		{`len:>5m`, []browseTrack{untagged}},
+		{`len:<4:00`, []browseTrack{creep}},
+		{`album:"ok computer"`, []browseTrack{karma}},
+		{`"karma police"`, []browseTrack{karma}},
+		{`untitled`, []browseTrack{untagged}},
+		{`genre:alt`, []browseTrack{karma}},
+		{``, []browseTrack{karma, creep, untagged}},
+	}
+
+	for _, test := range tests {
+
+		q, err := parseQuery(test.query)
+		if err != nil {
+			t.Errorf("parseQuery(%q); unexpected error %v", test.query, err)
+			continue
+		}
+
+		got := q.filter([]browseTrack{karma, creep, untagged})
+
+		if len(got) != len(test.expected) {
+			t.Errorf("%q; expected %d songs got %d", test.query, len(test.expected), len(got))
+			continue
+		}
+
+		for i := range got {
+			if got[i].audioFile != test.expected[i].audioFile {
+				t.Errorf("%q; expected %s got %s", test.query,
+					test.expected[i].audioFile.Name(), got[i].audioFile.Name())
+			}
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+
+	for _, query := range []string{
+		`color:blue`,
+		`year:nineties`,
+		`len:long`,
+		`artist:"daft punk`,
+	} {
+		if _, err := parseQuery(query); err == nil {
+			t.Errorf("parseQuery(%q); expected error", query)
+		}
+	}
+}
@@ -3,17 +3,10 @@
 package main
 
 import (
-	"bufio"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"os"
-	"path"
-	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -25,9 +18,7 @@ import (
 // Queue shows queued songs for playing
 type Queue struct {
 	*tview.List
-	savedQueuePath string
-	items          []*player.AudioFile
-	isLoop         bool
+	*songQueue
 }
 
 // Highlight the next item in the queue
@@ -60,19 +51,12 @@ func (q *Queue) deleteItem(index int) (*player.AudioFile, error) {
 	if index != -1 {
 		q.RemoveItem(index)
 
-		var nItems []*player.AudioFile
-
-		for i, v := range q.items {
-
-			if i == index {
-				dAudio = v
-				continue
-			}
-
-			nItems = append(nItems, v)
+		var err error
+		dAudio, err = q.remove(index)
+		if err != nil {
+			return nil, tracerr.Wrap(err)
 		}
 
-		q.items = nItems
 		// here we move to next item if not at the end
 		if index < len(q.items) {
 			q.next()
@@ -87,17 +71,11 @@ func (q *Queue) deleteItem(index int) (*player.AudioFile, error) {
 // Update queue title which shows number of items and total length
 func (q *Queue) updateTitle() string {
 
-	var totalLength time.Duration
-
-	for _, v := range q.items {
-		totalLength += v.Len()
-	}
-
-	fmtTime := fmtDurationH(totalLength)
+	fmtTime := fmtDurationH(q.totalLength())
 
 	var count string
 
-	if len(q.items) > 1 {
+	if q.length() > 1 {
 		count = "songs"
 	} else {
 		count = "song"
@@ -121,8 +99,16 @@ func (q *Queue) updateTitle() string {
 		}
 	}
 
+	if q.isShuffle {
+		loop += " | Shuffle"
+	}
+
+	if q.isAutoDJ {
+		loop += " | Auto DJ"
+	}
+
 	title := fmt.Sprintf("─ Queue ───┤ %d %s | %s | %s ├",
-		len(q.items), count, fmtTime, loop)
+		q.length(), count, fmtTime, loop)
 
 	q.SetTitle(title)
 
@@ -132,7 +118,7 @@ func (q *Queue) updateTitle() string {
 // Add item to the front of the queue
 func (q *Queue) pushFront(audioFile *player.AudioFile) {
 
-	q.items = append([]*player.AudioFile{audioFile}, q.items...)
+	q.addFront(audioFile)
 
 	songLength := audioFile.Len()
 
@@ -166,22 +152,65 @@ func (q *Queue) enqueue(audioFile *player.AudioFile) (int, error) {
 		return q.GetItemCount(), nil
 	}
 
-	q.items = append(q.items, audioFile)
-	songLength, err := getTagLength(audioFile.Path())
+	index := q.add(audioFile)
+	songLength, err := fmtSongLength(audioFile.Path())
 
 	if err != nil {
 		return 0, tracerr.Wrap(err)
 	}
 
 	queueItemView := fmt.Sprintf(
-		"[ %s ] %s", fmtDuration(songLength), getName(audioFile.Name()),
+		"[ %s ] %s", songLength, getName(audioFile.Name()),
 	)
-	q.AddItem(queueItemView, audioFile.Path(), 0, nil)
+	q.InsertItem(index, queueItemView, audioFile.Path(), 0, nil)
 	q.updateTitle()
 
 	return q.GetItemCount(), nil
 }
 
+// Formats the length of the song shown in the queue, a stream has none
+func fmtSongLength(songPath string) (string, error) {
+
+	if player.IsStream(songPath) {
+		return "--:--", nil
+	}
+
+	songLength, err := getTagLength(songPath)
+
+	return fmtDuration(songLength), tracerr.Wrap(err)
+}
+
+// Adds the songs to the queue and starts playing if nothing is playing
+func (q *Queue) enqueueAll(audioFiles []*player.AudioFile) {
+
+	for _, audioFile := range audioFiles {
+		if _, err := q.enqueue(audioFile); err != nil {
+			logError(err)
+		}
+	}
+
+	if len(q.items) > 0 && !gomu.player.IsRunning() {
+		if err := q.playQueue(); err != nil {
+			errorPopup(err)
+		}
+	}
+}
+
+// Appends the songs picked by the auto DJ when the queue runs low, songs are
+// taken from the playlist when possible
+func (q *Queue) runAutoDJ(current *player.AudioFile) {
+
+	if q.autoDJNeeded() == 0 {
+		return
+	}
+
+	q.fillAutoDJ(current, q.findAudioFile(), func(audioFile *player.AudioFile) {
+		if _, err := q.enqueue(audioFile); err != nil {
+			logError(err)
+		}
+	})
+}
+
 // getItems is used to get the secondary text
 // which is used to store the path of the audio file
 // this is for the sake of convenience
@@ -199,115 +228,61 @@ func (q *Queue) getItems() []string {
 	return items
 }
 
-// Save the current queue
+// Save the current queue and the state of the player
 func (q *Queue) saveQueue() error {
-
-	songPaths := q.getItems()
-	var content strings.Builder
-
-	if gomu.player.HasInit() && gomu.player.GetCurrentSong() != nil {
-		currentSongPath := gomu.player.GetCurrentSong().Path()
-		currentSongInQueue := false
-		for _, songPath := range songPaths {
-			if getName(songPath) == getName(currentSongPath) {
-				currentSongInQueue = true
-			}
-		}
-		if !currentSongInQueue && len(q.items) != 0 {
-			hashed := sha1Hex(getName(currentSongPath))
-			content.WriteString(hashed + "\n")
-		}
-	}
-
-	for _, songPath := range songPaths {
-		// hashed song name is easier to search through
-		hashed := sha1Hex(getName(songPath))
-		content.WriteString(hashed + "\n")
-	}
-
-	savedPath := expandTilde(q.savedQueuePath)
-	err := ioutil.WriteFile(savedPath, []byte(content.String()), 0644)
-
-	if err != nil {
-		return tracerr.Wrap(err)
-	}
-
-	return nil
-
+	return q.songQueue.saveQueue(getPlaybackState(gomu.player))
 }
 
 // Clears current queue
 func (q *Queue) clearQueue() {
 
-	q.items = []*player.AudioFile{}
+	q.clear()
 	q.Clear()
 	q.updateTitle()
 
 }
 
-// Loads previously saved list
-func (q *Queue) loadQueue() error {
+// Loads previously saved list, returns the state of the player to be
+// resumed
+func (q *Queue) loadQueue() (playbackState, error) {
 
-	songs, err := q.getSavedQueue()
-
-	if err != nil {
-		return tracerr.Wrap(err)
-	}
-
-	for _, v := range songs {
-
-		audioFile, err := gomu.playlist.findAudioFile(v)
-
-		if err != nil {
+	add := func(audioFile *player.AudioFile) {
+		if _, err := q.enqueue(audioFile); err != nil {
 			logError(err)
-			continue
-		}
-
-		q.enqueue(audioFile)
-	}
-
-	return nil
-}
-
-// Get saved queue, if not exist, create it
-func (q *Queue) getSavedQueue() ([]string, error) {
-
-	queuePath := expandTilde(q.savedQueuePath)
-
-	if _, err := os.Stat(queuePath); os.IsNotExist(err) {
-
-		dir, _ := path.Split(queuePath)
-		err := os.MkdirAll(dir, 0744)
-		if err != nil {
-			return nil, tracerr.Wrap(err)
-		}
-
-		_, err = os.Create(queuePath)
-		if err != nil {
-			return nil, tracerr.Wrap(err)
 		}
-
-		return []string{}, nil
-
 	}
 
-	f, err := os.Open(queuePath)
+	state, err := q.restoreQueue(getMusicDir(gomu.args), q.findAudioFile(), add)
 	if err != nil {
-		return nil, tracerr.Wrap(err)
+		return state, tracerr.Wrap(err)
 	}
 
-	records := []string{}
-	scanner := bufio.NewScanner(f)
+	q.updateTitle()
+
+	return state, nil
+}
 
-	for scanner.Scan() {
-		records = append(records, scanner.Text())
+// Returns a function finding the AudioFile of a path in the playlist, songs
+// outside of the playlist are created
+func (q *Queue) findAudioFile() func(songPath string) (*player.AudioFile, error) {
+
+	audioFiles := make(map[string]*player.AudioFile)
+	for _, v := range gomu.playlist.getAudioFiles() {
+		audioFiles[v.Path()] = v
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, tracerr.Wrap(err)
+	return func(songPath string) (*player.AudioFile, error) {
+		if audioFile, ok := audioFiles[songPath]; ok {
+			return audioFile, nil
+		}
+		return newAudioFile(songPath)
 	}
+}
 
-	return records, nil
+// Loads the play history of the previous session, songs are taken from the
+// playlist when possible
+func (q *Queue) loadHistory() error {
+	return q.history.load(q.findAudioFile())
 }
 
 func (q *Queue) help() []string {
@@ -320,6 +295,11 @@ func (q *Queue) help() []string {
 		"D      clear queue",
 		"z      toggle loop",
 		"s      shuffle",
+		"S      toggle shuffle",
+		"a      toggle auto dj",
+		"*      rate song",
+		"O      sort by play statistics",
+		"w      save queue as playlist file",
 		"/      find in queue",
 		"t      lyric delay increase 0.5 second",
 		"r      lyric delay decrease 0.5 second",
@@ -330,39 +310,42 @@ func (q *Queue) help() []string {
 // Shuffles the queue
 func (q *Queue) shuffle() {
 
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(q.items), func(i, j int) {
-		q.items[i], q.items[j] = q.items[j], q.items[i]
-	})
+	q.songQueue.shuffle()
+	q.redrawItems()
+
+	// q.updateTitle()
+
+}
+
+// Sorts the queue by the statistics of the songs, the highest first
+func (q *Queue) sortSongs(order statsOrder) {
+	q.sortBy(order)
+	q.redrawItems()
+}
+
+// Shows the items again after they have been reordered
+func (q *Queue) redrawItems() {
 
 	q.Clear()
 
-	for _, v := range q.items {
-		audioLen, err := getTagLength(v.Path())
+	for _, v := range q.songs() {
+		audioLen, err := fmtSongLength(v.Path())
 		if err != nil {
 			logError(err)
 		}
 
-		queueText := fmt.Sprintf("[ %s ] %s", fmtDuration(audioLen), v.Name())
+		queueText := fmt.Sprintf("[ %s ] %s", audioLen, v.Name())
 		q.AddItem(queueText, v.Path(), 0, nil)
 	}
-
-	// q.updateTitle()
-
 }
 
 // Initiliaze new queue with default values
 func newQueue() *Queue {
 
 	list := tview.NewList()
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		logError(err)
-	}
-	cacheQueuePath := filepath.Join(cacheDir, "gomu", "queue.cache")
 	queue := &Queue{
-		List:           list,
-		savedQueuePath: cacheQueuePath,
+		List:      list,
+		songQueue: newSongQueue(),
 	}
 
 	cmds := map[rune]string{
@@ -373,6 +356,11 @@ func newQueue() *Queue {
 		'l': "play_selected",
 		'z': "toggle_loop",
 		's': "shuffle_queue",
+		'S': "toggle_shuffle",
+		'a': "toggle_auto_dj",
+		'*': "rate_queued_song",
+		'O': "sort_queue",
+		'w': "save_queue_as",
 		'/': "queue_search",
 		't': "lyric_delay_increase",
 		'r': "lyric_delay_decrease",
@@ -441,13 +429,26 @@ func (q *Queue) renameItem(oldAudio *player.AudioFile, newAudio *player.AudioFil
 	return nil
 }
 
-// playQueue play the first item in the queue
+// playQueue play the first item in the queue, a stream is connected to in
+// the background
 func (q *Queue) playQueue() error {
 
 	audioFile, err := q.dequeue()
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
+
+	// connecting to a station may take a while, the ui is not blocked
+	if player.IsStream(audioFile.Path()) {
+		go func() {
+			if err := gomu.player.Run(audioFile); err != nil {
+				errorPopup(err)
+				gomu.app.Draw()
+			}
+		}()
+		return nil
+	}
+
 	err = gomu.player.Run(audioFile)
 	if err != nil {
 		return tracerr.Wrap(err)
@@ -463,28 +464,21 @@ func (q *Queue) insertItem(index int, audioFile *player.AudioFile) error {
 	}
 
 	if index != -1 {
-		songLength, err := getTagLength(audioFile.Path())
+		songLength, err := fmtSongLength(audioFile.Path())
 		if err != nil {
 			return tracerr.Wrap(err)
 		}
 		queueItemView := fmt.Sprintf(
-			"[ %s ] %s", fmtDuration(songLength), getName(audioFile.Name()),
+			"[ %s ] %s", songLength, getName(audioFile.Name()),
 		)
 
 		q.InsertItem(index, queueItemView, audioFile.Path(), 0, nil)
 
-		var nItems []*player.AudioFile
-
-		for i, v := range q.items {
-
-			if i == index {
-				nItems = append(nItems, audioFile)
-			}
-
-			nItems = append(nItems, v)
+		err = q.insert(index, audioFile)
+		if err != nil {
+			return tracerr.Wrap(err)
 		}
 
-		q.items = nItems
 		q.updateTitle()
 
 	}
@@ -492,32 +486,74 @@ func (q *Queue) insertItem(index int, audioFile *player.AudioFile) error {
 	return nil
 }
 
-// update the path information in queue
-func (q *Queue) updateQueuePath() {
+// pathRename is a file or a directory moved from oldPath to newPath
+type pathRename struct {
+	oldPath string
+	newPath string
+}
+
+// update the path information in queue, songs are looked up by their path
+// after the renames and then by their name as they may have been moved.
+// Songs which no longer exist are removed.
+func (q *Queue) updateQueuePath(renames ...pathRename) {
 
-	var songs []string
 	if len(q.items) < 1 {
 		return
 	}
-	for _, v := range q.items {
-		song := sha1Hex(getName(v.Name()))
-		songs = append(songs, song)
+
+	songs := q.songs()
+
+	audioFiles := make(map[string]*player.AudioFile)
+	for _, v := range gomu.playlist.getAudioFiles() {
+		if v.IsAudioFile() {
+			audioFiles[v.Path()] = v
+		}
 	}
 
+	// the order of the queue is kept
+	tmpShuffle := q.isShuffle
+	q.isShuffle = false
+
 	q.clearQueue()
 	for _, v := range songs {
 
-		audioFile, err := gomu.playlist.findAudioFile(v)
+		songPath := v.Path()
+		for _, r := range renames {
+			if isUnder(r.oldPath, songPath) {
+				songPath = r.newPath + strings.TrimPrefix(songPath, r.oldPath)
+			}
+		}
 
-		if err != nil {
-			continue
+		audioFile, ok := audioFiles[songPath]
+		if !ok {
+			var err error
+			audioFile, err = gomu.playlist.findAudioFile(sha1Hex(getName(v.Name())))
+			if err != nil {
+				// songs outside of the music directory
+				audioFile, err = newAudioFile(songPath)
+				if err != nil {
+					continue
+				}
+			}
 		}
+
 		q.enqueue(audioFile)
 	}
 
+	q.isShuffle = tmpShuffle
 	q.updateTitle()
 }
 
+// Checks whether a queued song is the file or is under the directory
+func (q *Queue) hasPathUnder(path string) bool {
+	for _, v := range q.songs() {
+		if isUnder(path, v.Path()) {
+			return true
+		}
+	}
+	return false
+}
+
 // update current playing song name to reflect the changes during rename and paste
 func (q *Queue) updateCurrentSongName(oldAudio *player.AudioFile, newAudio *player.AudioFile) error {
 
@@ -526,23 +562,17 @@ func (q *Queue) updateCurrentSongName(oldAudio *player.AudioFile, newAudio *play
 	}
 
 	currentSong := gomu.player.GetCurrentSong()
-	position := gomu.playingBar.getProgress()
-	paused := gomu.player.IsPaused()
 
 	if oldAudio.Name() != currentSong.Name() {
 		return nil
 	}
 
-	// we insert it in the first of queue, then play it
-	gomu.queue.pushFront(newAudio)
-	tmpLoop := q.isLoop
-	q.isLoop = false
-	gomu.player.Skip()
-	gomu.player.Seek(position)
-	if paused {
-		gomu.player.TogglePause()
+	// the renamed file continues from the same position
+	if err := gomu.player.Replace(newAudio); err != nil {
+		return tracerr.Wrap(err)
 	}
-	q.isLoop = tmpLoop
+
+	gomu.playingBar.setSongTitle(newAudio.Name())
 	q.updateTitle()
 
 	return nil
@@ -556,8 +586,6 @@ func (q *Queue) updateCurrentSongPath(oldAudio *player.AudioFile, newAudio *play
 	}
 
 	currentSong := gomu.player.GetCurrentSong()
-	position := gomu.playingBar.getProgress()
-	paused := gomu.player.IsPaused()
 
 	// Here we check the situation when currentsong is under oldAudio folder
 	if !strings.Contains(currentSong.Path(), oldAudio.Path()) {
@@ -569,15 +597,9 @@ func (q *Queue) updateCurrentSongPath(oldAudio *player.AudioFile, newAudio *play
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
-	gomu.queue.pushFront(currentSongAudioFile)
-	tmpLoop := q.isLoop
-	q.isLoop = false
-	gomu.player.Skip()
-	gomu.player.Seek(position)
-	if paused {
-		gomu.player.TogglePause()
+	if err := gomu.player.Replace(currentSongAudioFile); err != nil {
+		return tracerr.Wrap(err)
 	}
-	q.isLoop = tmpLoop
 
 	q.updateTitle()
 	return nil
@@ -608,13 +630,22 @@ func (q *Queue) updateCurrentSongDelete(oldAudio *player.AudioFile) {
 		return
 	}
 
-	tmpLoop := q.isLoop
-	q.isLoop = false
-	gomu.player.Skip()
+	// the deleted song is neither skipped nor finished
+	gomu.player.Stop()
 	if paused {
-		gomu.player.TogglePause()
+		// the next song starts paused
+		gomu.player.Pause()
 	}
-	q.isLoop = tmpLoop
+
+	if len(q.items) > 0 {
+		if err := q.playQueue(); err != nil {
+			logError(err)
+		}
+	} else {
+		gomu.playingBar.stop()
+		gomu.playingBar.setDefault()
+	}
+
 	q.updateTitle()
 
 }
@@ -0,0 +1,356 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// queueCacheVersion is increased whenever queueCache changes incompatibly
+const queueCacheVersion = 1
+
+// fingerprintSize is the number of bytes hashed from the start of a song
+const fingerprintSize = 64 * 1024
+
+// queueCacheEntry is a saved song, the fingerprint is used to find the song
+// after it has been moved
+type queueCacheEntry struct {
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// queueCache is the queue and the player state saved between sessions
+type queueCache struct {
+	// 0 for queues saved as hashed names by older versions
+	Version int              `json:"version"`
+	Current *queueCacheEntry `json:"current,omitempty"`
+	// position of the current song in seconds
+	Position float64           `json:"position"`
+	Paused   bool              `json:"paused"`
+	Loop     bool              `json:"loop"`
+	Shuffle  bool              `json:"shuffle"`
+	Volume   int               `json:"volume"`
+	Songs    []queueCacheEntry `json:"songs"`
+}
+
+// playbackState is the state of the player saved along with the queue
+type playbackState struct {
+	current  player.Audio
+	position time.Duration
+	paused   bool
+	volume   int
+}
+
+// Gets the state of the player, there is no current song when nothing is
+// playing
+func getPlaybackState(p *player.Player) playbackState {
+
+	state := playbackState{volume: player.VolToHuman(p.GetVolume())}
+
+	if p.HasInit() && (p.IsRunning() || p.IsPaused()) {
+		state.current = p.GetCurrentSong()
+		state.position = p.GetPosition()
+		state.paused = p.IsPaused()
+	}
+
+	return state
+}
+
+// Hashes the size and the beginning of the file
+func fingerprint(songPath string) (int64, string, error) {
+
+	f, err := os.Open(songPath)
+	if err != nil {
+		return 0, "", tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, "", tracerr.Wrap(err)
+	}
+
+	h := sha1.New()
+	if _, err := io.CopyN(h, f, fingerprintSize); err != nil && err != io.EOF {
+		return 0, "", tracerr.Wrap(err)
+	}
+
+	return info.Size(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Creates the saved entry of a song, streams are saved as their url
+func newQueueCacheEntry(songPath string) queueCacheEntry {
+
+	if player.IsStream(songPath) {
+		return queueCacheEntry{Path: songPath}
+	}
+
+	if abs, err := filepath.Abs(songPath); err == nil {
+		songPath = abs
+	}
+
+	entry := queueCacheEntry{Path: songPath}
+
+	size, hash, err := fingerprint(songPath)
+	if err != nil {
+		logError(err)
+		return entry
+	}
+
+	entry.Size = size
+	entry.Fingerprint = hash
+
+	return entry
+}
+
+// Saves the queue and the state of the player so that playing can be
+// resumed from the same position
+func (s *songQueue) saveQueue(state playbackState) error {
+
+	cache := queueCache{
+		Version:  queueCacheVersion,
+		Position: state.position.Seconds(),
+		Paused:   state.paused,
+		Loop:     s.isLoop,
+		Shuffle:  s.isShuffle,
+		Volume:   state.volume,
+		Songs:    []queueCacheEntry{},
+	}
+
+	if state.current != nil {
+		current := newQueueCacheEntry(state.current.Path())
+		cache.Current = &current
+	}
+
+	for _, song := range s.songs() {
+		cache.Songs = append(cache.Songs, newQueueCacheEntry(song.Path()))
+	}
+
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	savedPath := expandTilde(s.savedQueuePath)
+
+	if err := os.MkdirAll(filepath.Dir(savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = ioutil.WriteFile(savedPath, content, 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
+
+// Reads the saved queue, queues saved as hashed names by older versions are
+// converted using the music directory
+func (s *songQueue) getSavedQueue(finder *songFinder) (*queueCache, error) {
+
+	content, err := ioutil.ReadFile(expandTilde(s.savedQueuePath))
+	if os.IsNotExist(err) {
+		return &queueCache{Version: queueCacheVersion}, nil
+	}
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	cache := &queueCache{}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			songPath, err := finder.findByName(scanner.Text())
+			if err != nil {
+				logError(err)
+				continue
+			}
+			cache.Songs = append(cache.Songs, queueCacheEntry{Path: songPath})
+		}
+
+		return cache, nil
+	}
+
+	if err := json.Unmarshal(content, cache); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	if cache.Version > queueCacheVersion {
+		return nil, tracerr.Errorf("queue cache version %d is not supported", cache.Version)
+	}
+
+	return cache, nil
+}
+
+// songFinder finds the saved songs, songs which are not found at their saved
+// path are looked up in the music directory
+type songFinder struct {
+	musicDir string
+	// paths in the music directory, walked on the first song not found
+	bySize map[int64][]string
+	byName map[string]string
+}
+
+func newSongFinder(musicDir string) *songFinder {
+	return &songFinder{musicDir: musicDir}
+}
+
+func (f *songFinder) walk() {
+
+	if f.bySize != nil {
+		return
+	}
+
+	f.bySize = make(map[int64][]string)
+	f.byName = make(map[string]string)
+
+	filepath.Walk(f.musicDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() && player.HasSupportedExt(path) {
+			f.bySize[info.Size()] = append(f.bySize[info.Size()], path)
+			f.byName[sha1Hex(getName(path))] = path
+		}
+		return nil
+	})
+}
+
+// Finds the song by its hashed name as saved by older versions
+func (f *songFinder) findByName(hash string) (string, error) {
+
+	f.walk()
+
+	if songPath, ok := f.byName[hash]; ok {
+		return songPath, nil
+	}
+
+	return "", tracerr.New("no matching audio name")
+}
+
+// Finds the song at its saved path or by its fingerprint if it was moved
+func (f *songFinder) find(entry queueCacheEntry) (string, error) {
+
+	if player.IsStream(entry.Path) {
+		return entry.Path, nil
+	}
+
+	if info, err := os.Stat(entry.Path); err == nil && info.Mode().IsRegular() {
+		return entry.Path, nil
+	}
+
+	if entry.Fingerprint == "" {
+		return "", tracerr.Errorf("%s no longer exists", entry.Path)
+	}
+
+	f.walk()
+
+	for _, songPath := range f.bySize[entry.Size] {
+		_, hash, err := fingerprint(songPath)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		if hash == entry.Fingerprint {
+			return songPath, nil
+		}
+	}
+
+	return "", tracerr.Errorf("%s was moved or deleted", entry.Path)
+}
+
+// Restores the queue saved by the previous session. The songs are added with
+// add, the current song first. newAudioFile creates the AudioFile of a path.
+// Returns the state the player is resumed with, the volume is -1 for queues
+// saved by older versions.
+func (s *songQueue) restoreQueue(
+	musicDir string,
+	newAudioFile func(songPath string) (*player.AudioFile, error),
+	add func(*player.AudioFile),
+) (playbackState, error) {
+
+	state := playbackState{volume: -1}
+	finder := newSongFinder(musicDir)
+
+	cache, err := s.getSavedQueue(finder)
+	if err != nil {
+		return state, tracerr.Wrap(err)
+	}
+
+	restore := func(entry queueCacheEntry) *player.AudioFile {
+
+		songPath, err := finder.find(entry)
+		if err != nil {
+			logError(err)
+			return nil
+		}
+
+		audioFile, err := newAudioFile(songPath)
+		if err != nil {
+			logError(err)
+			return nil
+		}
+
+		add(audioFile)
+		return audioFile
+	}
+
+	if cache.Current != nil {
+		if current := restore(*cache.Current); current != nil {
+			state.current = current
+			state.position = time.Duration(cache.Position * float64(time.Second))
+			state.paused = cache.Paused
+		}
+	}
+
+	for _, entry := range cache.Songs {
+		restore(entry)
+	}
+
+	// older versions only saved the songs
+	if cache.Version > 0 {
+		s.isLoop = cache.Loop
+		s.isShuffle = cache.Shuffle
+		state.volume = cache.Volume
+	}
+
+	return state, nil
+}
+
+// Applies the restored state before the queue is played, the restored
+// current song is the first one played and starts at its position, paused
+// if it was, before any of it is heard
+func resumePlayback(state playbackState) {
+
+	p := gomu.player
+
+	if state.volume >= 0 {
+		p.SetVolume(player.AbsVolume(state.volume) - p.GetVolume())
+	}
+
+	if state.current == nil {
+		return
+	}
+
+	if state.position > 0 {
+		p.StartAt(state.position)
+	}
+
+	if state.paused {
+		p.Pause()
+	}
+}
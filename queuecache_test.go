@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// Creates songs with distinct content in the directory
+func writeTestSongs(t *testing.T, dir string, names ...string) []*player.AudioFile {
+
+	var songs []*player.AudioFile
+
+	for _, name := range names {
+		songPath := filepath.Join(dir, name+".mp3")
+		if err := ioutil.WriteFile(songPath, []byte("audio of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(name)
+		audioFile.SetPath(songPath)
+		audioFile.SetIsAudioFile(true)
+		songs = append(songs, audioFile)
+	}
+
+	return songs
+}
+
+func testAudioFile(songPath string) (*player.AudioFile, error) {
+	audioFile := new(player.AudioFile)
+	audioFile.SetName(getName(songPath))
+	audioFile.SetPath(songPath)
+	audioFile.SetIsAudioFile(true)
+	return audioFile, nil
+}
+
+func TestQueueCache(t *testing.T) {
+
+	musicDir := t.TempDir()
+	savedPath := filepath.Join(t.TempDir(), "gomu", "queue.cache")
+	songs := writeTestSongs(t, musicDir, "a", "b", "c")
+
+	// streams are saved as their url
+	stream, _ := testAudioFile("http://radio.example/128.mp3")
+
+	s := &songQueue{savedQueuePath: savedPath, isLoop: true}
+	s.add(songs[1])
+	s.add(songs[2])
+	s.add(stream)
+
+	err := s.saveQueue(playbackState{
+		current:  songs[0],
+		position: 42 * time.Second,
+		paused:   true,
+		volume:   80,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// moved songs are found by their fingerprint
+	if err := os.Mkdir(filepath.Join(musicDir, "moved"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	movedPath := filepath.Join(musicDir, "moved", "renamed.mp3")
+	if err := os.Rename(songs[2].Path(), movedPath); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &songQueue{savedQueuePath: savedPath}
+	state, err := restored.restoreQueue(musicDir, testAudioFile, func(a *player.AudioFile) {
+		restored.add(a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, song := range restored.songs() {
+		paths = append(paths, song.Path())
+	}
+
+	expected := []string{songs[0].Path(), songs[1].Path(), movedPath, stream.Path()}
+	if !Equal(paths, expected) {
+		t.Errorf("Expected %v; got %v", expected, paths)
+	}
+
+	if state.current == nil || state.current.Path() != songs[0].Path() {
+		t.Errorf("Expected current song a; got %v", state.current)
+	}
+
+	if state.position != 42*time.Second || !state.paused || state.volume != 80 {
+		t.Errorf("Unexpected playback state %+v", state)
+	}
+
+	if !restored.isLoop || restored.isShuffle {
+		t.Errorf("Expected loop without shuffle; got %v %v", restored.isLoop, restored.isShuffle)
+	}
+}
+
+func TestQueueCacheLegacy(t *testing.T) {
+
+	musicDir := t.TempDir()
+	savedPath := filepath.Join(t.TempDir(), "queue.cache")
+	writeTestSongs(t, musicDir, "a", "b")
+
+	// older versions saved the hashed names
+	content := sha1Hex("b") + "\n" + sha1Hex("missing") + "\n" + sha1Hex("a") + "\n"
+	if err := ioutil.WriteFile(savedPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &songQueue{savedQueuePath: savedPath}
+	state, err := s.restoreQueue(musicDir, testAudioFile, func(a *player.AudioFile) {
+		s.add(a)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := songNames(s.songs()); !Equal(got, []string{"b", "a"}) {
+		t.Errorf("Expected b a; got %v", got)
+	}
+
+	if state.current != nil || state.volume != -1 {
+		t.Errorf("Unexpected playback state %+v", state)
+	}
+
+}
@@ -0,0 +1,301 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// the playlist of a station is read up to this size
+const maxStationPlaylistSize = 1 << 20
+
+// the playlist of a station is given up on after this long
+const stationTimeout = 15 * time.Second
+
+// stationClient reads the playlists of the stations
+var stationClient = &http.Client{Timeout: stationTimeout}
+
+// errRadio is returned by the commands managing files when the Radio node or
+// a station is highlighted
+var errRadio = errors.New("not available for the radio, press a on the Radio node to add a station")
+
+// station is an internet radio saved under the Radio node of the playlist
+type station struct {
+	Name string
+	URL  string
+}
+
+func getRadioPath() string {
+	return expandTilde(gomu.anko.GetString("General.radio_path"))
+}
+
+// Reads the saved stations, they are kept as a M3U playlist with the name of
+// the station in the #EXTINF line. There are none if the file does not exist
+func loadStations(radioPath string) ([]station, error) {
+
+	content, err := ioutil.ReadFile(radioPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	var stations []station
+	name := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			if i := strings.IndexByte(line, ','); i >= 0 {
+				name = strings.TrimSpace(line[i+1:])
+			}
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if name == "" {
+			name = streamName(line)
+		}
+
+		stations = append(stations, station{Name: name, URL: line})
+		name = ""
+	}
+
+	return stations, nil
+}
+
+func saveStations(radioPath string, stations []station) error {
+
+	var content bytes.Buffer
+
+	content.WriteString("#EXTM3U\n")
+	for _, s := range stations {
+		fmt.Fprintf(&content, "#EXTINF:-1,%s\n%s\n", s.Name, s.URL)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(radioPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err := ioutil.WriteFile(radioPath, content.Bytes(), 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
+
+// Saves the station, a station with the same name is replaced
+func addStation(radioPath string, s station) error {
+
+	if !player.IsStream(s.URL) {
+		return tracerr.Errorf("%s is not a http or https url", s.URL)
+	}
+
+	if s.Name == "" {
+		s.Name = streamName(s.URL)
+	}
+
+	stations, err := loadStations(radioPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	replaced := false
+	for i, v := range stations {
+		if strings.EqualFold(v.Name, s.Name) {
+			stations[i] = s
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		stations = append(stations, s)
+	}
+
+	return tracerr.Wrap(saveStations(radioPath, stations))
+}
+
+func removeStation(radioPath string, name string) error {
+
+	stations, err := loadStations(radioPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	kept := stations[:0]
+	for _, s := range stations {
+		if !strings.EqualFold(s.Name, name) {
+			kept = append(kept, s)
+		}
+	}
+
+	return tracerr.Wrap(saveStations(radioPath, kept))
+}
+
+// Gets a name for a stream which is not a saved station from its url
+func streamName(streamURL string) string {
+
+	u, err := url.Parse(streamURL)
+	if err != nil || u.Host == "" {
+		return streamURL
+	}
+
+	return strings.TrimSuffix(u.Host+u.Path, "/")
+}
+
+// Gets the stream of a station. Many stations link to a M3U, PLS or XSPF
+// playlist rather than to the stream, it is resolved to the first stream it
+// lists
+func resolveStream(streamURL string) (string, error) {
+
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "", tracerr.Wrap(err)
+	}
+
+	if !isPlaylistFile(u.Path) {
+		return streamURL, nil
+	}
+
+	resp, err := stationClient.Get(streamURL)
+	if err != nil {
+		return "", tracerr.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", tracerr.Errorf("%s: %s", streamURL, resp.Status)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxStationPlaylistSize))
+	if err != nil {
+		return "", tracerr.Wrap(err)
+	}
+
+	// the segments of a HLS playlist are not streams
+	if bytes.Contains(content, []byte("#EXT-X-")) {
+		return "", tracerr.Errorf("%s is a HLS stream, which is not supported", streamURL)
+	}
+
+	locations, err := parsePlaylist(u.Path, content)
+	if err != nil {
+		return "", tracerr.Wrap(err)
+	}
+
+	for _, location := range locations {
+		if player.IsStream(location) {
+			return location, nil
+		}
+	}
+
+	return "", tracerr.Errorf("no stream found in %s", streamURL)
+}
+
+// Creates the AudioFile of a stream, it has the name of the station when it
+// is saved
+func newStreamAudioFile(streamURL string) *player.AudioFile {
+
+	name := streamName(streamURL)
+
+	stations, err := loadStations(getRadioPath())
+	if err != nil {
+		logError(err)
+	}
+
+	for _, s := range stations {
+		if s.URL == streamURL {
+			name = s.Name
+			break
+		}
+	}
+
+	audioFile := new(player.AudioFile)
+	audioFile.SetName(name)
+	audioFile.SetPath(streamURL)
+	audioFile.SetIsAudioFile(true)
+
+	return audioFile
+}
+
+// Adds the Radio node holding the saved stations to the root of the
+// playlist, the node is collapsed until it is opened
+func populateRadio(root *tview.TreeNode) {
+
+	radioPath := getRadioPath()
+
+	node := tview.NewTreeNode("")
+	node.SetColor(gomu.colors.playlistDir)
+	node.SetExpanded(false)
+
+	radio := new(player.AudioFile)
+	radio.SetName("Radio")
+	radio.SetPath(radioPath)
+	radio.SetNode(node)
+	radio.SetParentNode(root)
+
+	node.SetReference(radio)
+	node.SetText(setDisplayText(radio))
+	root.AddChild(node)
+
+	stations, err := loadStations(radioPath)
+	if err != nil {
+		logError(err)
+		return
+	}
+
+	for _, s := range stations {
+
+		child := tview.NewTreeNode("")
+
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(s.Name)
+		audioFile.SetPath(s.URL)
+		audioFile.SetIsAudioFile(true)
+		audioFile.SetNode(child)
+		audioFile.SetParentNode(node)
+
+		child.SetReference(audioFile)
+		child.SetText(setDisplayText(audioFile))
+		node.AddChild(child)
+	}
+}
+
+// Checks whether the file is the Radio node or a stream, which are not files
+// that can be managed
+func isRadio(audioFile *player.AudioFile) bool {
+
+	if audioFile == nil {
+		return false
+	}
+
+	if audioFile.IsAudioFile() {
+		return player.IsStream(audioFile.Path())
+	}
+
+	return audioFile.Path() == getRadioPath()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStations(t *testing.T) {
+
+	radioPath := filepath.Join(t.TempDir(), "gomu", "radio.m3u")
+
+	stations, err := loadStations(radioPath)
+	if err != nil || len(stations) != 0 {
+		t.Fatalf("Expected no stations; got %v %v", stations, err)
+	}
+
+	if err := addStation(radioPath, station{"Jazz", "http://jazz.example/stream"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// named after the url
+	if err := addStation(radioPath, station{"", "https://rock.example/live.ogg"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addStation(radioPath, station{"local", "/music/song.mp3"}); err == nil {
+		t.Error("Expected a file to be rejected")
+	}
+
+	// saving with the same name replaces the url
+	if err := addStation(radioPath, station{"jazz", "http://jazz.example/hq"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(radioPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(content), "#EXTM3U\n#EXTINF:-1,jazz\nhttp://jazz.example/hq\n") {
+		t.Errorf("Expected a M3U playlist; got %q", content)
+	}
+
+	stations, err = loadStations(radioPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []station{
+		{"jazz", "http://jazz.example/hq"},
+		{"rock.example/live.ogg", "https://rock.example/live.ogg"},
+	}
+
+	if fmt.Sprint(stations) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v; got %v", expected, stations)
+	}
+
+	if err := removeStation(radioPath, "JAZZ"); err != nil {
+		t.Fatal(err)
+	}
+
+	stations, err = loadStations(radioPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stations) != 1 || stations[0].Name != "rock.example/live.ogg" {
+		t.Errorf("Expected only the rock station to be left; got %v", stations)
+	}
+}
+
+func TestResolveStream(t *testing.T) {
+
+	playlists := map[string]string{
+		"/station.pls":  "[playlist]\nFile1=http://radio.example/128.mp3\nTitle1=Radio\nNumberOfEntries=1\n",
+		"/station.m3u":  "#EXTM3U\n#EXTINF:-1,Radio\nhttp://radio.example/128.mp3\n",
+		"/hls.m3u8":     "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\nsegment0.ts\n",
+		"/empty.pls":    "[playlist]\nNumberOfEntries=0\n",
+		"/missing.m3u8": "",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := playlists[r.URL.Path]
+		if content == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	samples := []struct {
+		url      string
+		expected string
+		fails    bool
+	}{
+		{server.URL + "/station.pls", "http://radio.example/128.mp3", false},
+		{server.URL + "/station.m3u", "http://radio.example/128.mp3", false},
+		// streams are not requested
+		{"http://radio.example/128.mp3", "http://radio.example/128.mp3", false},
+		{server.URL + "/hls.m3u8", "", true},
+		{server.URL + "/empty.pls", "", true},
+		{server.URL + "/missing.m3u8", "", true},
+	}
+
+	for _, s := range samples {
+
+		got, err := resolveStream(s.url)
+
+		if s.fails {
+			if err == nil {
+				t.Errorf("Expected %s to fail; got %s", s.url, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", s.url, err)
+			continue
+		}
+
+		if got != s.expected {
+			t.Errorf("Expected %s to resolve to %s; got %s", s.url, s.expected, got)
+		}
+	}
+}
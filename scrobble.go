@@ -0,0 +1,493 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/hook"
+	"github.com/issadarkthing/gomu/player"
+)
+
+const (
+	// songs shorter than this are never scrobbled
+	scrobbleMinLength = 30 * time.Second
+	// a song is scrobbled once half of it or this much was listened
+	scrobbleMaxListen = 4 * time.Minute
+	// pending listens are submitted again after this long when the server
+	// could not be reached
+	scrobbleRetryInterval = time.Minute
+	// listens submitted in one request
+	scrobbleBatchSize = 100
+)
+
+// listen is a song which has been listened, as written to the scrobbler log
+type listen struct {
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	Title       string `json:"title"`
+	TrackNumber int    `json:"track_number"`
+	// seconds
+	Length int `json:"length"`
+	// unix time the song started
+	ListenedAt int64 `json:"listened_at"`
+}
+
+// scrobbler records the listens to a rockbox .scrobbler.log and submits them
+// to a ListenBrainz compatible server. Listens which have not been submitted
+// are kept until the server is reachable.
+type scrobbler struct {
+	mu        sync.Mutex
+	logPath   string
+	queuePath string
+	// root of the ListenBrainz API, submission is disabled without a token
+	url    string
+	token  string
+	client *http.Client
+	// listens waiting to be submitted, oldest first
+	pending []listen
+	wake    chan struct{}
+
+	// the song being listened
+	current   *player.AudioFile
+	startedAt time.Time
+	skipped   bool
+	skippedAt time.Duration
+}
+
+func newScrobbler(logPath, queuePath, url, token string) *scrobbler {
+	return &scrobbler{
+		logPath:   logPath,
+		queuePath: queuePath,
+		url:       strings.TrimSuffix(url, "/"),
+		token:     token,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Checks whether the listen counts as a scrobble following the rules of
+// last.fm, half of the song or 4 minutes have to be listened
+func isScrobble(length, listened time.Duration) bool {
+
+	if length <= scrobbleMinLength {
+		return false
+	}
+
+	return listened*2 >= length || listened >= scrobbleMaxListen
+}
+
+// Loads the listens which were not submitted in the previous session
+func (s *scrobbler) load() error {
+
+	content, err := ioutil.ReadFile(s.queuePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	var pending []listen
+	if err := json.Unmarshal(content, &pending); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	s.mu.Lock()
+	s.pending = append(pending, s.pending...)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Saves the listens which have not been submitted yet
+func (s *scrobbler) save() error {
+
+	s.mu.Lock()
+	content, err := json.Marshal(s.pending)
+	s.mu.Unlock()
+
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.queuePath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return tracerr.Wrap(ioutil.WriteFile(s.queuePath, content, 0644))
+}
+
+// Starts listening to the song, the previous song is recorded
+func (s *scrobbler) start(audioFile *player.AudioFile, now time.Time) {
+	s.finish(false, 0)
+
+	s.mu.Lock()
+	s.current = audioFile
+	s.startedAt = now
+	s.skipped = false
+	s.mu.Unlock()
+}
+
+// Records the position the song is skipped at
+func (s *scrobbler) skip(position time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = true
+	s.skippedAt = position
+}
+
+// Records the song being listened. playing is true when the song has not
+// ended, position is how much of it has been listened then.
+func (s *scrobbler) finish(playing bool, position time.Duration) {
+
+	s.mu.Lock()
+	audioFile, startedAt := s.current, s.startedAt
+	skipped, skippedAt := s.skipped, s.skippedAt
+	s.current = nil
+	s.mu.Unlock()
+
+	if audioFile == nil {
+		return
+	}
+
+	listened := audioFile.Len()
+	if skipped {
+		listened = skippedAt
+	} else if playing {
+		listened = position
+	}
+
+	entry, ok := gomu.library.indexed(audioFile.Path())
+
+	// songs outside of the music directory are indexed once listened
+	if !ok {
+		if player.IsStream(audioFile.Path()) {
+			return
+		}
+		var err error
+		entry, err = gomu.library.lookupPath(audioFile.Path())
+		if err != nil {
+			logError(err)
+			return
+		}
+	}
+
+	if entry.Artist == "" || entry.Title == "" {
+		return
+	}
+
+	length := entry.Length
+	if length == 0 {
+		length = audioFile.Len()
+	}
+
+	l := listen{
+		Artist:      entry.Artist,
+		Album:       entry.Album,
+		Title:       entry.Title,
+		TrackNumber: entry.TrackNumber,
+		Length:      int(length.Seconds()),
+		ListenedAt:  startedAt.Unix(),
+	}
+
+	if err := s.record(l, isScrobble(length, listened)); err != nil {
+		logError(err)
+	}
+}
+
+// Appends the listen to the scrobbler log, scrobbles are queued to be
+// submitted
+func (s *scrobbler) record(l listen, scrobbled bool) error {
+
+	if scrobbled {
+		s.mu.Lock()
+		s.pending = append(s.pending, l)
+		s.mu.Unlock()
+
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return tracerr.Wrap(s.appendLog(l, scrobbled))
+}
+
+// Appends the listen to the log in the format of rockbox, which is accepted
+// by most last.fm uploaders
+func (s *scrobbler) appendLog(l listen, scrobbled bool) error {
+
+	if err := os.MkdirAll(filepath.Dir(s.logPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	f, err := os.OpenFile(s.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	var content strings.Builder
+
+	if info.Size() == 0 {
+		content.WriteString("#AUDIOSCROBBLER/1.1\n")
+		content.WriteString("#TZ/UTC\n")
+		fmt.Fprintf(&content, "#CLIENT/gomu %s\n", VERSION)
+	}
+
+	rating := "S"
+	if scrobbled {
+		rating = "L"
+	}
+
+	track := ""
+	if l.TrackNumber > 0 {
+		track = strconv.Itoa(l.TrackNumber)
+	}
+
+	// artist, album, title, track number, length, rating, timestamp and
+	// musicbrainz id
+	fields := []string{
+		l.Artist, l.Album, l.Title, track, strconv.Itoa(l.Length), rating,
+		strconv.FormatInt(l.ListenedAt, 10), "",
+	}
+
+	for i, field := range fields {
+		fields[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(field)
+	}
+
+	content.WriteString(strings.Join(fields, "\t") + "\n")
+
+	_, err = f.WriteString(content.String())
+
+	return tracerr.Wrap(err)
+}
+
+// listenBrainzSubmission is the body of submit-listens
+type listenBrainzSubmission struct {
+	ListenType string               `json:"listen_type"`
+	Payload    []listenBrainzListen `json:"payload"`
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64                `json:"listened_at"`
+	TrackMetadata listenBrainzMetadata `json:"track_metadata"`
+}
+
+type listenBrainzMetadata struct {
+	ArtistName     string                 `json:"artist_name"`
+	TrackName      string                 `json:"track_name"`
+	ReleaseName    string                 `json:"release_name,omitempty"`
+	AdditionalInfo map[string]interface{} `json:"additional_info"`
+}
+
+// Submits the pending listens, the listens are kept if the server cannot be
+// reached so that they are submitted again later
+func (s *scrobbler) submit() error {
+
+	if s.token == "" {
+		return nil
+	}
+
+	for {
+
+		s.mu.Lock()
+		batch := s.pending
+		if len(batch) > scrobbleBatchSize {
+			batch = batch[:scrobbleBatchSize]
+		}
+		batch = append([]listen(nil), batch...)
+		s.mu.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		err := s.post(batch)
+
+		// rejected listens would be rejected again
+		if err != nil && !isRejected(err) {
+			return tracerr.Wrap(err)
+		}
+
+		s.mu.Lock()
+		s.pending = s.pending[len(batch):]
+		s.mu.Unlock()
+
+		if err != nil {
+			logError(err)
+		}
+	}
+}
+
+// scrobbleRejected is returned when the server refuses the listens
+type scrobbleRejected struct {
+	status int
+	body   string
+}
+
+func (e *scrobbleRejected) Error() string {
+	return fmt.Sprintf("listens rejected with status %d: %s", e.status, e.body)
+}
+
+func isRejected(err error) bool {
+	var rejected *scrobbleRejected
+	return errors.As(err, &rejected)
+}
+
+func (s *scrobbler) post(batch []listen) error {
+
+	submission := listenBrainzSubmission{ListenType: "import"}
+	if len(batch) == 1 {
+		submission.ListenType = "single"
+	}
+
+	for _, l := range batch {
+
+		info := map[string]interface{}{
+			"duration_ms":               l.Length * 1000,
+			"media_player":              "gomu",
+			"submission_client":         "gomu",
+			"submission_client_version": VERSION,
+		}
+		if l.TrackNumber > 0 {
+			info["tracknumber"] = l.TrackNumber
+		}
+
+		submission.Payload = append(submission.Payload, listenBrainzListen{
+			ListenedAt: l.ListenedAt,
+			TrackMetadata: listenBrainzMetadata{
+				ArtistName:     l.Artist,
+				TrackName:      l.Title,
+				ReleaseName:    l.Album,
+				AdditionalInfo: info,
+			},
+		})
+	}
+
+	body, err := json.Marshal(submission)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	req.Header.Set("Authorization", "Token "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	message, _ := ioutil.ReadAll(res.Body)
+
+	// the token is wrong, the server is busy or down
+	if res.StatusCode == http.StatusUnauthorized ||
+		res.StatusCode == http.StatusTooManyRequests ||
+		res.StatusCode >= 500 {
+		return tracerr.Errorf("unable to submit listens, status %d: %s", res.StatusCode, message)
+	}
+
+	return tracerr.Wrap(&scrobbleRejected{res.StatusCode, string(message)})
+}
+
+// Submits the listens as they are recorded and the pending listens
+// periodically until done is closed
+func (s *scrobbler) run(done <-chan struct{}) {
+
+	ticker := time.NewTicker(scrobbleRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+
+		if err := s.submit(); err != nil {
+			logError(err)
+		}
+	}
+}
+
+// Records the listens through the new_song and exit hooks and the skips of
+// the player when General.scrobble is on
+func setupScrobbler(h *hook.EventHook, p *player.Player) {
+
+	if !gomu.anko.GetBool("General.scrobble") {
+		return
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logError(err)
+	}
+
+	s := newScrobbler(
+		expandTilde(gomu.anko.GetString("General.scrobbler_log")),
+		filepath.Join(cacheDir, "gomu", "scrobble.cache"),
+		gomu.anko.GetString("General.scrobble_url"),
+		gomu.anko.GetString("General.scrobble_token"),
+	)
+
+	if err := s.load(); err != nil {
+		logError(err)
+	}
+
+	done := make(chan struct{})
+	go s.run(done)
+
+	h.AddHook("new_song", func() {
+		if audioFile, ok := gomu.player.GetCurrentSong().(*player.AudioFile); ok {
+			s.start(audioFile, time.Now())
+		}
+	})
+
+	// the player has moved on by the time the skip hook runs
+	p.OnEvent(func(e player.Event) {
+		if e, ok := e.(player.SongSkipped); ok {
+			s.skip(e.Position)
+		}
+	})
+
+	h.AddHook("exit", func() {
+		close(done)
+		s.finish(
+			gomu.player.IsRunning() || gomu.player.IsPaused(),
+			gomu.player.GetPosition(),
+		)
+		if err := s.save(); err != nil {
+			logError(err)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsScrobble(t *testing.T) {
+
+	samples := []struct {
+		length, listened time.Duration
+		expected         bool
+	}{
+		{3 * time.Minute, 90 * time.Second, true},
+		{3 * time.Minute, 89 * time.Second, false},
+		{20 * time.Minute, 4 * time.Minute, true},
+		{20 * time.Minute, 3 * time.Minute, false},
+		{30 * time.Second, 30 * time.Second, false},
+	}
+
+	for _, s := range samples {
+		if got := isScrobble(s.length, s.listened); got != s.expected {
+			t.Errorf("isScrobble(%v, %v); expected %v got %v", s.length, s.listened, s.expected, got)
+		}
+	}
+}
+
+func TestScrobbler(t *testing.T) {
+
+	var mu sync.Mutex
+	var submissions []listenBrainzSubmission
+	status := http.StatusServiceUnavailable
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var submission listenBrainzSubmission
+		if err := json.NewDecoder(r.Body).Decode(&submission); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		submissions = append(submissions, submission)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, ".scrobbler.log")
+	queuePath := filepath.Join(dir, "scrobble.cache")
+
+	s := newScrobbler(logPath, queuePath, server.URL+"/", "secret")
+
+	karma := listen{Artist: "Radiohead", Album: "OK Computer", Title: "Karma Police", TrackNumber: 6, Length: 264, ListenedAt: 1000}
+	lucky := listen{Artist: "Radiohead", Album: "OK Computer", Title: "Lucky", Length: 259, ListenedAt: 2000}
+
+	if err := s.record(karma, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.record(lucky, false); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	expected := []string{
+		"#AUDIOSCROBBLER/1.1",
+		"#TZ/UTC",
+		"#CLIENT/gomu " + VERSION,
+		"Radiohead\tOK Computer\tKarma Police\t6\t264\tL\t1000\t",
+		"Radiohead\tOK Computer\tLucky\t\t259\tS\t2000\t",
+	}
+
+	if !Equal(lines, expected) {
+		t.Errorf("Expected log %q; got %q", expected, lines)
+	}
+
+	// the server is down, the listen is kept
+	if err := s.submit(); err == nil {
+		t.Error("Expected error when the server is unavailable")
+	}
+
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next session submits the listens left
+	s = newScrobbler(logPath, queuePath, server.URL, "secret")
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+
+	if err := s.submit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.pending) != 0 {
+		t.Errorf("Expected no listen left; got %v", s.pending)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(submissions) != 2 {
+		t.Fatalf("Expected 2 submissions; got %d", len(submissions))
+	}
+
+	last := submissions[1]
+	if last.ListenType != "single" || len(last.Payload) != 1 {
+		t.Fatalf("Expected a single listen; got %+v", last)
+	}
+
+	metadata := last.Payload[0].TrackMetadata
+	if last.Payload[0].ListenedAt != 1000 || metadata.ArtistName != "Radiohead" ||
+		metadata.TrackName != "Karma Police" || metadata.ReleaseName != "OK Computer" {
+		t.Errorf("Unexpected listen %+v", last.Payload[0])
+	}
+}
+
+func TestScrobblerRejected(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	s := newScrobbler(filepath.Join(dir, "log"), filepath.Join(dir, "cache"), server.URL, "secret")
+	s.pending = []listen{{Artist: "Radiohead", Title: "Airbag", Length: 284}}
+
+	// rejected listens are not submitted again
+	if err := s.submit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.pending) != 0 {
+		t.Errorf("Expected rejected listen to be dropped; got %v", s.pending)
+	}
+}
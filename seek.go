@@ -0,0 +1,130 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ztrue/tracerr"
+)
+
+// Moves the current song by the offset from the position of the player, the
+// song is not skipped by moving past its end
+func seekBy(offset time.Duration) {
+
+	if playingSong() == nil {
+		return
+	}
+
+	position := gomu.player.GetPosition() + offset
+	if position >= gomu.player.GetLength() {
+		return
+	}
+
+	seekTo(position)
+}
+
+// Moves the current song to the position, paused songs stay paused
+func seekTo(position time.Duration) {
+
+	if position < 0 {
+		position = 0
+	}
+
+	if err := gomu.player.Seek(position); err != nil {
+		errorPopup(err)
+		return
+	}
+
+	gomu.playingBar.setProgress(int(position.Seconds()))
+}
+
+// Parses the position to seek to in a song of the length. It is either a
+// timestamp such as 1:23 or 1:02:03, seconds, a duration such as 1m30s or a
+// percentage of the song such as 50%. A leading + or - moves from the
+// position instead.
+func parseSeekPosition(input string, position, length time.Duration) (time.Duration, error) {
+
+	input = strings.TrimSpace(input)
+
+	sign := 0
+	if strings.HasPrefix(input, "+") {
+		sign = 1
+	} else if strings.HasPrefix(input, "-") {
+		sign = -1
+	}
+	value := strings.TrimSpace(strings.TrimLeft(input, "+-"))
+
+	var offset time.Duration
+
+	switch {
+	case strings.HasSuffix(value, "%"):
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 {
+			return 0, tracerr.Errorf("invalid percentage %q", value)
+		}
+		offset = time.Duration(float64(length) * percent / 100)
+
+	case strings.Contains(value, ":"):
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return 0, tracerr.Errorf("invalid timestamp %q", value)
+		}
+		for i, part := range parts {
+			n, err := strconv.ParseFloat(part, 64)
+			// only the seconds may have a fraction
+			if err != nil || n < 0 || (i < len(parts)-1 && n != float64(int(n))) {
+				return 0, tracerr.Errorf("invalid timestamp %q", value)
+			}
+			offset = offset*60 + time.Duration(n*float64(time.Second))
+		}
+
+	default:
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err == nil && seconds >= 0 {
+			offset = time.Duration(seconds * float64(time.Second))
+			break
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, tracerr.Errorf("invalid position %q, expected e.g. 1:23 or 50%%", input)
+		}
+		offset = d
+	}
+
+	if sign != 0 {
+		offset = position + time.Duration(sign)*offset
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > length {
+		offset = length
+	}
+
+	return offset, nil
+}
+
+// Asks for the position to seek to in the current song
+func seekToPopup() {
+
+	if playingSong() == nil {
+		return
+	}
+
+	inputPopup("Seek to (1:23 or 50%)", "", func(input string) {
+
+		position, err := parseSeekPosition(
+			input, gomu.player.GetPosition(), gomu.player.GetLength(),
+		)
+		if err != nil {
+			errorPopup(err)
+			return
+		}
+
+		seekTo(position)
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSeekPosition(t *testing.T) {
+
+	position, length := time.Minute, 4*time.Minute
+
+	samples := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"1:23", 83 * time.Second},
+		{"01:02:03", 4 * time.Minute},
+		{"0:01.5", 1500 * time.Millisecond},
+		{"90", 90 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"50%", 2 * time.Minute},
+		{"12.5%", 30 * time.Second},
+		{"+30", 90 * time.Second},
+		{"-1:30", 0},
+		{"+ 10%", 84 * time.Second},
+		{"200%", length},
+	}
+
+	for _, s := range samples {
+		got, err := parseSeekPosition(s.input, position, length)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", s.input, err)
+			continue
+		}
+		if got != s.expected {
+			t.Errorf("Expected %q to be %v; got %v", s.input, s.expected, got)
+		}
+	}
+
+	for _, input := range []string{"", "abc", "1:x", "1.5:00", "1:2:3:4", "%"} {
+		if _, err := parseSeekPosition(input, position, length); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
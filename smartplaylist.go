@@ -0,0 +1,145 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+)
+
+// smartPlaylist is a saved query, its songs are evaluated again whenever the
+// library changes
+type smartPlaylist struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+func getSmartPlaylistsPath() string {
+	return expandTilde(gomu.anko.GetString("General.smart_playlists_path"))
+}
+
+// Reads the saved smart playlists, there are none if the file does not exist
+func loadSmartPlaylists(savedPath string) ([]smartPlaylist, error) {
+
+	content, err := ioutil.ReadFile(savedPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	var playlists []smartPlaylist
+	if err := json.Unmarshal(content, &playlists); err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	return playlists, nil
+}
+
+func saveSmartPlaylists(savedPath string, playlists []smartPlaylist) error {
+
+	content, err := json.MarshalIndent(playlists, "", "  ")
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = ioutil.WriteFile(savedPath, content, 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	return nil
+}
+
+// Saves the query as a smart playlist, a smart playlist with the same name is
+// replaced
+func addSmartPlaylist(savedPath string, playlist smartPlaylist) error {
+
+	if _, err := parseQuery(playlist.Query); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	playlists, err := loadSmartPlaylists(savedPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	replaced := false
+	for i, p := range playlists {
+		if strings.EqualFold(p.Name, playlist.Name) {
+			playlists[i] = playlist
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		playlists = append(playlists, playlist)
+	}
+
+	return tracerr.Wrap(saveSmartPlaylists(savedPath, playlists))
+}
+
+func removeSmartPlaylist(savedPath string, name string) error {
+
+	playlists, err := loadSmartPlaylists(savedPath)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	kept := playlists[:0]
+	for _, p := range playlists {
+		if !strings.EqualFold(p.Name, name) {
+			kept = append(kept, p)
+		}
+	}
+
+	return tracerr.Wrap(saveSmartPlaylists(savedPath, kept))
+}
+
+// Builds the tree of the smart playlists view, every smart playlist is a
+// group of the songs matching its query
+func buildSmartTree(
+	playlists []smartPlaylist, tracks []browseTrack,
+) (*tview.TreeNode, map[*tview.TreeNode]string) {
+
+	root := newBrowseGroup(browseSmart.String(), nil)
+	keys := map[*tview.TreeNode]string{root: ""}
+
+	for _, playlist := range playlists {
+
+		group := newBrowseGroup(playlist.Name, root)
+		root.AddChild(group)
+		keys[group] = "/" + strings.ToLower(playlist.Name)
+
+		q, err := parseQuery(playlist.Query)
+		if err != nil {
+			logError(err)
+			continue
+		}
+
+		for _, track := range q.filter(tracks) {
+			node := tview.NewTreeNode(setDisplayText(track.audioFile)).
+				SetReference(track.audioFile)
+			group.AddChild(node)
+			keys[node] = keys[group] + "/" + track.audioFile.Path()
+		}
+	}
+
+	return root, keys
+}
+
+// Checks whether the node is a smart playlist rather than one of its songs
+func (p *Playlist) isSmartPlaylist(node *tview.TreeNode) bool {
+	return p.view == browseSmart && node != p.GetRoot() && p.parentNode(node) == p.GetRoot()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSmartPlaylists(t *testing.T) {
+
+	savedPath := filepath.Join(t.TempDir(), "gomu", "smart_playlists")
+
+	playlists, err := loadSmartPlaylists(savedPath)
+	if err != nil || len(playlists) != 0 {
+		t.Fatalf("Expected no smart playlists; got %v %v", playlists, err)
+	}
+
+	if err := addSmartPlaylist(savedPath, smartPlaylist{"nineties", "year:1990..1999"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addSmartPlaylist(savedPath, smartPlaylist{"short", "len:<3m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid queries are not saved
+	if err := addSmartPlaylist(savedPath, smartPlaylist{"broken", "color:blue"}); err == nil {
+		t.Error("Expected invalid query to be rejected")
+	}
+
+	// saving with the same name replaces the query
+	if err := addSmartPlaylist(savedPath, smartPlaylist{"Nineties", "year:1990..1999 genre:rock"}); err != nil {
+		t.Fatal(err)
+	}
+
+	playlists, err = loadSmartPlaylists(savedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []smartPlaylist{
+		{"Nineties", "year:1990..1999 genre:rock"},
+		{"short", "len:<3m"},
+	}
+
+	if len(playlists) != len(expected) {
+		t.Fatalf("Expected %v; got %v", expected, playlists)
+	}
+
+	for i := range expected {
+		if playlists[i] != expected[i] {
+			t.Errorf("Expected %v; got %v", expected[i], playlists[i])
+		}
+	}
+
+	if err := removeSmartPlaylist(savedPath, "nineties"); err != nil {
+		t.Fatal(err)
+	}
+
+	playlists, err = loadSmartPlaylists(savedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(playlists) != 1 || playlists[0].Name != "short" {
+		t.Errorf("Expected only short to be left; got %v", playlists)
+	}
+}
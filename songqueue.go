@@ -0,0 +1,173 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// songQueue holds the queued songs without any view so that it can be used
+// without a screen. Queue shows it in the tui.
+type songQueue struct {
+	mu             sync.Mutex
+	savedQueuePath string
+	items          []*player.AudioFile
+	isLoop         bool
+	// songs are added in random positions
+	isShuffle bool
+	// songs are appended by the auto DJ when the queue runs low
+	isAutoDJ bool
+	dj       *autoDJ
+	history  *playHistory
+}
+
+// Initiliaze new song queue saved in the cache directory along with its
+// play history
+func newSongQueue() *songQueue {
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		logError(err)
+	}
+
+	return &songQueue{
+		savedQueuePath: filepath.Join(cacheDir, "gomu", "queue.cache"),
+		dj:             newAutoDJ(),
+		history: &playHistory{
+			savedPath: filepath.Join(cacheDir, "gomu", "history.cache"),
+		},
+	}
+}
+
+// Add item to the end of the queue or to a random position when shuffle is
+// on, returns the index of the item
+func (s *songQueue) add(audioFile *player.AudioFile) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := len(s.items)
+	if s.isShuffle {
+		index = rand.Intn(len(s.items) + 1)
+	}
+
+	s.items = append(s.items, nil)
+	copy(s.items[index+1:], s.items[index:])
+	s.items[index] = audioFile
+
+	return index
+}
+
+// Add item to the front of the queue
+func (s *songQueue) addFront(audioFile *player.AudioFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = append([]*player.AudioFile{audioFile}, s.items...)
+}
+
+// Inserts item before the given index
+func (s *songQueue) insert(index int, audioFile *player.AudioFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 0 || index > len(s.items) {
+		return tracerr.New("Index out of range")
+	}
+
+	s.items = append(s.items, nil)
+	copy(s.items[index+1:], s.items[index:])
+	s.items[index] = audioFile
+
+	return nil
+}
+
+// Removes the item at the given index and returns it
+func (s *songQueue) remove(index int) (*player.AudioFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index < 0 || index > len(s.items)-1 {
+		return nil, tracerr.New("Index out of range")
+	}
+
+	removed := s.items[index]
+	s.items = append(s.items[:index:index], s.items[index+1:]...)
+
+	return removed, nil
+}
+
+// Removes the first item and returns it
+func (s *songQueue) pop() (*player.AudioFile, error) {
+	if s.length() == 0 {
+		return nil, tracerr.New("Empty list")
+	}
+	return s.remove(0)
+}
+
+// Returns the first item or nil if the queue is empty
+func (s *songQueue) head() *player.AudioFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.items) == 0 {
+		return nil
+	}
+
+	return s.items[0]
+}
+
+func (s *songQueue) length() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// Returns a copy of the queued songs
+func (s *songQueue) songs() []*player.AudioFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*player.AudioFile(nil), s.items...)
+}
+
+// Sums the length of the queued songs
+func (s *songQueue) totalLength() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total time.Duration
+	for _, v := range s.items {
+		total += v.Len()
+	}
+
+	return total
+}
+
+func (s *songQueue) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = []*player.AudioFile{}
+}
+
+// Sorts the songs by their statistics, the highest first
+func (s *songQueue) sortBy(order statsOrder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order.sort(s.items)
+}
+
+func (s *songQueue) shuffle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rand.Seed(time.Now().UnixNano())
+	rand.Shuffle(len(s.items), func(i, j int) {
+		s.items[i], s.items[j] = s.items[j], s.items[i]
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func newTestSongs(names ...string) []*player.AudioFile {
+	var songs []*player.AudioFile
+	for _, name := range names {
+		audioFile := new(player.AudioFile)
+		audioFile.SetName(name)
+		audioFile.SetPath("/music/" + name + ".mp3")
+		audioFile.SetIsAudioFile(true)
+		songs = append(songs, audioFile)
+	}
+	return songs
+}
+
+func songNames(songs []*player.AudioFile) []string {
+	var names []string
+	for _, song := range songs {
+		names = append(names, song.Name())
+	}
+	return names
+}
+
+func TestSongQueue(t *testing.T) {
+
+	s := &songQueue{}
+	songs := newTestSongs("a", "b", "c", "d")
+
+	s.add(songs[1])
+	s.add(songs[2])
+	s.addFront(songs[0])
+
+	if err := s.insert(3, songs[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := songNames(s.songs()); !Equal(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Expected a b c d; got %v", got)
+	}
+
+	removed, err := s.remove(1)
+	if err != nil || removed != songs[1] {
+		t.Errorf("Expected b to be removed; got %v %v", removed, err)
+	}
+
+	if _, err := s.remove(3); err == nil {
+		t.Error("Expected error when removing out of range")
+	}
+
+	if head := s.head(); head != songs[0] {
+		t.Errorf("Expected head a; got %v", head)
+	}
+
+	popped, err := s.pop()
+	if err != nil || popped != songs[0] {
+		t.Errorf("Expected a to be popped; got %v %v", popped, err)
+	}
+
+	if got := songNames(s.songs()); !Equal(got, []string{"c", "d"}) {
+		t.Errorf("Expected c d; got %v", got)
+	}
+
+	s.clear()
+
+	if s.head() != nil || s.length() != 0 {
+		t.Error("Queue is not cleared")
+	}
+
+	if _, err := s.pop(); err == nil {
+		t.Error("Expected error when popping empty queue")
+	}
+}
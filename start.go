@@ -11,8 +11,8 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -39,6 +39,8 @@ type Args struct {
 	empty   *bool
 	music   *string
 	version *bool
+	daemon  *bool
+	socket  *string
 }
 
 func getArgs() Args {
@@ -56,12 +58,16 @@ func getArgs() Args {
 	musicPath := filepath.Join(home, "Music")
 	musicFlag := flag.String("music", musicPath, "Specify music directory")
 	versionFlag := flag.Bool("version", false, "Print gomu version")
+	daemonFlag := flag.Bool("daemon", false, "Run without the tui, controlled by gomu ctl")
+	socketFlag := flag.String("socket", defaultSocketPath(), "Specify control socket for daemon mode")
 	flag.Parse()
 	return Args{
 		config:  configFlag,
 		empty:   emptyFlag,
 		music:   musicFlag,
 		version: versionFlag,
+		daemon:  daemonFlag,
+		socket:  socketFlag,
 	}
 }
 
@@ -104,6 +110,12 @@ func defineInternals() {
 
 	player, _ := gomu.anko.NewModule("Player")
 	player.Define("current_audio", gomu.player.GetCurrentSong)
+	player.Define("state", func() string {
+		return gomu.player.State().String()
+	})
+	player.Define("stream_title", gomu.player.StreamTitle)
+
+	defineStats(gomu.anko)
 }
 
 func setupHooks(hook *hook.EventHook, anko *anko.Anko) {
@@ -234,7 +246,7 @@ module General {
 	load_prev_queue     = true
 	popup_timeout       = "5s"
 	sort_by_mtime       = false
-	# change this to directory that contains mp3 files
+	# change this to directory that contains audio files
 	music_dir           = "~/Music"
 	# url history of downloaded audio will be saved here
 	history_path        = "~/.local/share/gomu/urls"
@@ -254,6 +266,84 @@ module General {
 	lang_lyric          = "en"
 	# When save tag, could rename the file by tag info: artist-songname-album
 	rename_bytag        = false
+	# playback speed from 0.5 to 2, changed with '[' and ']'
+	speed               = 1
+	# keep the pitch when the speed changes, otherwise faster songs sound
+	# higher like a tape
+	preserve_pitch      = true
+	# fade out the ending song while fading in the next one, e.g. "3s"
+	crossfade           = "0s"
+	# normalize loudness using replaygain tags: "off", "track" or "album"
+	replaygain          = "off"
+	# gain in dB added on top of replaygain
+	replaygain_preamp   = 0
+	# lower the gain if the song would clip
+	replaygain_prevent_clip = true
+	# where the songs are played: "speaker", "null" to play without a sound
+	# card, "wav" to write a wav file, "pcm" to write raw 16 bit little
+	# endian stereo, e.g. gomu -daemon | aplay -f S16_LE -c 2 -r 48000, or
+	# "stream" to serve an icecast stream encoded by ffmpeg
+	output              = "speaker"
+	# file written by the wav and pcm outputs, pcm writes to stdout if empty
+	# which is only possible with -daemon
+	output_path         = ""
+	# address of the stream, listen with e.g. mpv http://localhost:8000
+	stream_address      = ":8000"
+	# "mp3" or "ogg"
+	stream_format       = "mp3"
+	# sample rate in Hz the songs are resampled to for the output
+	sample_rate         = 48000
+	# milliseconds of audio buffered by the output, raise it if the sound
+	# stutters
+	buffer_size         = 100
+	# control gomu with media keys and playerctl through dbus
+	mpris               = true
+	# previous restarts the current song once it has played this long
+	previous_restart    = "3s"
+	# update the playlist when files are changed by other programs
+	watch_music_dir     = true
+	# searches saved as smart playlists
+	smart_playlists_path = "~/.local/share/gomu/smart_playlists"
+	# stations shown under the Radio node of the playlist, a M3U playlist
+	radio_path          = "~/.local/share/gomu/radio.m3u"
+	# append songs from the library when the queue runs low
+	auto_dj             = false
+	# "random", "artist", "album", "genre", "least_recent" or "play_count"
+	auto_dj_strategy    = "random"
+	# songs are appended when the queue holds fewer songs than this
+	auto_dj_min_queue   = 3
+	# songs played or picked within this many songs are not picked again
+	auto_dj_repeat_window = 50
+	# plays, skips and ratings of the songs
+	stats_path          = "~/.local/share/gomu/stats"
+	# songs count as played once this percentage was listened, as skipped
+	# otherwise
+	play_count_percent  = 50
+	# named positions in the songs
+	bookmarks_path      = "~/.local/share/gomu/bookmarks"
+	# record listens to a rockbox .scrobbler.log and submit them to a
+	# ListenBrainz compatible server
+	scrobble            = false
+	scrobbler_log       = "~/.local/share/gomu/.scrobbler.log"
+	scrobble_url        = "https://api.listenbrainz.org"
+	# user token from https://listenbrainz.org/profile, listens are only
+	# logged without it
+	scrobble_token      = ""
+}
+
+module Equalizer {
+	# press 'E' to change the equalizer while playing
+	enabled             = false
+	# "flat", "bass", "treble", "rock", "pop", "jazz", "classical",
+	# "electronic", "vocal" or "loudness"
+	preset              = "flat"
+	# gains in dB of the 31, 62, 125, 250, 500, 1k, 2k, 4k, 8k and 16k Hz
+	# bands, from -12 to 12, used instead of the preset when given
+	gains               = []
+	# from -1 for the left speaker only to 1 for the right speaker only
+	balance             = 0
+	# mix both channels into each speaker
+	mono                = false
 }
 
 module Emoji {
@@ -336,6 +426,11 @@ func start(application *tview.Application, args Args) {
 		return
 	}
 
+	if *args.daemon {
+		runDaemon(args)
+		return
+	}
+
 	// Assigning to global variable gomu
 	gomu = newGomu()
 	gomu.command.defineCommands()
@@ -368,21 +463,31 @@ func start(application *tview.Application, args Args) {
 	tview.Styles.PrimitiveBackgroundColor = gomu.colors.popup
 
 	gomu.initPanels(application, args)
+	setupScrobbler(gomu.hook, gomu.player)
 	defineInternals()
 
 	gomu.player.SetSongStart(func(audio player.Audio) {
 
-		duration, err := getTagLength(audio.Path())
-		if err != nil || duration == 0 {
-			duration, err = player.GetLength(audio.Path())
-			if err != nil {
-				logError(err)
-				return
+		var duration time.Duration
+		var err error
+
+		// a stream has no length
+		if !player.IsStream(audio.Path()) {
+			duration, err = getTagLength(audio.Path())
+			if err != nil || duration == 0 {
+				duration, err = player.GetLength(audio.Path())
+				if err != nil {
+					logError(err)
+					return
+				}
 			}
 		}
 
 		audioFile := audio.(*player.AudioFile)
 
+		// the next song is preloaded from the head of the queue
+		gomu.queue.runAutoDJ(audioFile)
+
 		gomu.playingBar.newProgress(audioFile, int(duration.Seconds()))
 
 		name := audio.Name()
@@ -398,6 +503,8 @@ func start(application *tview.Application, args Args) {
 
 		defaultTimedPopup(" Now Playing ", description)
 
+		gomu.hook.RunHooks("new_song")
+
 		go func() {
 			err := gomu.playingBar.run()
 			if err != nil {
@@ -407,30 +514,77 @@ func start(application *tview.Application, args Args) {
 
 	})
 
+	// a station playing another song is a new song as well
+	gomu.player.OnEvent(func(e player.Event) {
+		if e, ok := e.(player.StreamTitleChanged); ok {
+			title := e.Song.Name()
+			if e.Title != "" {
+				title += " - " + e.Title
+			}
+			gomu.app.QueueUpdateDraw(func() {
+				gomu.playingBar.setSongTitle(title)
+			})
+			defaultTimedPopup(" Now Playing ", title)
+			gomu.hook.RunHooks("new_song")
+		}
+	})
+
+	// the player may have moved on, the position is the one skipped at
+	gomu.player.SetSongSkip(func(_ player.Audio, position time.Duration) {
+		gomu.stats.skip(position)
+		gomu.hook.RunHooks("skip")
+	})
+
 	gomu.player.SetSongFinish(func(currAudio player.Audio) {
 
-		gomu.playingBar.subtitles = nil
-		var mu sync.Mutex
-		mu.Lock()
-		gomu.playingBar.subtitle = nil
-		mu.Unlock()
-		if gomu.queue.isLoop {
-			_, err = gomu.queue.enqueue(currAudio.(*player.AudioFile))
-			if err != nil {
-				logError(err)
+		audioFile := currAudio.(*player.AudioFile)
+
+		// the callback runs on the goroutine of the player, the widgets and
+		// the queue are only changed on the ui goroutine
+		gomu.app.QueueUpdateDraw(func() {
+
+			gomu.playingBar.subtitles = nil
+			gomu.playingBar.subtitle = nil
+
+			recorded := recordFinished(gomu.queue.songQueue, audioFile)
+			if gomu.queue.isLoop && recorded {
+				if _, err := gomu.queue.enqueue(audioFile); err != nil {
+					logError(err)
+				}
 			}
-		}
 
-		if len(gomu.queue.items) > 0 {
-			err := gomu.queue.playQueue()
-			if err != nil {
-				logError(err)
+			gomu.queue.runAutoDJ(audioFile)
+
+			if len(gomu.queue.items) > 0 {
+				err := gomu.queue.playQueue()
+				if err != nil {
+					logError(err)
+				}
+			} else {
+				gomu.playingBar.setDefault()
 			}
-		} else {
-			gomu.playingBar.setDefault()
+		})
+	})
+
+	// the head of the queue is preloaded for gapless playback
+	gomu.player.SetNextSong(func() player.Audio {
+		if head := gomu.queue.head(); head != nil {
+			return head
 		}
+		return nil
 	})
 
+	setupMpris(tuiController{})
+
+	if gomu.anko.GetBool("General.watch_music_dir") {
+		watcher, err := newFsWatcher(getMusicDir(args))
+		if err != nil {
+			logError(err)
+		} else {
+			go gomu.playlist.watch(watcher)
+		}
+	}
+
 	flex := layout(gomu)
 	gomu.pages.AddPage("main", flex, true, true)
 
@@ -441,16 +595,25 @@ func start(application *tview.Application, args Args) {
 	gomu.playingBar.setDefault()
 
 	gomu.queue.isLoop = gomu.anko.GetBool("General.queue_loop")
+	gomu.queue.isAutoDJ = gomu.anko.GetBool("General.auto_dj")
 
 	loadQueue := gomu.anko.GetBool("General.load_prev_queue")
 
+	state := playbackState{volume: -1}
+
 	if !*args.empty && loadQueue {
 		// load saved queue from previous session
-		if err := gomu.queue.loadQueue(); err != nil {
+		state, err = gomu.queue.loadQueue()
+		if err != nil {
+			logError(err)
+		}
+		if err := gomu.queue.loadHistory(); err != nil {
 			logError(err)
 		}
 	}
 
+	resumePlayback(state)
+
 	if len(gomu.queue.items) > 0 {
 		if err := gomu.queue.playQueue(); err != nil {
 			logError(err)
@@ -477,6 +640,8 @@ func start(application *tview.Application, args Args) {
 		'-': "volume_down",
 		'_': "volume_down",
 		'n': "skip",
+		'P': "previous",
+		'H': "show_history",
 		':': "command_search",
 		'?': "toggle_help",
 		'f': "forward",
@@ -486,6 +651,13 @@ func start(application *tview.Application, args Args) {
 		'm': "repl",
 		'T': "switch_lyric",
 		'c': "show_colors",
+		'E': "equalizer",
+		']': "speed_up",
+		'[': "speed_down",
+		'A': "ab_repeat",
+		'i': "add_bookmark",
+		'g': "show_bookmarks",
+		'J': "seek_to",
 	}
 
 	for key, cmdName := range cmds {
@@ -555,4 +727,9 @@ func start(application *tview.Application, args Args) {
 	}
 
 	gomu.hook.RunHooks("exit")
+
+	// completes the file of the wav output
+	if err := gomu.player.Close(); err != nil {
+		logError(err)
+	}
 }
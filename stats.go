@@ -0,0 +1,400 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rivo/tview"
+	"github.com/ztrue/tracerr"
+
+	"github.com/issadarkthing/gomu/anko"
+	"github.com/issadarkthing/gomu/player"
+)
+
+// defaultPlayedPercent is used when play_count_percent is not set
+const defaultPlayedPercent = 50
+
+// songStats is the listening record of a song
+type songStats struct {
+	Plays int `json:"plays"`
+	Skips int `json:"skips"`
+	// unix time, 0 if never played
+	LastPlayed int64 `json:"last_played"`
+	// from 1 to 5 stars, 0 if unrated
+	Rating int `json:"rating"`
+}
+
+// playStats records the plays, the skips and the ratings of the songs by
+// path
+type playStats struct {
+	mu        sync.Mutex
+	savedPath string
+	songs     map[string]*songStats
+	dirty     bool
+	// the position the current song was skipped at, the song finishes right
+	// after being skipped
+	skipped   bool
+	skippedAt time.Duration
+}
+
+func newPlayStats() *playStats {
+	return &playStats{songs: make(map[string]*songStats)}
+}
+
+func getStatsPath() string {
+	return expandTilde(gomu.anko.GetString("General.stats_path"))
+}
+
+// Gets the percentage of a song which has to be listened for it to count as
+// played rather than skipped, configs written before play_count_percent
+// existed get the default
+func getPlayedPercent() int {
+
+	// an unset key is an error rather than 0
+	if _, err := gomu.anko.Execute("General.play_count_percent"); err != nil {
+		return defaultPlayedPercent
+	}
+
+	percent := gomu.anko.GetInt("General.play_count_percent")
+
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+
+	return percent
+}
+
+// Loads the statistics saved at the path, which is where they are saved
+// afterwards
+func (s *playStats) load(savedPath string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.savedPath = savedPath
+
+	content, err := ioutil.ReadFile(savedPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	songs := make(map[string]*songStats)
+	if err := json.Unmarshal(content, &songs); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	s.songs = songs
+
+	return nil
+}
+
+// Saves the statistics if they have changed
+func (s *playStats) save() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty || s.savedPath == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(s.songs)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.savedPath), 0744); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	err = ioutil.WriteFile(s.savedPath, content, 0644)
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	s.dirty = false
+
+	return nil
+}
+
+// Gets a copy of the statistics of the song
+func (s *playStats) get(songPath string) songStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stats, ok := s.songs[songPath]; ok {
+		return *stats
+	}
+
+	return songStats{}
+}
+
+// must be called with the lock held
+func (s *playStats) entry(songPath string) *songStats {
+
+	stats, ok := s.songs[songPath]
+	if !ok {
+		stats = &songStats{}
+		s.songs[songPath] = stats
+	}
+
+	s.dirty = true
+
+	return stats
+}
+
+// Records the position the current song is skipped at
+func (s *playStats) skip(position time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped = true
+	s.skippedAt = position
+}
+
+// Records the song which has finished, it counts as played when at least
+// percent of it was listened and as skipped otherwise. Nothing is counted if
+// count is false.
+func (s *playStats) finish(
+	songPath string, length time.Duration, percent int, count bool, now time.Time,
+) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listened := length
+	if s.skipped {
+		listened = s.skippedAt
+	}
+	s.skipped = false
+
+	if !count {
+		return
+	}
+
+	stats := s.entry(songPath)
+
+	if length <= 0 || listened*100 >= length*time.Duration(percent) {
+		stats.Plays++
+		stats.LastPlayed = now.Unix()
+	} else {
+		stats.Skips++
+	}
+}
+
+// Gets the rating of the song, the rating of its tag is used when it has not
+// been rated in gomu
+func (s *playStats) rating(songPath string) int {
+
+	if stars := s.get(songPath).Rating; stars > 0 {
+		return stars
+	}
+
+	if entry, ok := gomu.library.indexed(songPath); ok {
+		return entry.Rating
+	}
+
+	return 0
+}
+
+func (s *playStats) setRating(songPath string, stars int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(songPath).Rating = stars
+}
+
+// Moves the statistics of the renamed file or of the files under the renamed
+// directory
+func (s *playStats) rename(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	moved := make(map[string]*songStats)
+
+	for songPath, stats := range s.songs {
+		if isUnder(oldPath, songPath) {
+			delete(s.songs, songPath)
+			moved[newPath+songPath[len(oldPath):]] = stats
+		}
+	}
+
+	for songPath, stats := range moved {
+		s.songs[songPath] = stats
+		s.dirty = true
+	}
+}
+
+// Records the song which has finished playing in the play history and in the
+// statistics. The song left by going back to the previous song is in neither,
+// false is returned for it so that it is not looped either.
+func recordFinished(queue *songQueue, audioFile *player.AudioFile) bool {
+	recorded := queue.history.push(audioFile)
+	gomu.stats.finish(
+		audioFile.Path(), audioFile.Len(), getPlayedPercent(), recorded, time.Now(),
+	)
+	return recorded
+}
+
+// Rates the song from 1 to 5 stars, 0 removes the rating. The rating is
+// written to the tag of the song as well when its format has one.
+func rateSong(songPath string, stars int) error {
+
+	if stars < 0 || stars > 5 {
+		return tracerr.Errorf("rating must be from 0 to 5 stars, got %d", stars)
+	}
+
+	gomu.stats.setRating(songPath, stars)
+
+	tag, err := player.OpenTag(songPath)
+	if errors.Is(err, player.ErrTagNotSupported) {
+		return nil
+	}
+	if err != nil {
+		return tracerr.Wrap(err)
+	}
+	defer tag.Close()
+
+	tag.SetRating(stars)
+
+	if err := tag.Save(); err != nil {
+		return tracerr.Wrap(err)
+	}
+
+	// the file has changed
+	_, err = gomu.library.lookupPath(songPath)
+
+	return tracerr.Wrap(err)
+}
+
+// statsOrder orders songs by their statistics
+type statsOrder int
+
+const (
+	// the order of the directory or of the browse view
+	orderDefault statsOrder = iota
+	orderPlays
+	orderSkips
+	orderRating
+	orderLastPlayed
+)
+
+func (o statsOrder) String() string {
+	switch o {
+	case orderPlays:
+		return "play count"
+	case orderSkips:
+		return "skip count"
+	case orderRating:
+		return "rating"
+	case orderLastPlayed:
+		return "last played"
+	}
+	return "default"
+}
+
+// Gets the value songs are ordered by, the highest first
+func (o statsOrder) value(songPath string) int64 {
+	switch o {
+	case orderPlays:
+		return int64(gomu.stats.get(songPath).Plays)
+	case orderSkips:
+		return int64(gomu.stats.get(songPath).Skips)
+	case orderRating:
+		return int64(gomu.stats.rating(songPath))
+	case orderLastPlayed:
+		return gomu.stats.get(songPath).LastPlayed
+	}
+	return 0
+}
+
+// Sorts the songs by the order, the highest first. Songs with the same value
+// keep their order.
+func (o statsOrder) sort(songs []*player.AudioFile) {
+
+	if o == orderDefault {
+		return
+	}
+
+	values := make(map[*player.AudioFile]int64, len(songs))
+	for _, song := range songs {
+		values[song] = o.value(song.Path())
+	}
+
+	sort.SliceStable(songs, func(i, j int) bool {
+		return values[songs[i]] > values[songs[j]]
+	})
+}
+
+// Sorts the songs among the children of every node of the tree, directories
+// and groups stay where they are. Songs of playlist files keep the order of
+// the playlist file.
+func sortTreeSongs(root *tview.TreeNode, order statsOrder) {
+
+	if order == orderDefault {
+		return
+	}
+
+	root.Walk(func(node, _ *tview.TreeNode) bool {
+
+		audioFile := node.GetReference().(*player.AudioFile)
+		if audioFile.IsAudioFile() || isPlaylistFile(audioFile.Path()) {
+			return false
+		}
+
+		children := node.GetChildren()
+
+		var slots []int
+		var songs []*tview.TreeNode
+		values := make(map[*tview.TreeNode]int64)
+
+		for i, child := range children {
+			song := child.GetReference().(*player.AudioFile)
+			if song.IsAudioFile() {
+				slots = append(slots, i)
+				songs = append(songs, child)
+				values[child] = order.value(song.Path())
+			}
+		}
+
+		sort.SliceStable(songs, func(i, j int) bool {
+			return values[songs[i]] > values[songs[j]]
+		})
+
+		for k, i := range slots {
+			children[i] = songs[k]
+		}
+
+		return true
+	})
+}
+
+// Defines the Stats module so that the config is able to use the statistics,
+// e.g. Stats.play_count(Player.current_audio().Path())
+func defineStats(env *anko.Anko) {
+	stats, _ := env.NewModule("Stats")
+	stats.Define("play_count", func(songPath string) int {
+		return gomu.stats.get(songPath).Plays
+	})
+	stats.Define("skip_count", func(songPath string) int {
+		return gomu.stats.get(songPath).Skips
+	})
+	// unix time, 0 if never played
+	stats.Define("last_played", func(songPath string) int64 {
+		return gomu.stats.get(songPath).LastPlayed
+	})
+	stats.Define("rating", gomu.stats.rating)
+	stats.Define("rate", rateSong)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+func TestPlayStats(t *testing.T) {
+
+	s := newPlayStats()
+	now := time.Unix(1000, 0)
+
+	// finished without being skipped
+	s.finish("/music/a.mp3", time.Minute, 50, true, now)
+
+	// skipped after the threshold counts as played
+	s.skip(40 * time.Second)
+	s.finish("/music/b.mp3", time.Minute, 50, true, now)
+
+	s.skip(10 * time.Second)
+	s.finish("/music/c.mp3", time.Minute, 50, true, now)
+
+	// going back to the previous song is neither
+	s.skip(10 * time.Second)
+	s.finish("/music/d.mp3", time.Minute, 50, false, now)
+
+	// the skip is not carried over to the next song
+	s.finish("/music/d.mp3", time.Minute, 50, true, now)
+
+	expected := map[string]songStats{
+		"/music/a.mp3": {Plays: 1, LastPlayed: 1000},
+		"/music/b.mp3": {Plays: 1, LastPlayed: 1000},
+		"/music/c.mp3": {Skips: 1},
+		"/music/d.mp3": {Plays: 1, LastPlayed: 1000},
+	}
+
+	for path, want := range expected {
+		if got := s.get(path); got != want {
+			t.Errorf("Expected %s to be %+v; got %+v", path, want, got)
+		}
+	}
+
+	s.rename("/music", "/songs")
+
+	if got := s.get("/songs/c.mp3"); got.Skips != 1 {
+		t.Errorf("Expected stats to be moved on rename; got %+v", got)
+	}
+
+	if got := s.get("/music/c.mp3"); got != (songStats{}) {
+		t.Errorf("Expected no stats left at the old path; got %+v", got)
+	}
+
+	savedPath := filepath.Join(t.TempDir(), "gomu", "stats")
+	s.savedPath = savedPath
+
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newPlayStats()
+	if err := loaded.load(savedPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loaded.get("/songs/a.mp3"); got.Plays != 1 || got.LastPlayed != 1000 {
+		t.Errorf("Expected saved stats to be loaded; got %+v", got)
+	}
+}
+
+func TestPlayedPercent(t *testing.T) {
+
+	gomu = newGomu()
+
+	// configs written before the key existed
+	if _, err := gomu.anko.Execute("module General {\n volume = 80\n}"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getPlayedPercent(); got != defaultPlayedPercent {
+		t.Errorf("Expected %d%% when unset; got %d%%", defaultPlayedPercent, got)
+	}
+
+	if _, err := gomu.anko.Execute("module General {\n play_count_percent = 0\n}"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getPlayedPercent(); got != 0 {
+		t.Errorf("Expected 0%% when set to 0; got %d%%", got)
+	}
+}
+
+func TestStatsOrder(t *testing.T) {
+
+	gomu = newGomu()
+
+	songs := newTestSongs("a", "b", "c", "d")
+
+	gomu.stats.songs["/music/b.mp3"] = &songStats{Plays: 3, Rating: 2}
+	gomu.stats.songs["/music/c.mp3"] = &songStats{Plays: 5, Rating: 4}
+	gomu.stats.songs["/music/d.mp3"] = &songStats{Plays: 3, Rating: 5}
+
+	orderPlays.sort(songs)
+
+	if got := songNames(songs); !Equal(got, []string{"c", "b", "d", "a"}) {
+		t.Errorf("Expected c b d a; got %v", got)
+	}
+
+	root := tview.NewTreeNode("music")
+	dir := new(player.AudioFile)
+	dir.SetPath("/music")
+	root.SetReference(dir)
+
+	sub := tview.NewTreeNode("sub")
+	subDir := new(player.AudioFile)
+	subDir.SetPath("/music/sub")
+	sub.SetReference(subDir)
+
+	for i, song := range newTestSongs("a", "b", "c", "d") {
+		// directories stay where they are
+		if i == 2 {
+			root.AddChild(sub)
+		}
+		root.AddChild(tview.NewTreeNode(song.Name()).SetReference(song))
+	}
+
+	sortTreeSongs(root, orderRating)
+
+	var got []string
+	for _, child := range root.GetChildren() {
+		got = append(got, child.GetText())
+	}
+
+	if !Equal(got, []string{"d", "c", "sub", "b", "a"}) {
+		t.Errorf("Expected d c sub b a; got %v", got)
+	}
+}
@@ -5,12 +5,12 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
-	"github.com/tramhao/id3v2"
 	"github.com/ztrue/tracerr"
 
 	"github.com/issadarkthing/gomu/lyric"
@@ -38,6 +38,8 @@ func tagPopup(node *player.AudioFile) (err error) {
 		artistInputField  *tview.InputField = tview.NewInputField()
 		titleInputField   *tview.InputField = tview.NewInputField()
 		albumInputField   *tview.InputField = tview.NewInputField()
+		genreInputField   *tview.InputField = tview.NewInputField()
+		yearInputField    *tview.InputField = tview.NewInputField()
 		getTagButton      *tview.Button     = tview.NewButton("Get Tag")
 		saveTagButton     *tview.Button     = tview.NewButton("Save Tag")
 		lyricDropDown     *tview.DropDown   = tview.NewDropDown()
@@ -64,6 +66,22 @@ func tagPopup(node *player.AudioFile) (err error) {
 		SetText(tag.Album()).
 		SetFieldBackgroundColor(gomu.colors.popup)
 
+	genreInputField.SetLabel("Genre:  ").
+		SetFieldWidth(20).
+		SetText(tag.Genre()).
+		SetFieldBackgroundColor(gomu.colors.popup)
+
+	var year string
+	if tag.Year() > 0 {
+		year = strconv.Itoa(tag.Year())
+	}
+
+	yearInputField.SetLabel("Year:   ").
+		SetFieldWidth(20).
+		SetText(year).
+		SetAcceptanceFunc(tview.InputFieldInteger).
+		SetFieldBackgroundColor(gomu.colors.popup)
+
 	leftBox := tview.NewBox().
 		SetBorder(true).
 		SetTitle(node.Name()).
@@ -105,7 +123,7 @@ func tagPopup(node *player.AudioFile) (err error) {
 					titleInputField.SetText(newTag.Title)
 					albumInputField.SetText(newTag.Album)
 
-					tag, err = id3v2.Open(node.Path(), id3v2.Options{Parse: true})
+					tag, err = player.OpenTag(node.Path())
 					if err != nil {
 						errorPopup(err)
 						return
@@ -150,10 +168,7 @@ func tagPopup(node *player.AudioFile) (err error) {
 		SetTitleColor(gomu.colors.accent)
 
 	saveTagButton.SetSelectedFunc(func() {
-		tag, err = id3v2.Open(node.Path(), id3v2.Options{
-			Parse:       true,
-			ParseFrames: []string{},
-		})
+		tag, err = player.OpenTag(node.Path())
 		if err != nil {
 			errorPopup(err)
 			return
@@ -162,9 +177,19 @@ func tagPopup(node *player.AudioFile) (err error) {
 		newArtist := artistInputField.GetText()
 		newTitle := titleInputField.GetText()
 		newAlbum := albumInputField.GetText()
+		newYear := 0
+		if yearInputField.GetText() != "" {
+			newYear, err = strconv.Atoi(yearInputField.GetText())
+			if err != nil {
+				errorPopup(err)
+				return
+			}
+		}
 		tag.SetArtist(newArtist)
 		tag.SetTitle(newTitle)
 		tag.SetAlbum(newAlbum)
+		tag.SetGenre(genreInputField.GetText())
+		tag.SetYear(newYear)
 		err = tag.Save()
 		if err != nil {
 			errorPopup(err)
@@ -187,6 +212,9 @@ func tagPopup(node *player.AudioFile) (err error) {
 				return
 			}
 			node = gomu.playlist.getCurrentFile()
+		} else if gomu.playlist.view != browseDirectory {
+			// the song may have moved to another group
+			gomu.playlist.refresh()
 		}
 
 		defaultTimedPopup(" Success ", "Tag update successfully")
@@ -362,17 +390,19 @@ func tagPopup(node *player.AudioFile) (err error) {
 		})
 	})
 
-	leftGrid.SetRows(3, 1, 2, 2, 2, 3, 0, 3, 3, 1, 3, 3).
+	leftGrid.SetRows(3, 1, 2, 2, 2, 2, 2, 3, 0, 3, 3, 1, 3, 3).
 		SetColumns(30).
 		AddItem(getTagButton, 0, 0, 1, 3, 1, 10, true).
 		AddItem(artistInputField, 2, 0, 1, 3, 1, 10, true).
 		AddItem(titleInputField, 3, 0, 1, 3, 1, 10, true).
 		AddItem(albumInputField, 4, 0, 1, 3, 1, 10, true).
-		AddItem(saveTagButton, 5, 0, 1, 3, 1, 10, true).
-		AddItem(getLyricDropDown, 7, 0, 1, 3, 1, 20, true).
-		AddItem(getLyricButton, 8, 0, 1, 3, 1, 10, true).
-		AddItem(lyricDropDown, 10, 0, 1, 3, 1, 10, true).
-		AddItem(deleteLyricButton, 11, 0, 1, 3, 1, 10, true)
+		AddItem(genreInputField, 5, 0, 1, 3, 1, 10, true).
+		AddItem(yearInputField, 6, 0, 1, 3, 1, 10, true).
+		AddItem(saveTagButton, 7, 0, 1, 3, 1, 10, true).
+		AddItem(getLyricDropDown, 9, 0, 1, 3, 1, 20, true).
+		AddItem(getLyricButton, 10, 0, 1, 3, 1, 10, true).
+		AddItem(lyricDropDown, 12, 0, 1, 3, 1, 10, true).
+		AddItem(deleteLyricButton, 13, 0, 1, 3, 1, 10, true)
 
 	rightFlex.SetDirection(tview.FlexColumn).
 		AddItem(lyricTextView, 0, 1, true)
@@ -393,6 +423,8 @@ func tagPopup(node *player.AudioFile) (err error) {
 		artistInputField,
 		titleInputField,
 		albumInputField,
+		genreInputField,
+		yearInputField,
 		saveTagButton,
 		getLyricDropDown,
 		getLyricButton,
@@ -405,7 +437,7 @@ func tagPopup(node *player.AudioFile) (err error) {
 		gomu.playingBar.albumPhoto.Clear()
 	}
 
-	gomu.pages.AddPage(popupID, center(lyricFlex, 90, 30), true, true)
+	gomu.pages.AddPage(popupID, center(lyricFlex, 90, 34), true, true)
 	gomu.popups.push(lyricFlex)
 
 	lyricFlex.SetInputCapture(func(e *tcell.EventKey) *tcell.EventKey {
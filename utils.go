@@ -7,17 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/tramhao/id3v2"
 	"github.com/ztrue/tracerr"
 
 	"github.com/issadarkthing/gomu/lyric"
@@ -116,24 +113,13 @@ func expandTilde(_path string) string {
 	return path.Join(home, strings.TrimPrefix(_path, "~"))
 }
 
-// Detects the filetype of file
-func getFileContentType(out *os.File) (string, error) {
-
-	buffer := make([]byte, 512)
-
-	_, err := out.Read(buffer)
-	if err != nil {
-		return "", tracerr.Wrap(err)
-	}
-
-	contentType := http.DetectContentType(buffer)
-
-	return strings.SplitAfter(contentType, "/")[1], nil
-}
-
 // Gets the file name by removing extension and path
 func getName(fn string) string {
-	return strings.TrimSuffix(path.Base(fn), ".mp3")
+	base := path.Base(fn)
+	if player.HasSupportedExt(base) {
+		return strings.TrimSuffix(base, path.Ext(base))
+	}
+	return base
 }
 
 // This just parsing the output from the ytdl to get the audio path
@@ -248,59 +234,19 @@ func shell(input string) (string, error) {
 
 func embedLyric(songPath string, lyricTobeWritten *lyric.Lyric, isDelete bool) (err error) {
 
-	var tag *id3v2.Tag
-	tag, err = id3v2.Open(songPath, id3v2.Options{Parse: true})
+	tag, err := player.OpenTag(songPath)
 	if err != nil {
 		return tracerr.Wrap(err)
 	}
 	defer tag.Close()
-	usltFrames := tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
-	tag.DeleteFrames(tag.CommonID("Unsynchronised lyrics/text transcription"))
-	// We delete the lyric frame with same language by delete all and add others back
-	for _, f := range usltFrames {
-		uslf, ok := f.(id3v2.UnsynchronisedLyricsFrame)
-		if !ok {
-			die(errors.New("uslt error"))
-		}
-		if uslf.ContentDescriptor == lyricTobeWritten.LangExt {
-			continue
-		}
-		tag.AddUnsynchronisedLyricsFrame(uslf)
-	}
-	syltFrames := tag.GetFrames(tag.CommonID("Synchronised lyrics/text"))
-	tag.DeleteFrames(tag.CommonID("Synchronised lyrics/text"))
-	for _, f := range syltFrames {
-		sylf, ok := f.(id3v2.SynchronisedLyricsFrame)
-		if !ok {
-			die(errors.New("sylt error"))
-		}
-		if strings.Contains(sylf.ContentDescriptor, lyricTobeWritten.LangExt) {
-			continue
-		}
-		tag.AddSynchronisedLyricsFrame(sylf)
-	}
 
-	if !isDelete {
-		tag.AddUnsynchronisedLyricsFrame(id3v2.UnsynchronisedLyricsFrame{
-			Encoding:          id3v2.EncodingUTF8,
-			Language:          "eng",
-			ContentDescriptor: lyricTobeWritten.LangExt,
-			Lyrics:            lyricTobeWritten.AsLRC(),
-		})
-		var lyric lyric.Lyric
-		err := lyric.NewFromLRC(lyricTobeWritten.AsLRC())
+	if isDelete {
+		tag.DeleteLyric(lyricTobeWritten.LangExt)
+	} else {
+		err = tag.SetLyric(lyricTobeWritten.LangExt, lyricTobeWritten.AsLRC())
 		if err != nil {
 			return tracerr.Wrap(err)
 		}
-		tag.AddSynchronisedLyricsFrame(id3v2.SynchronisedLyricsFrame{
-			Encoding:          id3v2.EncodingUTF8,
-			Language:          "eng",
-			TimestampFormat:   2,
-			ContentType:       1,
-			ContentDescriptor: lyricTobeWritten.LangExt,
-			SynchronizedTexts: lyric.SyncedCaptions,
-		})
-
 	}
 
 	err = tag.Save()
@@ -313,7 +259,7 @@ func embedLyric(songPath string, lyricTobeWritten *lyric.Lyric, isDelete bool) (
 }
 
 func embedLength(songPath string) (time.Duration, error) {
-	tag, err := id3v2.Open(songPath, id3v2.Options{Parse: true})
+	tag, err := player.OpenTag(songPath)
 	if err != nil {
 		return 0, tracerr.Wrap(err)
 	}
@@ -325,13 +271,12 @@ func embedLength(songPath string) (time.Duration, error) {
 		return 0, tracerr.Wrap(err)
 	}
 
-	lengthSongString := strconv.FormatInt(lengthSongTimeDuration.Milliseconds(), 10)
-	lengthFrame := id3v2.UserDefinedTextFrame{
-		Encoding:    id3v2.EncodingUTF8,
-		Description: "TLEN",
-		Value:       lengthSongString,
+	tag.SetLength(lengthSongTimeDuration)
+
+	// formats which compute the length from their headers do not store it
+	if tag.Length() == 0 {
+		return lengthSongTimeDuration, nil
 	}
-	tag.AddUserDefinedTextFrame(lengthFrame)
 
 	err = tag.Save()
 	if err != nil {
@@ -341,38 +286,244 @@ func embedLength(songPath string) (time.Duration, error) {
 }
 
 func getTagLength(songPath string) (songLength time.Duration, err error) {
-	var tag *id3v2.Tag
-	tag, err = id3v2.Open(songPath, id3v2.Options{Parse: true})
+
+	tag, err := player.OpenTag(songPath)
+	if errors.Is(err, player.ErrTagNotSupported) {
+		return player.GetLength(songPath)
+	}
 	if err != nil {
 		return 0, tracerr.Wrap(err)
 	}
-	defer tag.Close()
-	tlenFrames := tag.GetFrames(tag.CommonID("User defined text information frame"))
-	if tlenFrames == nil {
-		songLength, err = embedLength(songPath)
+	songLength = tag.Length()
+	tag.Close()
+
+	if songLength != 0 {
+		return songLength, nil
+	}
+
+	songLength, err = embedLength(songPath)
+	if err != nil {
+		return 0, tracerr.Wrap(err)
+	}
+
+	return songLength, err
+}
+
+// Gets the playback speed from config file, 1 when it is not set
+func getSpeed() float64 {
+
+	speed := gomu.anko.GetFloat("General.speed")
+	if speed <= 0 {
+		return 1
+	}
+
+	return speed
+}
+
+// Gets crossfade duration from config file
+func getCrossfade() time.Duration {
+
+	dur := gomu.anko.GetString("General.crossfade")
+	if dur == "" {
+		return 0
+	}
+
+	m, err := time.ParseDuration(dur)
+	if err != nil {
+		logError(err)
+		return 0
+	}
+
+	return m
+}
+
+// Gets the output the songs of the player are played to from config file
+func getOutput(p *player.Player) (player.Output, error) {
+
+	outputPath := expandTilde(gomu.anko.GetString("General.output_path"))
+
+	switch output := gomu.anko.GetString("General.output"); output {
+	case "", "speaker":
+		return player.NewSpeaker(), nil
+
+	case "null":
+		return player.NewNullOutput(), nil
+
+	case "wav":
+		if outputPath == "" {
+			return nil, tracerr.New("the wav output needs output_path")
+		}
+		o, err := player.NewWavOutput(outputPath)
+		return o, tracerr.Wrap(err)
+
+	case "pcm":
+		if outputPath == "" {
+			// the tui draws on stdout
+			if gomu.args.daemon == nil || !*gomu.args.daemon {
+				return nil, tracerr.New("the pcm output needs output_path unless gomu runs as a daemon")
+			}
+			return player.NewPCMOutput(os.Stdout), nil
+		}
+		f, err := os.Create(outputPath)
 		if err != nil {
-			return 0, tracerr.Wrap(err)
+			return nil, tracerr.Wrap(err)
 		}
-		return songLength, nil
+		return player.NewPCMOutput(f), nil
+
+	case "stream":
+		o, err := player.NewStreamOutput(player.StreamConfig{
+			Addr:   gomu.anko.GetString("General.stream_address"),
+			Format: gomu.anko.GetString("General.stream_format"),
+			Name:   "gomu",
+			Title: func() string {
+				// the title sent by the station of a stream being played
+				if title := p.StreamTitle(); title != "" {
+					return title
+				}
+				return songTitle(p.GetCurrentSong())
+			},
+		})
+		return o, tracerr.Wrap(err)
+
+	default:
+		return nil, tracerr.Errorf("unknown output %q", output)
+	}
+}
+
+// Gets "artist - title" of the song from its tags or its name otherwise
+func songTitle(song player.Audio) string {
+
+	if song == nil {
+		return ""
 	}
-	for _, tlenFrame := range tlenFrames {
-		if tlenFrame.(id3v2.UserDefinedTextFrame).Description == "TLEN" {
-			songLengthString := tlenFrame.(id3v2.UserDefinedTextFrame).Value
-			songLengthInt64, err := strconv.ParseInt(songLengthString, 10, 64)
-			if err != nil {
-				return 0, tracerr.Wrap(err)
+
+	tag, err := player.OpenTag(song.Path())
+	if err != nil {
+		return song.Name()
+	}
+	defer tag.Close()
+
+	if tag.Artist() == "" || tag.Title() == "" {
+		return song.Name()
+	}
+
+	return tag.Artist() + " - " + tag.Title()
+}
+
+// Gets the sample rate of the output from config file
+func getSampleRate() int {
+
+	rate := gomu.anko.GetInt("General.sample_rate")
+	if rate <= 0 {
+		return 48000
+	}
+
+	return rate
+}
+
+// Gets the duration buffered by the output from config file
+func getBufferSize() time.Duration {
+
+	ms := gomu.anko.GetInt("General.buffer_size")
+	if ms <= 0 {
+		return 100 * time.Millisecond
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Gets replaygain mode from config file
+func getReplayGainMode() player.ReplayGainMode {
+
+	mode, err := player.ParseReplayGainMode(gomu.anko.GetString("General.replaygain"))
+	if err != nil {
+		logError(err)
+	}
+
+	return mode
+}
+
+// scanReplayGain computes replaygain of the song, or of every song in the
+// directory as an album, if the tags are missing. It returns the number of
+// songs scanned.
+func scanReplayGain(audioFile *player.AudioFile) (int, error) {
+
+	var paths []string
+	album := !audioFile.IsAudioFile()
+
+	if album {
+		for _, child := range audioFile.Node().GetChildren() {
+			a := child.GetReference().(*player.AudioFile)
+			if a.IsAudioFile() {
+				paths = append(paths, a.Path())
 			}
-			songLength = (time.Duration)(songLengthInt64) * time.Millisecond
-			break
 		}
+	} else {
+		paths = append(paths, audioFile.Path())
 	}
-	if songLength != 0 {
-		return songLength, nil
+
+	missing := false
+
+	for _, path := range paths {
+		tag, err := player.OpenTag(path)
+		if err != nil {
+			continue
+		}
+		rg := player.ReadReplayGain(tag)
+		tag.Close()
+		if !rg.HasTrack || (album && !rg.HasAlbum) {
+			missing = true
+		}
 	}
-	songLength, err = embedLength(songPath)
+
+	if !missing {
+		return 0, nil
+	}
+
+	gains, err := player.ScanReplayGain(paths)
 	if err != nil {
 		return 0, tracerr.Wrap(err)
 	}
 
-	return songLength, err
+	for i, path := range paths {
+
+		// a single song has no album
+		gains[i].HasAlbum = album
+
+		tag, err := player.OpenTag(path)
+		if errors.Is(err, player.ErrTagNotSupported) {
+			continue
+		}
+		if err != nil {
+			return 0, tracerr.Wrap(err)
+		}
+
+		player.WriteReplayGain(tag, gains[i])
+
+		err = tag.Save()
+		tag.Close()
+		if err != nil {
+			return 0, tracerr.Wrap(err)
+		}
+	}
+
+	return len(paths), nil
+}
+
+// Gets the music directory from the args or the config file
+func getMusicDir(args Args) string {
+
+	m := gomu.anko.GetString("General.music_dir")
+	rootDir, err := filepath.Abs(expandTilde(m))
+	if err != nil {
+		err = tracerr.Errorf("unable to find music directory: %e", err)
+		die(err)
+	}
+
+	// if not default value was given
+	if *args.music != "~/music" {
+		rootDir = expandFilePath(*args.music)
+	}
+
+	return rootDir
 }
@@ -40,6 +40,9 @@ func TestGetName(t *testing.T) {
 		"~/music/fl.mp3":                           "fl",
 		"/home/terra/Music/pop/hola na.mp3":        "hola na",
 		"~/macklemary - (ft jello) extreme!! .mp3": "macklemary - (ft jello) extreme!! ",
+		"/home/terra/Music/jazz/so what.flac":      "so what",
+		"~/music/live.OGG":                         "live",
+		"~/music/v1.2 sessions":                    "v1.2 sessions",
 	}
 
 	for k, v := range samples {
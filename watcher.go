@@ -0,0 +1,299 @@
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"github.com/issadarkthing/gomu/player"
+)
+
+// fsEventOp is the kind of change reported by fsWatcher
+type fsEventOp int
+
+const (
+	fsCreate fsEventOp = iota
+	fsRemove
+	fsRename
+	// events were lost, the whole tree has to be refreshed
+	fsRescan
+)
+
+// fsEvent is a change in the watched directory tree. A created file may
+// already exist when it has been rewritten.
+type fsEvent struct {
+	op   fsEventOp
+	path string
+	// path before a rename
+	oldPath string
+	isDir   bool
+}
+
+// Checks whether path is root or is inside of it
+func isUnder(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// Applies the changes reported by the watcher to the tree until the watcher
+// is closed
+func (p *Playlist) watch(w *fsWatcher) {
+	for e := range w.events {
+		e := e
+		gomu.app.QueueUpdateDraw(func() {
+			p.applyFsEvent(e)
+		})
+	}
+}
+
+// Updates the tree, the library and the queue after a change in the music
+// directory. The expansion state and the highlighted node are kept.
+func (p *Playlist) applyFsEvent(e fsEvent) {
+
+	sortMtime := gomu.anko.GetBool("General.sort_by_mtime")
+	current := p.GetCurrentNode()
+
+	switch e.op {
+	case fsRescan:
+		p.refresh()
+		gomu.queue.updateQueuePath()
+
+	case fsCreate:
+		p.addPath(e.path, sortMtime)
+
+	case fsRemove:
+		removed := p.removePath(e.path)
+		gomu.library.remove(e.path)
+
+		if removed != nil && containsNode(removed, current) {
+			p.setHighlight(removed.GetReference().(*player.AudioFile).ParentNode())
+		}
+
+		if gomu.queue.hasPathUnder(e.path) {
+			gomu.queue.updateQueuePath()
+		}
+
+	case fsRename:
+		removed := p.removePath(e.oldPath)
+		gomu.library.rename(e.oldPath, e.path)
+		gomu.stats.rename(e.oldPath, e.path)
+		if err := gomu.bookmarks.rename(e.oldPath, e.path); err != nil {
+			logError(err)
+		}
+		added := p.addPath(e.path, sortMtime)
+
+		if removed != nil && added != nil {
+			copyExpansion(removed, added, e.oldPath, e.path)
+		}
+
+		if removed != nil && containsNode(removed, current) {
+			currentPath := current.GetReference().(*player.AudioFile).Path()
+			if node := p.findNode(e.path + strings.TrimPrefix(currentPath, e.oldPath)); node != nil {
+				p.setHighlight(node)
+			} else if added != nil {
+				p.setHighlight(added)
+			} else {
+				p.setHighlight(removed.GetReference().(*player.AudioFile).ParentNode())
+			}
+		}
+
+		if gomu.queue.hasPathUnder(e.oldPath) {
+			gomu.queue.updateQueuePath(pathRename{e.oldPath, e.path})
+		}
+	}
+
+	// the directory tree has been refreshed already
+	if p.view != browseDirectory && e.op != fsRescan {
+		// an empty tree has no current node
+		currentPath := ""
+		if current != nil {
+			currentPath = current.GetReference().(*player.AudioFile).Path()
+		}
+		if e.op == fsRename && isUnder(e.oldPath, currentPath) {
+			currentPath = e.path + strings.TrimPrefix(currentPath, e.oldPath)
+		}
+		p.rebuildBrowse(currentPath)
+	}
+
+	// new songs are inserted by name
+	if p.view == browseDirectory && e.op != fsRescan {
+		sortTreeSongs(p.GetRoot(), p.sortOrder)
+	}
+}
+
+// Finds the node of the file or the directory, songs of playlist files are
+// not looked into
+func (p *Playlist) findNode(path string) *tview.TreeNode {
+
+	root := p.directoryRoot()
+	rootPath := root.GetReference().(*player.AudioFile).Path()
+
+	// the music directory may be a symlink
+	if realPath, err := filepath.EvalSymlinks(rootPath); err == nil && path == realPath {
+		return root
+	}
+	if path == rootPath {
+		return root
+	}
+
+	var found *tview.TreeNode
+
+	root.Walk(func(node, _ *tview.TreeNode) bool {
+
+		if found != nil {
+			return false
+		}
+
+		if node == root {
+			return true
+		}
+
+		audioFile := node.GetReference().(*player.AudioFile)
+
+		if audioFile.Path() == path {
+			found = node
+			return false
+		}
+
+		return !audioFile.IsAudioFile() && !isPlaylistFile(audioFile.Path()) &&
+			isUnder(audioFile.Path(), path)
+	})
+
+	return found
+}
+
+// Adds the node of a created file or directory at its sorted position. A
+// file which is already shown is replaced as it has been rewritten.
+func (p *Playlist) addPath(path string, sortMtime bool) *tview.TreeNode {
+
+	parent := p.findNode(filepath.Dir(path))
+	if parent == nil {
+		return nil
+	}
+
+	// it may have been removed in the meantime
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil
+	}
+
+	var existing *tview.TreeNode
+	for _, child := range parent.GetChildren() {
+		if child.GetReference().(*player.AudioFile).Path() == realPath {
+			existing = child
+		}
+	}
+
+	if existing != nil && info.IsDir() {
+		return existing
+	}
+
+	node := populateFile(parent, realPath, info, sortMtime)
+
+	children := parent.GetChildren()
+
+	if node == nil {
+		if existing != nil {
+			parent.RemoveChild(existing)
+		}
+		return nil
+	}
+
+	// the node has been added last
+	children = children[:len(children)-1]
+
+	if existing != nil {
+		for i, child := range children {
+			if child == existing {
+				children[i] = node
+			}
+		}
+		if p.GetCurrentNode() == existing {
+			p.setHighlight(node)
+		}
+		parent.SetChildren(children)
+		return node
+	}
+
+	// the newest file is first when sorted by modification time
+	index := 0
+	if !sortMtime {
+		name := filepath.Base(path)
+		index = sort.Search(len(children), func(i int) bool {
+			childPath := children[i].GetReference().(*player.AudioFile).Path()
+			return filepath.Base(childPath) > name
+		})
+	}
+
+	children = append(children, nil)
+	copy(children[index+1:], children[index:])
+	children[index] = node
+	parent.SetChildren(children)
+
+	return node
+}
+
+// Removes the node of the file or the directory, returns the removed node
+// or nil if it was not shown
+func (p *Playlist) removePath(path string) *tview.TreeNode {
+
+	node := p.findNode(path)
+	if node == nil || node == p.directoryRoot() {
+		return nil
+	}
+
+	parent := node.GetReference().(*player.AudioFile).ParentNode()
+	if parent == nil {
+		return nil
+	}
+
+	parent.RemoveChild(node)
+
+	return node
+}
+
+// Checks whether the node is root or one of its descendants
+func containsNode(root, node *tview.TreeNode) bool {
+
+	found := false
+
+	root.Walk(func(n, _ *tview.TreeNode) bool {
+		if n == node {
+			found = true
+		}
+		return !found
+	})
+
+	return found
+}
+
+// Copies the expansion state of the nodes of a moved directory
+func copyExpansion(from, to *tview.TreeNode, fromPath, toPath string) {
+
+	expanded := make(map[string]bool)
+
+	from.Walk(func(node, _ *tview.TreeNode) bool {
+		path := node.GetReference().(*player.AudioFile).Path()
+		if isUnder(fromPath, path) {
+			expanded[strings.TrimPrefix(path, fromPath)] = node.IsExpanded()
+		}
+		return true
+	})
+
+	to.Walk(func(node, _ *tview.TreeNode) bool {
+		path := node.GetReference().(*player.AudioFile).Path()
+		if e, ok := expanded[strings.TrimPrefix(path, toPath)]; ok && isUnder(toPath, path) {
+			node.SetExpanded(e)
+		}
+		return true
+	})
+}
@@ -0,0 +1,241 @@
+//go:build linux
+// +build linux
+
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/ztrue/tracerr"
+)
+
+// events of the watched directories, files are reported once they are
+// written rather than when they are created
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// fsWatcher reports the changes in a directory tree using inotify
+type fsWatcher struct {
+	// Fd of file must not be called as it would make reads blocking
+	fd     int
+	file   *os.File
+	events chan fsEvent
+
+	mu sync.Mutex
+	// watched directory of every watch descriptor
+	dirs map[int32]string
+}
+
+// Watches the directory and all of its subdirectories
+func newFsWatcher(root string) (*fsWatcher, error) {
+
+	// the paths in the playlist have their symlinks evaluated
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, tracerr.Wrap(err)
+	}
+
+	w := &fsWatcher{
+		fd: fd,
+		// non blocking so that reading is interrupted by close
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan fsEvent, 64),
+		dirs:   make(map[int32]string),
+	}
+
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, tracerr.Wrap(err)
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Adds a watch to the directory and its subdirectories
+func (w *fsWatcher) addTree(root string) error {
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+
+		// the directory may have been removed in the meantime
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			logError(tracerr.Errorf("unable to watch %s: %v", path, err))
+			return nil
+		}
+
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+
+		return nil
+	})
+}
+
+// Removes the watches of the directory and its subdirectories
+func (w *fsWatcher) removeTree(root string, unwatch bool) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wd, dir := range w.dirs {
+		if isUnder(root, dir) {
+			if unwatch {
+				syscall.InotifyRmWatch(w.fd, uint32(wd))
+			}
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// Updates the paths of the watched directories after their parent has
+// been moved
+func (w *fsWatcher) moveTree(oldRoot, newRoot string) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for wd, dir := range w.dirs {
+		if isUnder(oldRoot, dir) {
+			w.dirs[wd] = newRoot + strings.TrimPrefix(dir, oldRoot)
+		}
+	}
+}
+
+func (w *fsWatcher) dir(wd int32) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.dirs[wd]
+	return dir, ok
+}
+
+// Reads the inotify events until the watcher is closed
+func (w *fsWatcher) run() {
+
+	defer close(w.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// moves are reported as a pair of events sharing a cookie, a move
+		// out of the tree has no pair
+		movedFrom := make(map[uint32]fsEvent)
+		var order []uint32
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.events <- fsEvent{op: fsRescan}
+				continue
+			}
+
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				w.mu.Lock()
+				delete(w.dirs, raw.Wd)
+				w.mu.Unlock()
+				continue
+			}
+
+			dir, ok := w.dir(raw.Wd)
+			if !ok || name == "" {
+				continue
+			}
+
+			e := fsEvent{
+				path:  filepath.Join(dir, name),
+				isDir: raw.Mask&syscall.IN_ISDIR != 0,
+			}
+
+			switch {
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				e.op = fsRemove
+				movedFrom[raw.Cookie] = e
+				order = append(order, raw.Cookie)
+				continue
+
+			case raw.Mask&syscall.IN_MOVED_TO != 0:
+				if from, ok := movedFrom[raw.Cookie]; ok {
+					delete(movedFrom, raw.Cookie)
+					e.op = fsRename
+					e.oldPath = from.path
+					if e.isDir {
+						w.moveTree(from.path, e.path)
+					}
+				} else {
+					e.op = fsCreate
+					if e.isDir {
+						w.addTree(e.path)
+					}
+				}
+
+			case raw.Mask&syscall.IN_CREATE != 0:
+				// files are reported once they are written
+				if !e.isDir {
+					continue
+				}
+				e.op = fsCreate
+				w.addTree(e.path)
+
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				e.op = fsCreate
+
+			case raw.Mask&syscall.IN_DELETE != 0:
+				e.op = fsRemove
+
+			default:
+				continue
+			}
+
+			w.events <- e
+		}
+
+		for _, cookie := range order {
+			if e, ok := movedFrom[cookie]; ok {
+				if e.isDir {
+					w.removeTree(e.path, true)
+				}
+				w.events <- e
+			}
+		}
+	}
+}
+
+// Stops watching, the events channel is closed afterwards
+func (w *fsWatcher) close() error {
+	return tracerr.Wrap(w.file.Close())
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Waits for the next event of the watcher
+func nextFsEvent(t *testing.T, w *fsWatcher) fsEvent {
+	t.Helper()
+	select {
+	case e := <-w.events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return fsEvent{}
+}
+
+func TestFsWatcher(t *testing.T) {
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newFsWatcher(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	dir := filepath.Join(root, "album")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextFsEvent(t, w); e.op != fsCreate || e.path != dir || !e.isDir {
+		t.Errorf("Expected creation of %s; got %+v", dir, e)
+	}
+
+	// files in new directories are watched as well
+	song := filepath.Join(dir, "song.mp3")
+	if err := ioutil.WriteFile(song, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextFsEvent(t, w); e.op != fsCreate || e.path != song || e.isDir {
+		t.Errorf("Expected creation of %s; got %+v", song, e)
+	}
+
+	renamed := filepath.Join(root, "renamed")
+	if err := os.Rename(dir, renamed); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextFsEvent(t, w); e.op != fsRename || e.oldPath != dir || e.path != renamed {
+		t.Errorf("Expected rename of %s to %s; got %+v", dir, renamed, e)
+	}
+
+	// the watches follow renamed directories
+	movedSong := filepath.Join(renamed, "song.mp3")
+	if err := os.Remove(movedSong); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextFsEvent(t, w); e.op != fsRemove || e.path != movedSong {
+		t.Errorf("Expected removal of %s; got %+v", movedSong, e)
+	}
+
+	// moving out of the tree is a removal
+	if err := os.Rename(renamed, filepath.Join(t.TempDir(), "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := nextFsEvent(t, w); e.op != fsRemove || e.path != renamed {
+		t.Errorf("Expected removal of %s; got %+v", renamed, e)
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events are closed along with the watcher
+	for range w.events {
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+// Copyright (C) 2020  Raziman
+
+package main
+
+import (
+	"github.com/ztrue/tracerr"
+)
+
+// fsWatcher is only implemented with inotify
+type fsWatcher struct {
+	events chan fsEvent
+}
+
+func newFsWatcher(root string) (*fsWatcher, error) {
+	return nil, tracerr.New("watching the music directory is not supported on this platform")
+}
+
+func (w *fsWatcher) close() error {
+	return nil
+}